@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/models"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the binary. run returns the process exit
+// code, 2 for usage errors.
+type command struct {
+	usage string
+	run   func(c *cli, args []string) int
+}
+
+var commands = map[string]command{
+	"serve":   {"serve", runServe},
+	"migrate": {migrateUsage, runMigrate},
+	"user":    {userUsage, runUser},
+	"cache":   {cacheUsage, runCache},
+	"tasks":   {tasksUsage, runTasks},
+	"token":   {tokenUsage, runToken},
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-task [-config file] [-output table|json] [command]")
+	fmt.Fprintln(w, "commands, serve when none is given:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(w, "  "+strings.TrimPrefix(commands[name].usage, "usage: "))
+	}
+}
+
+// cli is what every command gets: the loaded config, the logger and the
+// output format chosen with -output.
+type cli struct {
+	cfg    *config.Config
+	logger *logrus.Logger
+	output string
+	addr   string
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// usageError prints usage to stderr and returns the exit code for it.
+func (c *cli) usageError(usage string) int {
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// fail logs err and returns the exit code for it.
+func (c *cli) fail(message string, err error) int {
+	c.logger.Error(message+" : ", err)
+	return 1
+}
+
+// openModels connects to the database and Redis the way serve does. The
+// returned func closes both.
+func (c *cli) openModels() (*models.Init, func(), error) {
+	db, err := openDBORM(c.cfg.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := InitRedis(c.cfg.Redis)
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+
+	return models.Constructor(c.cfg, db, client, c.logger), func() {
+		client.Close()
+		sqlDB.Close()
+	}, nil
+}
+
+// print writes v as indented JSON with -output json, otherwise table writes
+// it to a tabwriter.
+func (c *cli) print(v any, table func(w io.Writer)) {
+	if c.output == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	w.Flush()
+}
+
+// parseFlags parses args into fs and checks the number of positional
+// arguments left.
+func parseFlags(fs *flag.FlagSet, args []string, positional int) bool {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+
+	return fs.NArg() == positional
+}
+
+// readPassword takes the first line of r. Passwords are never passed as
+// arguments, those end up in shell history and process listings.
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+const passwordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// generatePassword returns a random password that passes
+// pkg.Validator.ValidPassword.
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+		if err != nil {
+			return "", err
+		}
+
+		b[i] = passwordAlphabet[n.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// validationError joins the field errors of v into one error.
+func validationError(v *pkg.Validator) error {
+	fields := make([]string, 0, len(v.Errors))
+	for field, message := range v.Errors {
+		fields = append(fields, field+": "+message)
+	}
+
+	sort.Strings(fields)
+	return errors.New(strings.Join(fields, ", "))
+}
+
+const userUsage = "usage: user create [-role user|admin] [-active] [-password-stdin] <email> | activate <email> | deactivate <email> | set-password [-password-stdin] <email> | set-role <email> <user|admin>"
+
+// userView is a user as the user command prints it. Password is only set
+// when it was generated.
+type userView struct {
+	ID       uint   `json:"id"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Active   bool   `json:"active"`
+	Password string `json:"password,omitempty"`
+}
+
+func (c *cli) printUser(user *models.User, password string) {
+	view := userView{ID: user.ID, Email: user.Email, Role: user.Role, Active: user.Active, Password: password}
+	c.print(view, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tEMAIL\tROLE\tACTIVE")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", view.ID, view.Email, view.Role, view.Active)
+		if password != "" {
+			fmt.Fprintf(w, "\ngenerated password: %s\n", password)
+		}
+	})
+}
+
+func runUser(c *cli, args []string) int {
+	if len(args) == 0 {
+		return c.usageError(userUsage)
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	var role *string
+	var active, passwordStdin *bool
+	positional := 1
+	switch args[0] {
+	case "create":
+		role = fs.String("role", models.RoleUser, "user or admin")
+		active = fs.Bool("active", false, "activate the account right away")
+		passwordStdin = fs.Bool("password-stdin", false, "read the password from stdin")
+	case "set-password":
+		passwordStdin = fs.Bool("password-stdin", false, "read the password from stdin")
+	case "set-role":
+		positional = 2
+	case "activate", "deactivate":
+	default:
+		return c.usageError(userUsage)
+	}
+
+	if !parseFlags(fs, args[1:], positional) {
+		return c.usageError(userUsage)
+	}
+
+	email := strings.TrimSpace(fs.Arg(0))
+	if args[0] == "set-role" {
+		newRole := fs.Arg(1)
+		role = &newRole
+	}
+
+	if role != nil && !models.ValidRole(*role) {
+		return c.usageError(userUsage)
+	}
+
+	var password, generated string
+	if passwordStdin != nil {
+		var err error
+		if *passwordStdin {
+			password, err = readPassword(c.stdin)
+		} else {
+			password, err = generatePassword()
+			generated = password
+		}
+
+		if err != nil {
+			return c.fail("Reading the password failed", err)
+		}
+	}
+
+	m, closeModels, err := c.openModels()
+	if err != nil {
+		return c.fail("Error creating connections", err)
+	}
+
+	defer closeModels()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if args[0] == "create" {
+		creds := &models.UserStruct{Email: email, Passw: password, RepeatPassw: password}
+		if v := m.Users.ValidateUserData(creds, true); !v.Valid() {
+			return c.fail("Invalid user", validationError(v))
+		}
+
+		if err := m.Users.RegisterUser(ctx, email, password, "cli"); err != nil {
+			return c.fail("Creating the user failed", err)
+		}
+	}
+
+	user, err := m.Users.UserByEmail(ctx, email)
+	if errors.Is(err, pkg.ErrNoRecord) {
+		return c.fail("Unknown user", fmt.Errorf("nobody has the email %q", email))
+	}
+
+	if err != nil {
+		return c.fail("Looking up the user failed", err)
+	}
+
+	switch args[0] {
+	case "create":
+		if *role != models.RoleUser {
+			err = m.Users.SetRole(ctx, user.ID, *role)
+		}
+
+		if err == nil && *active {
+			err = m.Users.SetActive(ctx, user.ID, true)
+		}
+	case "activate", "deactivate":
+		err = m.Users.SetActive(ctx, user.ID, args[0] == "activate")
+	case "set-password":
+		creds := &models.UserStruct{Email: email, Passw: password}
+		if v := m.Users.ValidateUserData(creds, false); !v.Valid() {
+			return c.fail("Invalid password", validationError(v))
+		}
+
+		err = m.Users.SetPassword(ctx, user.ID, password)
+	case "set-role":
+		err = m.Users.SetRole(ctx, user.ID, *role)
+	}
+
+	if err != nil {
+		return c.fail("Updating the user failed", err)
+	}
+
+	if user, err = m.Users.UserByEmail(ctx, email); err != nil {
+		return c.fail("Looking up the user failed", err)
+	}
+
+	c.printUser(user, generated)
+	return 0
+}
+
+const cacheUsage = "usage: cache flush [-listings] | stats"
+
+func runCache(c *cli, args []string) int {
+	if len(args) == 0 {
+		return c.usageError(cacheUsage)
+	}
+
+	fs := flag.NewFlagSet("cache "+args[0], flag.ContinueOnError)
+	var listings *bool
+	switch args[0] {
+	case "flush":
+		listings = fs.Bool("listings", false, "only drop the cached task listings")
+	case "stats":
+	default:
+		return c.usageError(cacheUsage)
+	}
+
+	if !parseFlags(fs, args[1:], 0) {
+		return c.usageError(cacheUsage)
+	}
+
+	m, closeModels, err := c.openModels()
+	if err != nil {
+		return c.fail("Error creating connections", err)
+	}
+
+	defer closeModels()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if args[0] == "flush" {
+		flush := m.Cache.Clear
+		if *listings {
+			flush = func(ctx context.Context) error { return invalidateAllListings(ctx, m) }
+		}
+
+		if err := flush(ctx); err != nil {
+			return c.fail("Flushing the cache failed", err)
+		}
+
+		c.logger.Info("Cache flushed")
+		return 0
+	}
+
+	stats, err := m.Cache.Stats(ctx)
+	if err != nil {
+		return c.fail("Reading cache stats failed", err)
+	}
+
+	c.print(stats, func(w io.Writer) {
+		kinds := make([]string, 0, len(stats.Keys))
+		for kind := range stats.Keys {
+			kinds = append(kinds, kind)
+		}
+
+		sort.Strings(kinds)
+		fmt.Fprintln(w, "STAT\tVALUE")
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "keys %s\t%d\n", kind, stats.Keys[kind])
+		}
+
+		fmt.Fprintf(w, "hits\t%d\n", stats.Hits)
+		fmt.Fprintf(w, "misses\t%d\n", stats.Misses)
+		fmt.Fprintf(w, "used memory bytes\t%d\n", stats.UsedMemory)
+	})
+
+	return 0
+}
+
+// invalidateAllListings bumps the listing generation of the personal tasks
+// and of every workspace.
+func invalidateAllListings(ctx context.Context, m *models.Init) error {
+	workspaceIDs, err := m.Workspaces.WorkspaceIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, workspaceID := range append([]uint{0}, workspaceIDs...) {
+		if err := models.InvalidateListings(ctx, m.Cache, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const tasksUsage = "usage: tasks purge-deleted [-older-than duration]"
+
+func runTasks(c *cli, args []string) int {
+	if len(args) == 0 || args[0] != "purge-deleted" {
+		return c.usageError(tasksUsage)
+	}
+
+	fs := flag.NewFlagSet("tasks purge-deleted", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "keep tasks deleted more recently")
+	if !parseFlags(fs, args[1:], 0) || *olderThan < 0 {
+		return c.usageError(tasksUsage)
+	}
+
+	m, closeModels, err := c.openModels()
+	if err != nil {
+		return c.fail("Error creating connections", err)
+	}
+
+	defer closeModels()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	purged, err := m.Tasks.PurgeDeleted(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return c.fail("Purging deleted tasks failed", err)
+	}
+
+	c.print(map[string]int64{"purged": purged}, func(w io.Writer) {
+		fmt.Fprintf(w, "purged %d tasks\n", purged)
+	})
+
+	return 0
+}
+
+const tokenUsage = "usage: token issue [-name name] [-scopes tasks:read,tasks:write] [-expires-in-days n] <email>"
+
+// tokenView is an issued API token with its plain value, which can't be
+// looked up again.
+type tokenView struct {
+	*models.APIToken
+	Token string `json:"token"`
+}
+
+func runToken(c *cli, args []string) int {
+	if len(args) == 0 || args[0] != "issue" {
+		return c.usageError(tokenUsage)
+	}
+
+	fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	name := fs.String("name", "cli", "name to recognise the token by")
+	scopes := fs.String("scopes", models.ScopeTasksRead+","+models.ScopeTasksWrite, "comma separated scopes")
+	expiresInDays := fs.Int("expires-in-days", 90, "0 for a token that never expires")
+	if !parseFlags(fs, args[1:], 1) {
+		return c.usageError(tokenUsage)
+	}
+
+	m, closeModels, err := c.openModels()
+	if err != nil {
+		return c.fail("Error creating connections", err)
+	}
+
+	defer closeModels()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	input := &models.APITokenStruct{Name: *name, Scopes: strings.Split(*scopes, ","), ExpiresInDays: *expiresInDays}
+	if v := m.UsersORM.ValidateAPITokenData(input); !v.Valid() {
+		return c.fail("Invalid token", validationError(v))
+	}
+
+	user, err := m.Users.UserByEmail(ctx, fs.Arg(0))
+	if errors.Is(err, pkg.ErrNoRecord) {
+		return c.fail("Unknown user", fmt.Errorf("nobody has the email %q", fs.Arg(0)))
+	}
+
+	if err != nil {
+		return c.fail("Looking up the user failed", err)
+	}
+
+	plain, token, err := m.UsersORM.CreateAPIToken(ctx, user.ID, input)
+	if err != nil {
+		return c.fail("Issuing the token failed", err)
+	}
+
+	c.print(tokenView{APIToken: token, Token: plain}, func(w io.Writer) {
+		expires := "never"
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Local().Format(time.RFC3339)
+		}
+
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tEXPIRES\tTOKEN")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", token.ID, token.Name, strings.Join(token.Scopes, ","), expires, plain)
+	})
+
+	return 0
+}
@@ -0,0 +1,381 @@
+// Package config loads the settings of the API once at startup. Values come
+// from, in increasing order of precedence, the defaults below, an optional
+// YAML or TOML file, a .env file and the process environment.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	App       AppConfig       `yaml:"app"`
+	Log       LogConfig       `yaml:"log"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	DB        DBConfig        `yaml:"db"`
+	Redis     RedisConfig     `yaml:"redis"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Mail      MailConfig      `yaml:"mail"`
+	OIDC      OIDCConfig      `yaml:"oidc"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+}
+
+type AppConfig struct {
+	Env          string `yaml:"env" env:"APP_ENV"`
+	URL          string `yaml:"url" env:"APP_URL"`
+	ServerStatus string `yaml:"server_status" env:"SERVER_STATUS"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// Format is json, or text for reading logs in a terminal
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+type HTTPConfig struct {
+	Port           int           `yaml:"port" env:"PORT"`
+	ReadTimeout    time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout   time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"HTTP_REQUEST_TIMEOUT"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after SIGINT/SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+	// DrainDelay is how long /readyz fails before the listener closes
+	DrainDelay     time.Duration `yaml:"drain_delay" env:"HTTP_DRAIN_DELAY"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES"`
+}
+
+// DBConfig selects the database. For sqlite Database is the file, or
+// :memory:, and the server settings are ignored.
+type DBConfig struct {
+	Driver   string `yaml:"driver" env:"DB_DRIVER"`
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	Database string `yaml:"database" env:"DB_DATABASE" required:"true"`
+	Username string `yaml:"username" env:"DB_USERNAME"`
+	Password string `yaml:"password" env:"DB_PASSWORD"`
+	Params   string `yaml:"params" env:"DB_PARAMS"`
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+// DSN is the data source name in the format of the driver.
+func (c DBConfig) DSN() string {
+	switch c.Driver {
+	case "postgres":
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(c.Username, c.Password),
+			Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+			Path:     "/" + c.Database,
+			RawQuery: c.Params,
+		}
+
+		return dsn.String()
+	case "sqlite":
+		if c.Params == "" {
+			return c.Database
+		}
+
+		return c.Database + "?" + c.Params
+	default:
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", c.Username, c.Password, c.Host, c.Port, c.Database, c.Params)
+	}
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+	// ConnectRetries is how often the startup ping is retried before the
+	// server starts without a cache
+	ConnectRetries int `yaml:"connect_retries" env:"REDIS_CONNECT_RETRIES"`
+}
+
+type JWTConfig struct {
+	Algorithm   string        `yaml:"algorithm" env:"JWT_ALGORITHM"`
+	Issuer      string        `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience    string        `yaml:"audience" env:"JWT_AUDIENCE"`
+	KeyRotation time.Duration `yaml:"key_rotation" env:"JWT_KEY_ROTATION"`
+	SessionTTL  time.Duration `yaml:"session_ttl" env:"JWT_SESSION_TTL"`
+	// KeyEncryptionKey is 32 base64 encoded bytes the private signing keys
+	// are encrypted with in the database. Only development runs without.
+	KeyEncryptionKey string `yaml:"key_encryption_key" env:"JWT_KEY_ENCRYPTION_KEY"`
+}
+
+// RateLimit allows Limit requests per Period, Burst of them back to back.
+type RateLimit struct {
+	Limit  int           `yaml:"limit" env:"LIMIT"`
+	Period time.Duration `yaml:"period" env:"PERIOD"`
+	Burst  int           `yaml:"burst" env:"BURST"`
+}
+
+type RateLimitConfig struct {
+	Login RateLimit `yaml:"login" env:"RATE_LIMIT_LOGIN_"`
+	Write RateLimit `yaml:"write" env:"RATE_LIMIT_WRITE_"`
+	Read  RateLimit `yaml:"read" env:"RATE_LIMIT_READ_"`
+}
+
+type CacheConfig struct {
+	TaskTTL    time.Duration `yaml:"task_ttl" env:"CACHE_TASK_TTL"`
+	ListingTTL time.Duration `yaml:"listing_ttl" env:"CACHE_LISTING_TTL"`
+	// NotFoundTTL is how long a task that doesn't exist is remembered
+	NotFoundTTL time.Duration `yaml:"not_found_ttl" env:"CACHE_NOT_FOUND_TTL"`
+	// StaleTTL is how long after its TTL an entry is still served while
+	// it is refreshed in the background, 0 turns that off
+	StaleTTL time.Duration `yaml:"stale_ttl" env:"CACHE_STALE_TTL"`
+	// EarlyRefreshBeta scales how early hot entries are refreshed before
+	// they expire, 0 turns that off
+	EarlyRefreshBeta float64 `yaml:"early_refresh_beta" env:"CACHE_EARLY_REFRESH_BETA"`
+	// BreakerFailures errors in a row keep calls away from the cache for
+	// BreakerCooldown
+	BreakerFailures int           `yaml:"breaker_failures" env:"CACHE_BREAKER_FAILURES"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown" env:"CACHE_BREAKER_COOLDOWN"`
+	// Backend is redis, memory (this process only) or tiered, an
+	// in-process LRU of LocalMaxMB in front of Redis. Local copies live
+	// for LocalTTL at most.
+	Backend    string        `yaml:"backend" env:"CACHE_BACKEND"`
+	LocalMaxMB int           `yaml:"local_max_mb" env:"CACHE_LOCAL_MAX_MB"`
+	LocalTTL   time.Duration `yaml:"local_ttl" env:"CACHE_LOCAL_TTL"`
+}
+
+type MailConfig struct {
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+}
+
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER"`
+	ProviderName string `yaml:"provider_name" env:"OIDC_PROVIDER_NAME"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+	RedirectURL  string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+}
+
+// TracingConfig uses the standard OpenTelemetry variable names.
+type TracingConfig struct {
+	// Exporter is none, otlp or stdout
+	Exporter    string  `yaml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG"`
+}
+
+// Default is the configuration before any file or environment is applied.
+func Default() Config {
+	return Config{
+		App: AppConfig{Env: "development", ServerStatus: "development"},
+		Log: LogConfig{Level: "info", Format: "json"},
+		HTTP: HTTPConfig{
+			Port:            8080,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			RequestTimeout:  5 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+			DrainDelay:      5 * time.Second,
+			MaxHeaderBytes:  1 << 20,
+		},
+		DB:    DBConfig{Driver: "mysql", Host: "127.0.0.1", AutoMigrate: true},
+		Redis: RedisConfig{Addr: "localhost:6379", ConnectRetries: 5},
+		JWT: JWTConfig{
+			Algorithm:   "RS256",
+			Issuer:      "go-task",
+			Audience:    "go-task",
+			KeyRotation: 30 * 24 * time.Hour,
+			SessionTTL:  4 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Login: RateLimit{Limit: 10, Period: time.Minute, Burst: 5},
+			Write: RateLimit{Limit: 60, Period: time.Minute, Burst: 10},
+			Read:  RateLimit{Limit: 300, Period: time.Minute, Burst: 50},
+		},
+		Cache: CacheConfig{
+			TaskTTL:          10 * time.Minute,
+			ListingTTL:       10 * time.Minute,
+			NotFoundTTL:      30 * time.Second,
+			StaleTTL:         time.Minute,
+			EarlyRefreshBeta: 1,
+			BreakerFailures:  5,
+			BreakerCooldown:  10 * time.Second,
+			Backend:          "redis",
+			LocalMaxMB:       64,
+			LocalTTL:         30 * time.Second,
+		},
+		Mail: MailConfig{SMTPPort: 587, From: "no-reply@localhost"},
+		OIDC: OIDCConfig{ProviderName: "oidc"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "go-task",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// validate fills in values derived from others and reports every problem at
+// once, so a broken deployment can be fixed in one go.
+func (c *Config) validate() error {
+	var errs []error
+	if c.App.URL == "" {
+		c.App.URL = fmt.Sprintf("http://localhost:%d", c.HTTP.Port)
+	}
+
+	if c.OIDC.RedirectURL == "" {
+		c.OIDC.RedirectURL = c.App.URL + "/auth/oidc/callback"
+	}
+
+	switch c.DB.Driver {
+	case "mysql", "postgres":
+		if c.DB.Port == 0 {
+			c.DB.Port = map[string]int{"mysql": 3306, "postgres": 5432}[c.DB.Driver]
+		}
+
+		if c.DB.Params == "" && c.DB.Driver == "mysql" {
+			c.DB.Params = "parseTime=true"
+		}
+
+		// the driver hands DATETIME columns over as text otherwise, which
+		// the models and the migration status can't scan into times
+		params, _ := url.ParseQuery(c.DB.Params)
+		if parseTime, _ := strconv.ParseBool(params.Get("parseTime")); c.DB.Driver == "mysql" && !parseTime {
+			errs = append(errs, errors.New("DB_PARAMS: must include parseTime=true for mysql"))
+		}
+
+		if c.DB.Username == "" {
+			errs = append(errs, errors.New("DB_USERNAME: required"))
+		}
+
+		if c.DB.Password == "" {
+			errs = append(errs, errors.New("DB_PASSWORD: required"))
+		}
+	case "sqlite":
+		if c.DB.Params == "" {
+			c.DB.Params = "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DB_DRIVER: %q is not mysql, postgres or sqlite", c.DB.Driver))
+	}
+
+	if _, err := url.ParseRequestURI(c.App.URL); err != nil {
+		errs = append(errs, fmt.Errorf("APP_URL: %w", err))
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: %q is not json or text", c.Log.Format))
+	}
+
+	if c.HTTP.Port <= 0 || c.HTTP.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %d is not a valid port", c.HTTP.Port))
+	}
+
+	if c.HTTP.DrainDelay < 0 {
+		errs = append(errs, errors.New("HTTP_DRAIN_DELAY: must not be negative"))
+	}
+
+	if c.JWT.Algorithm != "RS256" && c.JWT.Algorithm != "EdDSA" {
+		errs = append(errs, fmt.Errorf("JWT_ALGORITHM: %q is not RS256 or EdDSA", c.JWT.Algorithm))
+	}
+
+	if c.JWT.KeyEncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.JWT.KeyEncryptionKey); err != nil || len(key) != 32 {
+			errs = append(errs, errors.New("JWT_KEY_ENCRYPTION_KEY: must be 32 base64 encoded bytes"))
+		}
+	} else if c.App.Env != "development" {
+		errs = append(errs, errors.New("JWT_KEY_ENCRYPTION_KEY: required unless APP_ENV is development"))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_EXPORTER: %q is not none, otlp or stdout", c.Tracing.Exporter))
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("OTEL_TRACES_SAMPLER_ARG: must be between 0 and 1"))
+	}
+
+	if c.Cache.StaleTTL < 0 {
+		errs = append(errs, errors.New("CACHE_STALE_TTL: must not be negative"))
+	}
+
+	if c.Cache.BreakerFailures <= 0 {
+		errs = append(errs, errors.New("CACHE_BREAKER_FAILURES: must be greater than 0"))
+	}
+
+	switch c.Cache.Backend {
+	case "redis", "memory", "tiered":
+	default:
+		errs = append(errs, fmt.Errorf("CACHE_BACKEND: %q is not redis, memory or tiered", c.Cache.Backend))
+	}
+
+	if c.Cache.LocalMaxMB <= 0 {
+		errs = append(errs, errors.New("CACHE_LOCAL_MAX_MB: must be greater than 0"))
+	}
+
+	if c.Redis.ConnectRetries < 0 {
+		errs = append(errs, errors.New("REDIS_CONNECT_RETRIES: must not be negative"))
+	}
+
+	if c.Cache.EarlyRefreshBeta < 0 {
+		errs = append(errs, errors.New("CACHE_EARLY_REFRESH_BETA: must not be negative"))
+	}
+
+	if c.OIDC.Issuer != "" && c.OIDC.ClientID == "" {
+		errs = append(errs, errors.New("OIDC_CLIENT_ID: required when OIDC_ISSUER is set"))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout},
+		{"HTTP_REQUEST_TIMEOUT", c.HTTP.RequestTimeout},
+		{"HTTP_SHUTDOWN_TIMEOUT", c.HTTP.ShutdownTimeout},
+		{"JWT_KEY_ROTATION", c.JWT.KeyRotation},
+		{"JWT_SESSION_TTL", c.JWT.SessionTTL},
+		{"CACHE_TASK_TTL", c.Cache.TaskTTL},
+		{"CACHE_LISTING_TTL", c.Cache.ListingTTL},
+		{"CACHE_NOT_FOUND_TTL", c.Cache.NotFoundTTL},
+		{"CACHE_BREAKER_COOLDOWN", c.Cache.BreakerCooldown},
+		{"CACHE_LOCAL_TTL", c.Cache.LocalTTL},
+	}
+
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be greater than 0", d.name))
+		}
+	}
+
+	limits := []struct {
+		name  string
+		value RateLimit
+	}{
+		{"RATE_LIMIT_LOGIN", c.RateLimit.Login},
+		{"RATE_LIMIT_WRITE", c.RateLimit.Write},
+		{"RATE_LIMIT_READ", c.RateLimit.Read},
+	}
+
+	for _, l := range limits {
+		if l.value.Limit <= 0 || l.value.Period <= 0 || l.value.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("%s: limit, period and burst must be greater than 0", l.name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load builds the configuration from the defaults, the YAML or TOML file at
+// path (if any), .env and the environment, and validates the result.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	root := reflect.ValueOf(&cfg).Elem()
+	if path != "" {
+		if err := loadFile(root, path); err != nil {
+			return nil, err
+		}
+	}
+
+	// .env never overrides variables that are already set
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("config: .env: %w", err)
+	}
+
+	var errs []error
+	loadEnv(root, "", &errs)
+	if err := cfg.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("config: invalid configuration:\n%w", err)
+	}
+
+	return &cfg, nil
+}
+
+func loadFile(root reflect.Value, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("config: %s: expected a .yaml, .yml or .toml file", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	return applyFile(root, values, "")
+}
+
+// applyFile copies the values of a decoded file onto the struct, matching
+// keys against yaml tags. Unknown keys are errors, they are usually typos.
+func applyFile(v reflect.Value, values map[string]interface{}, path string) error {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("yaml")] = v.Field(i)
+	}
+
+	for key, value := range values {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("config: unknown setting %s%s", path, key)
+		}
+
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("config: %s%s must be a table", path, key)
+			}
+
+			if err := applyFile(field, nested, path+key+"."); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := setField(field, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("config: %s%s: %w", path, key, err)
+		}
+	}
+
+	return nil
+}
+
+// loadEnv overrides fields from environment variables named by env tags.
+// On nested structs the tag is a prefix for the names of their fields.
+func loadEnv(v reflect.Value, prefix string, errs *[]error) {
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag
+		field := v.Field(i)
+		name := prefix + tag.Get("env")
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			loadEnv(field, name, errs)
+			continue
+		}
+
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setField(field, strings.TrimSpace(value)); err != nil {
+				*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+
+		if tag.Get("required") == "true" && field.IsZero() {
+			*errs = append(*errs, fmt.Errorf("%s: required", name))
+		}
+	}
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
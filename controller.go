@@ -1,24 +1,47 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/iamgak/go-task/models"
 	"github.com/iamgak/go-task/pkg"
 )
 
+// workspaceScope is the workspace of a /workspaces/:workspace route, whose
+// membership requireWorkspaceRole checked, and 0 for the personal tasks of
+// the other routes.
+func workspaceScope(c *gin.Context) uint {
+	return c.GetUint(ctxWorkspaceID)
+}
+
+// setWorkspace puts the task in the workspace of the route, whatever the
+// body said.
+func setWorkspace(c *gin.Context, task *models.Task) {
+	task.WorkspaceID = nil
+	if workspaceID := workspaceScope(c); workspaceID != 0 {
+		task.WorkspaceID = &workspaceID
+	}
+}
+
 func (app *Application) ListTask(c *gin.Context) {
 	filter := models.NewFilters(c)
-	tasks, err := app.Model.TaskModelORM.TaskListing(c.Request.Context(), filter)
-	if err != nil {
-		app.Logger.Error(err.Error())
-		if err == pkg.ErrNoRecord {
-			app.ErrorJSONResponse(c.Writer, http.StatusNotFound, err.Error())
+	filter.WorkspaceID = workspaceScope(c)
+	// members see the tasks of a workspace whichever project they are in,
+	// personal ones only in the projects of the caller
+	if filter.ProjectID != 0 && filter.WorkspaceID == 0 {
+		if _, err := app.Model.Projects.ProjectById(c.Request.Context(), c.GetUint(ctxUserID), filter.ProjectID); err != nil {
+			app.errorResponse(c, err)
 			return
 		}
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	tasks, err := app.Model.Tasks.TaskListing(c.Request.Context(), filter)
+	if err != nil {
+		app.errorResponse(c, err)
 		return
 	}
 
@@ -28,19 +51,13 @@ func (app *Application) ListTask(c *gin.Context) {
 func (app *Application) TaskListingById(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.badRequest(c, "id must be a number")
 		return
 	}
 
-	data, err := app.Model.TaskModelORM.TaskById(c.Request.Context(), id)
+	data, err := app.Model.Tasks.TaskById(c.Request.Context(), workspaceScope(c), id)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		if err == pkg.ErrNoRecord {
-			app.ErrorJSONResponse(c.Writer, http.StatusNotFound, err.Error())
-			return
-		}
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 
@@ -50,41 +67,90 @@ func (app *Application) TaskListingById(c *gin.Context) {
 func (app *Application) UpdateTask(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.badRequest(c, "id must be a number")
 		return
 	}
 
 	var task models.Task
 	if err := c.ShouldBindJSON(&task); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.bindError(c, err)
 		return
 	}
 
-	validator := app.Model.TaskModelORM.ValidateTaskData(&task, true)
+	validator := app.Model.Tasks.ValidateTaskData(&task, true)
 	if len(validator.Errors) != 0 {
-		c.JSON(http.StatusBadRequest, validator)
+		app.validationFailed(c, validator)
 		return
 	}
 
-	task.UserID = app.UserID
-	err = app.Model.TaskModelORM.UpdateTask(c.Request.Context(), id, &task)
+	task.UserID = c.GetUint(ctxUserID)
+	setWorkspace(c, &task)
+	err = app.Model.Tasks.UpdateTask(c.Request.Context(), id, &task)
 	if err != nil {
-		app.Logger.Error("error updating data ", err.Error())
-		if err == pkg.ErrInvalidUserFound {
-			app.ErrorJSONResponse(c.Writer, http.StatusNotFound, err.Error())
-			return
-		}
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: "Task Updated"}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Task Updated"}
+	err = app.Model.Users.UserActivityLog(&activity)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, task)
+}
+
+// PatchTask changes only the fields present in the body, the others keep
+// their current value.
+func (app *Application) PatchTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	task, err := app.Model.Tasks.TaskById(c.Request.Context(), workspaceScope(c), id)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	// decoding into the current task overwrites just the fields sent
+	if err := binding.JSON.BindBody(body, task); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	// a null due_at leaves DueAt nil just like an absent one, only the body
+	// tells them apart
+	var sent map[string]json.RawMessage
+	if json.Unmarshal(body, &sent) == nil && string(sent["due_at"]) == "null" {
+		task.ClearDueAt = true
+	}
+
+	validator := app.Model.Tasks.ValidateTaskData(task, true)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	task.UserID = c.GetUint(ctxUserID)
+	setWorkspace(c, task)
+	if err := app.Model.Tasks.UpdateTask(c.Request.Context(), id, task); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Task Updated"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
 		return
 	}
 
@@ -93,46 +159,42 @@ func (app *Application) UpdateTask(c *gin.Context) {
 
 func (app *Application) UserActivateAccount(c *gin.Context) {
 	token := c.Param("token")
-	err := app.Model.UsersORM.ActivateAccount(token)
+	err := app.Model.Users.ActivateAccount(token)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		if err == pkg.ErrNoRecord {
-			app.ErrorJSONResponse(c.Writer, http.StatusNotFound, err.Error())
-			return
-		}
-
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Account Activated Successfully")
 }
 
+func (app *Application) UserUnlockAccount(c *gin.Context) {
+	err := app.Model.UsersORM.UnlockAccount(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Account Unlocked Successfully")
+}
+
 func (app *Application) SoftDelete(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.badRequest(c, "id must be a number")
 		return
 	}
 
-	err = app.Model.TaskModelORM.SoftDelete(c.Request.Context(), app.UserID, uint(id))
+	err = app.Model.Tasks.SoftDelete(c.Request.Context(), workspaceScope(c), c.GetUint(ctxUserID), uint(id))
 	if err != nil {
-		app.Logger.Error(err.Error())
-		if err == pkg.ErrInvalidUserFound {
-			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
-			return
-		}
-
-		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: "Task Deleted"}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Task Deleted"}
+	err = app.Model.Users.UserActivityLog(&activity)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Deleted Successfully")
@@ -141,30 +203,28 @@ func (app *Application) SoftDelete(c *gin.Context) {
 func (app *Application) CreateTask(c *gin.Context) {
 	var task models.Task
 	if err := c.ShouldBindJSON(&task); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.bindError(c, err)
 		return
 	}
 
-	validator := app.Model.TaskModelORM.ValidateTaskData(&task, false)
+	validator := app.Model.Tasks.ValidateTaskData(&task, false)
 	if len(validator.Errors) != 0 {
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, validator)
+		app.validationFailed(c, validator)
 		return
 	}
 
-	task.UserID = app.UserID
-	err := app.Model.TaskModelORM.CreateTask(c.Request.Context(), &task)
+	task.UserID = c.GetUint(ctxUserID)
+	setWorkspace(c, &task)
+	err := app.Model.Tasks.CreateTask(c.Request.Context(), &task)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 
 	activity := models.UserActivityLog{UserID: task.UserID, Activity: "New Task Created"}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
+	err = app.Model.Users.UserActivityLog(&activity)
 	if err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+		app.errorResponse(c, err)
 		return
 	}
 	app.sendJSONResponse(c.Writer, http.StatusCreated, task)
@@ -173,31 +233,41 @@ func (app *Application) CreateTask(c *gin.Context) {
 func (app *Application) UserLogin(c *gin.Context) {
 	var creds *models.UserStruct
 	if err := c.ShouldBindJSON(&creds); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.bindError(c, err)
 		return
 	}
 
-	validator := app.Model.UsersORM.ValidateUserData(creds, false)
+	validator := app.Model.Users.ValidateUserData(creds, false)
 	if len(validator.Errors) != 0 {
-		c.JSON(http.StatusBadRequest, validator)
+		app.validationFailed(c, validator)
 		return
 	}
 
-	token, err := app.Model.UsersORM.LoginUser(c.Request.Context(), creds)
+	token, mfaRequired, err := app.Model.Users.LoginUser(c.Request.Context(), creds, c.ClientIP())
 	if err != nil {
-		app.Logger.Error(err.Error())
-		if err == pkg.ErrAccountInActive {
-			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
-			return
-		}
+		app.errorResponse(c, err)
+		return
+	}
 
-		if err == pkg.ErrInvalidCredentials {
-			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
-			return
-		}
+	if mfaRequired {
+		app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"mfa_required": true, "mfa_token": token})
+		return
+	}
 
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
+	c.Header("Authorization", "Bearer "+token)
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Login Successfull")
+}
+
+func (app *Application) UserLoginMFA(c *gin.Context) {
+	var input models.MFAStruct
+	if err := c.ShouldBindJSON(&input); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	token, err := app.Model.UsersORM.LoginMFA(c.Request.Context(), input.MFAToken, input.Code, c.ClientIP())
+	if err != nil {
+		app.errorResponse(c, err)
 		return
 	}
 
@@ -205,25 +275,513 @@ func (app *Application) UserLogin(c *gin.Context) {
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Login Successfull")
 }
 
+func (app *Application) EnrollMFA(c *gin.Context) {
+	secret, uri, err := app.Model.UsersORM.EnrollMFA(c.Request.Context(), c.GetUint(ctxUserID))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"secret": secret, "provisioning_uri": uri})
+}
+
+func (app *Application) MFAQRCode(c *gin.Context) {
+	img, err := app.Model.UsersORM.MFAQRCode(c.Request.Context(), c.GetUint(ctxUserID))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", img)
+}
+
+func (app *Application) ConfirmMFA(c *gin.Context) {
+	var input models.MFAStruct
+	if err := c.ShouldBindJSON(&input); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	codes, err := app.Model.UsersORM.ConfirmMFA(c.Request.Context(), c.GetUint(ctxUserID), input.Code)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (app *Application) DisableMFA(c *gin.Context) {
+	var input models.MFAStruct
+	if err := c.ShouldBindJSON(&input); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	err := app.Model.UsersORM.DisableMFA(c.Request.Context(), c.GetUint(ctxUserID), input.Passw, input.Code)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "MFA Disabled Successfully")
+}
+
+const oidcStateCookie = "oidc_state"
+
+func (app *Application) OIDCStart(c *gin.Context) {
+	url, state, err := app.Model.UsersORM.StartOIDCLogin(c.Request.Context())
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	// ties the callback to the browser that started the login
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, url)
+}
+
+func (app *Application) OIDCCallback(c *gin.Context) {
+	if errParam := c.Query("error"); errParam != "" {
+		app.problem(c, pkg.NewProblem(http.StatusUnauthorized, pkg.CodeUnauthorized, "Login rejected by identity provider", errParam))
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie != state {
+		app.problem(c, pkg.NewProblem(http.StatusBadRequest, pkg.CodeBadRequest, "Invalid login state", ""))
+		return
+	}
+
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+	token, mfaRequired, err := app.Model.UsersORM.FinishOIDCLogin(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	if mfaRequired {
+		app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"mfa_required": true, "mfa_token": token})
+		return
+	}
+
+	// the browser landing here can't read response headers, the token goes
+	// in the body
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"token": token})
+}
+
+func (app *Application) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, app.Model.Signer.JWKS())
+}
+
 func (app *Application) UserRegister(c *gin.Context) {
 	var creds *models.UserStruct
 	if err := c.ShouldBindJSON(&creds); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		app.bindError(c, err)
 		return
 	}
 
-	validator := app.Model.UsersORM.ValidateUserData(creds, true)
+	validator := app.Model.Users.ValidateUserData(creds, true)
 	if len(validator.Errors) != 0 {
-		c.JSON(http.StatusBadRequest, validator)
+		app.validationFailed(c, validator)
 		return
 	}
 
-	if err := app.Model.UsersORM.RegisterUser(c.Request.Context(), creds.Email, creds.Passw, c.ClientIP()); err != nil {
-		app.Logger.Error(err.Error())
-		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
+	if err := app.Model.Users.RegisterUser(c.Request.Context(), creds.Email, creds.Passw, c.ClientIP()); err != nil {
+		app.errorResponse(c, err)
 		return
 	}
 
 	app.sendJSONResponse(c.Writer, http.StatusCreated, "Registration Successfully")
 }
+
+func (app *Application) CreateAPIToken(c *gin.Context) {
+	var input models.APITokenStruct
+	if err := c.ShouldBindJSON(&input); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	validator := app.Model.UsersORM.ValidateAPITokenData(&input)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	plain, token, err := app.Model.UsersORM.CreateAPIToken(c.Request.Context(), c.GetUint(ctxUserID), &input)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusCreated, gin.H{"token": plain, "api_token": token})
+}
+
+func (app *Application) ListAPITokens(c *gin.Context) {
+	tokens, err := app.Model.UsersORM.ListAPITokens(c.Request.Context(), c.GetUint(ctxUserID))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (app *Application) RevokeAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	err = app.Model.UsersORM.RevokeAPIToken(c.Request.Context(), c.GetUint(ctxUserID), uint(id))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Token Revoked Successfully")
+}
+
+func (app *Application) ListProjects(c *gin.Context) {
+	archived := c.Query("include_archived") == "true"
+	projects, err := app.Model.Projects.ProjectListing(c.Request.Context(), c.GetUint(ctxUserID), archived)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, projects)
+}
+
+func (app *Application) ProjectById(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	project, err := app.Model.Projects.ProjectById(c.Request.Context(), c.GetUint(ctxUserID), uint(id))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, project)
+}
+
+func (app *Application) CreateProject(c *gin.Context) {
+	var project models.Project
+	if err := c.ShouldBindJSON(&project); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	validator := app.Model.Projects.ValidateProjectData(&project)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	project.UserID = c.GetUint(ctxUserID)
+	if err := app.Model.Projects.CreateProject(c.Request.Context(), &project); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "New Project Created"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusCreated, project)
+}
+
+func (app *Application) UpdateProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	var project models.Project
+	if err := c.ShouldBindJSON(&project); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	app.saveProject(c, uint(id), &project)
+}
+
+// PatchProject changes only the fields present in the body, archiving a
+// project is a PATCH of archived.
+func (app *Application) PatchProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	project, err := app.Model.Projects.ProjectById(c.Request.Context(), c.GetUint(ctxUserID), uint(id))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	// decoding into the current project overwrites just the fields sent
+	if err := c.ShouldBindJSON(project); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	app.saveProject(c, uint(id), project)
+}
+
+// saveProject validates and stores an update of the project and answers
+// with the project as it is now.
+func (app *Application) saveProject(c *gin.Context, id uint, project *models.Project) {
+	validator := app.Model.Projects.ValidateProjectData(project)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	project.UserID = c.GetUint(ctxUserID)
+	if err := app.Model.Projects.UpdateProject(c.Request.Context(), id, project); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Project Updated"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	updated, err := app.Model.Projects.ProjectById(c.Request.Context(), c.GetUint(ctxUserID), id)
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, updated)
+}
+
+// DeleteProject moves the tasks of the project to the project move_to, or
+// out of any project without it. With tasks=delete it deletes them instead.
+func (app *Application) DeleteProject(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.badRequest(c, "id must be a number")
+		return
+	}
+
+	var tasks models.ProjectTasks
+	switch c.DefaultQuery("tasks", "move") {
+	case "move":
+	case "delete":
+		tasks.Delete = true
+	default:
+		app.badRequest(c, "tasks must be move or delete")
+		return
+	}
+
+	if moveTo := c.Query("move_to"); moveTo != "" {
+		target, err := strconv.ParseUint(moveTo, 10, 32)
+		if err != nil || tasks.Delete {
+			app.badRequest(c, "move_to must be a project id and needs tasks=move")
+			return
+		}
+
+		projectID := uint(target)
+		tasks.MoveTo = &projectID
+	}
+
+	if err := app.Model.Projects.DeleteProject(c.Request.Context(), c.GetUint(ctxUserID), uint(id), tasks); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Project Deleted"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Deleted Successfully")
+}
+
+func (app *Application) ListWorkspaces(c *gin.Context) {
+	workspaces, err := app.Model.Workspaces.WorkspaceListing(c.Request.Context(), c.GetUint(ctxUserID))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+func (app *Application) WorkspaceById(c *gin.Context) {
+	workspace, err := app.Model.Workspaces.WorkspaceById(c.Request.Context(), workspaceScope(c))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	workspace.Role = c.GetString(ctxWorkspaceRole)
+	c.JSON(http.StatusOK, workspace)
+}
+
+// CreateWorkspace makes the user the owner of the new workspace.
+func (app *Application) CreateWorkspace(c *gin.Context) {
+	var workspace models.Workspace
+	if err := c.ShouldBindJSON(&workspace); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	validator := app.Model.Workspaces.ValidateWorkspaceData(&workspace)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	if err := app.Model.Workspaces.CreateWorkspace(c.Request.Context(), &workspace, c.GetUint(ctxUserID)); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "New Workspace Created"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusCreated, workspace)
+}
+
+// InviteMember mails an invitation to join the workspace with a role. The
+// token is only in the mail, the invitee accepts it logged in with the
+// address it went to.
+func (app *Application) InviteMember(c *gin.Context) {
+	var invitation models.WorkspaceInvitation
+	if err := c.ShouldBindJSON(&invitation); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	validator := app.Model.Workspaces.ValidateInvitationData(&invitation)
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	invitation.WorkspaceID = workspaceScope(c)
+	invitation.InvitedBy = c.GetUint(ctxUserID)
+	if err := app.Model.Workspaces.Invite(c.Request.Context(), &invitation); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Workspace Member Invited"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusCreated, invitation)
+}
+
+func (app *Application) AcceptInvitation(c *gin.Context) {
+	member, err := app.Model.Workspaces.AcceptInvitation(c.Request.Context(), c.Param("token"), c.GetUint(ctxUserID), c.GetString(ctxEmail))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Workspace Joined"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, member)
+}
+
+// UpdateMember changes the role of a member.
+func (app *Application) UpdateMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		app.badRequest(c, "user must be a number")
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		app.bindError(c, err)
+		return
+	}
+
+	validator := &pkg.Validator{}
+	validator.CheckField(models.ValidWorkspaceRole(input.Role), "role", "Role must be viewer, editor or owner")
+	if len(validator.Errors) != 0 {
+		app.validationFailed(c, validator)
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := app.Model.Workspaces.SetMemberRole(ctx, workspaceScope(c), uint(userID), input.Role); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Workspace Member Updated"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	member, err := app.Model.Workspaces.Member(ctx, workspaceScope(c), uint(userID))
+	if err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, member)
+}
+
+// RemoveMember takes a member out of the workspace. Owners remove anybody,
+// the others only themselves, which is leaving it.
+func (app *Application) RemoveMember(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		app.badRequest(c, "user must be a number")
+		return
+	}
+
+	if uint(userID) != c.GetUint(ctxUserID) && c.GetString(ctxWorkspaceRole) != models.WorkspaceOwner {
+		app.errorResponse(c, pkg.ErrWorkspaceRole)
+		return
+	}
+
+	if err := app.Model.Workspaces.RemoveMember(c.Request.Context(), workspaceScope(c), uint(userID)); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	activity := models.UserActivityLog{UserID: c.GetUint(ctxUserID), Activity: "Workspace Member Removed"}
+	if err := app.Model.Users.UserActivityLog(&activity); err != nil {
+		app.errorResponse(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Removed Successfully")
+}
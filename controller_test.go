@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/migrations"
+	"github.com/iamgak/go-task/models"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/pquerna/otp/totp"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// mailbox is a Mailer that hands the mails over to the test.
+type mailbox chan [2]string
+
+func (m mailbox) Send(ctx context.Context, to, subject, body string) error {
+	m <- [2]string{to, body}
+	return nil
+}
+
+// receive waits for the next mail and returns the first submatch of link in
+// its body.
+func (m mailbox) receive(t *testing.T, link *regexp.Regexp) string {
+	t.Helper()
+	select {
+	case mail := <-m:
+		match := link.FindStringSubmatch(mail[1])
+		if match == nil {
+			t.Fatalf("no %s in %q", link, mail[1])
+		}
+
+		return match[1]
+	case <-time.After(time.Second):
+		t.Fatal("no mail was sent")
+		return ""
+	}
+}
+
+// memoryApp serves the in-memory repositories, workspace mail goes to the
+// returned mailbox.
+func memoryApp(t *testing.T) (*Application, *gin.Engine, mailbox) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	// budgets big enough not to get in the way
+	cfg.RateLimit.Write = config.RateLimit{Limit: 6000, Period: time.Minute, Burst: 1000}
+	cfg.RateLimit.Read = cfg.RateLimit.Write
+	logger := quietLogger()
+	mail := make(mailbox, 10)
+	model := models.NewMemoryInit(&cfg)
+	model.Limiter, _ = testLimiter(t, logger)
+	model.Workspaces = models.NewMemoryWorkspaceRepository(model.Users.(*models.MemoryUserRepository), mail, cfg.App.URL, logger)
+	app := &Application{Model: model, Config: &cfg, Logger: logger}
+	return app, app.InitRouter(), mail
+}
+
+// ormApp serves the routes from the GORM models, on an in-memory SQLite
+// database and miniredis.
+func ormApp(t *testing.T) (*Application, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	// budgets big enough not to get in the way
+	cfg.RateLimit.Write = config.RateLimit{Limit: 6000, Period: time.Minute, Burst: 1000}
+	cfg.RateLimit.Read = cfg.RateLimit.Write
+	cfg.RateLimit.Login = cfg.RateLimit.Write
+	db, err := models.OpenDB(config.DBConfig{Driver: "sqlite", Database: ":memory:"}, &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sqlDB.Close() })
+	logger := quietLogger()
+	migrator, err := migrations.New(sqlDB, "sqlite", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	model := models.Constructor(&cfg, db, client, logger)
+	if err := model.Signer.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &Application{Model: model, Config: &cfg, Logger: logger}
+	return app, app.InitRouter()
+}
+
+// signUp registers an active user and returns its ID and a session token.
+func signUp(t *testing.T, users models.UserRepository, email string) (uint, string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := users.RegisterUser(ctx, email, "Secret.123", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := users.UserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.SetActive(ctx, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := users.LoginUser(ctx, &models.UserStruct{Email: email, Passw: "Secret.123"}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.ID, token
+}
+
+// decode reads the message of a sendJSONResponse body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	var body struct {
+		Message json.RawMessage `json:"message"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(body.Message, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var invitationLink = regexp.MustCompile(`/v1/invitations/([0-9a-f]+)/accept`)
+
+func TestWorkspaceHandlersUseTheCallersIdentity(t *testing.T) {
+	app, r, mail := memoryApp(t)
+	_, ownerToken := signUp(t, app.Model.Users, "owner@example.com")
+	coOwnerID, coOwnerToken := signUp(t, app.Model.Users, "co-owner@example.com")
+	_, viewerToken := signUp(t, app.Model.Users, "viewer@example.com")
+	_, outsiderToken := signUp(t, app.Model.Users, "outsider@example.com")
+
+	w := serve(r, http.MethodPost, "/v1/workspaces", ownerToken, map[string]string{"name": "Team"})
+	expectStatus(t, w, http.StatusCreated)
+	var workspace models.Workspace
+	decode(t, w, &workspace)
+
+	base := fmt.Sprintf("/v1/workspaces/%d", workspace.ID)
+	for _, invite := range []struct{ token, role string }{{coOwnerToken, models.WorkspaceOwner}, {viewerToken, models.WorkspaceViewer}} {
+		email := map[string]string{coOwnerToken: "co-owner@example.com", viewerToken: "viewer@example.com"}[invite.token]
+		expectStatus(t, serve(r, http.MethodPost, base+"/invitations", ownerToken, map[string]string{"email": email, "role": invite.role}), http.StatusCreated)
+		link := mail.receive(t, invitationLink)
+		expectStatus(t, serve(r, http.MethodPost, "/v1/invitations/"+link+"/accept", outsiderToken, nil), http.StatusNotFound)
+		expectStatus(t, serve(r, http.MethodPost, "/v1/invitations/"+link+"/accept", invite.token, nil), http.StatusOK)
+	}
+
+	// the viewer may only remove itself, however busy the co-owner is
+	removeCoOwner := fmt.Sprintf("%s/members/%d", base, coOwnerID)
+	var wg sync.WaitGroup
+	statuses := make(chan int, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			serve(r, http.MethodGet, "/v1/workspaces", coOwnerToken, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			statuses <- serve(r, http.MethodDelete, removeCoOwner, viewerToken, nil).Code
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusForbidden {
+			t.Fatalf("viewer removing the co-owner got %d, want 403", status)
+		}
+	}
+
+	if _, err := app.Model.Workspaces.Member(context.Background(), workspace.ID, coOwnerID); err != nil {
+		t.Fatalf("co-owner is no longer a member: %v", err)
+	}
+}
+
+func TestMFALogin(t *testing.T) {
+	app, r := ormApp(t)
+	_, session := signUp(t, app.Model.Users, "ada@example.com")
+
+	w := serve(r, http.MethodPost, "/v1/me/mfa/enroll", session, nil)
+	expectStatus(t, w, http.StatusOK)
+	var enrolled struct{ Secret string }
+	decode(t, w, &enrolled)
+
+	code := func(steps int) string {
+		code, err := totp.GenerateCode(enrolled.Secret, time.Now().Add(time.Duration(steps)*30*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return code
+	}
+
+	w = serve(r, http.MethodPost, "/v1/me/mfa/confirm", session, map[string]string{"code": code(-1)})
+	expectStatus(t, w, http.StatusOK)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	decode(t, w, &confirmed)
+	if len(confirmed.RecoveryCodes) == 0 {
+		t.Fatalf("confirmation without recovery codes: %s", w.Body)
+	}
+
+	w = serve(r, http.MethodPost, "/v1/login", "", map[string]string{"email": "ada@example.com", "passw": "Secret.123"})
+	expectStatus(t, w, http.StatusOK)
+	var challenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	decode(t, w, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || w.Header().Get("Authorization") != "" {
+		t.Fatalf("login with MFA on = %s, want a challenge and no session", w.Body)
+	}
+
+	// the pending token is no session
+	expectStatus(t, serve(r, http.MethodPost, "/v1/me/mfa/enroll", challenge.MFAToken, nil), http.StatusUnauthorized)
+
+	login := map[string]string{"mfa_token": challenge.MFAToken, "code": "000000"}
+	if login["code"] == code(0) {
+		login["code"] = "111111"
+	}
+
+	expectStatus(t, serve(r, http.MethodPost, "/v1/login/mfa", "", login), http.StatusUnauthorized)
+	for _, second := range []string{code(0), confirmed.RecoveryCodes[0]} {
+		login["code"] = second
+		w = serve(r, http.MethodPost, "/v1/login/mfa", "", login)
+		expectStatus(t, w, http.StatusOK)
+		// a session gets past LoginMiddleware, to find MFA already on
+		token := strings.TrimPrefix(w.Header().Get("Authorization"), "Bearer ")
+		expectStatus(t, serve(r, http.MethodPost, "/v1/me/mfa/enroll", token, nil), http.StatusConflict)
+
+		// and neither code works twice
+		expectStatus(t, serve(r, http.MethodPost, "/v1/login/mfa", "", login), http.StatusUnauthorized)
+	}
+
+	expectStatus(t, serve(r, http.MethodPost, "/v1/me/mfa/disable", session, map[string]string{"passw": "Secret.123", "code": code(1)}), http.StatusOK)
+	w = serve(r, http.MethodPost, "/v1/login", "", map[string]string{"email": "ada@example.com", "passw": "Secret.123"})
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("Authorization") == "" {
+		t.Fatalf("login after disabling MFA = %s, want a session", w.Body)
+	}
+}
+
+func TestPatchTaskDueAt(t *testing.T) {
+	app, r, _ := memoryApp(t)
+	_, token := signUp(t, app.Model.Users, "someone@example.com")
+
+	w := serve(r, http.MethodPost, "/v1/tasks", token, map[string]string{"title": "Taxes", "description": "File them", "status": "pending", "due_at": "2030-04-15T00:00:00Z"})
+	expectStatus(t, w, http.StatusCreated)
+	var task models.Task
+	decode(t, w, &task)
+
+	path := fmt.Sprintf("/v1/tasks/%d", task.ID)
+	for _, tt := range []struct {
+		name string
+		body any
+		want bool
+	}{
+		{"absent", map[string]string{"title": "Income taxes"}, true},
+		{"null", map[string]any{"due_at": nil}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, serve(r, http.MethodPatch, path, token, tt.body), http.StatusOK)
+			got, err := app.Model.Tasks.TaskById(context.Background(), 0, int(task.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (got.DueAt != nil) != tt.want {
+				t.Fatalf("due_at after a PATCH with it %s = %v", tt.name, got.DueAt)
+			}
+		})
+	}
+}
+
+func TestProjectFilterIsPrivate(t *testing.T) {
+	app, r, _ := memoryApp(t)
+	_, owner := signUp(t, app.Model.Users, "owner@example.com")
+	_, other := signUp(t, app.Model.Users, "other@example.com")
+
+	w := serve(r, http.MethodPost, "/v1/projects", owner, map[string]string{"name": "Private"})
+	expectStatus(t, w, http.StatusCreated)
+	var project models.Project
+	decode(t, w, &project)
+	expectStatus(t, serve(r, http.MethodPost, "/v1/tasks", owner, map[string]any{"title": "Secret", "description": "Nobody else's", "status": "pending", "project_id": project.ID}), http.StatusCreated)
+
+	path := fmt.Sprintf("/v1/tasks?project_id=%d", project.ID)
+	expectStatus(t, serve(r, http.MethodGet, path, "", nil), http.StatusUnauthorized)
+	expectStatus(t, serve(r, http.MethodGet, path, other, nil), http.StatusNotFound)
+
+	w = serve(r, http.MethodGet, path, owner, nil)
+	expectStatus(t, w, http.StatusOK)
+	var tasks []models.Task
+	if err := json.Unmarshal(w.Body.Bytes(), &tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tasks) != 1 || tasks[0].Title != "Secret" {
+		t.Fatalf("the owner's listing of the project = %s", w.Body)
+	}
+
+	// without project_id the listing stays public
+	expectStatus(t, serve(r, http.MethodGet, "/v1/tasks", "", nil), http.StatusOK)
+}
+
+func TestAdminChangesEndSessions(t *testing.T) {
+	app, r := ormApp(t)
+	ctx := context.Background()
+	userID, session := signUp(t, app.Model.Users, "ada@example.com")
+	expectStatus(t, serve(r, http.MethodGet, "/v1/me/tokens", session, nil), http.StatusOK)
+
+	if err := app.Model.Users.SetPassword(ctx, userID, "Another.456"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectProblem(t, serve(r, http.MethodGet, "/v1/me/tokens", session, nil), http.StatusUnauthorized, pkg.CodeInvalidToken)
+
+	token, _, err := app.Model.Users.LoginUser(ctx, &models.UserStruct{Email: "ada@example.com", Passw: "Another.456"}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(r, http.MethodPost, "/v1/me/tokens", token, map[string]any{"name": "ci", "scopes": []string{models.ScopeTasksRead}})
+	expectStatus(t, w, http.StatusCreated)
+	var created struct {
+		Token string `json:"token"`
+	}
+
+	decode(t, w, &created)
+	if err := app.Model.Users.SetActive(ctx, userID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, old := range []string{token, created.Token} {
+		expectProblem(t, serve(r, http.MethodGet, "/v1/projects", old, nil), http.StatusUnauthorized, pkg.CodeInvalidToken)
+	}
+}
@@ -0,0 +1,37 @@
+// Package docs embeds the OpenAPI 3.1 document of the API and serves it with
+// a reference page. openapi.json is maintained by hand, the route test in the
+// main package fails when a route is missing from it.
+package docs
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var Spec []byte
+
+// redoc renders the spec, the script comes from the jsDelivr CDN.
+const redoc = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Task Management API</title>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+  <redoc spec-url="/openapi.json"></redoc>
+  <script src="https://cdn.jsdelivr.net/npm/redoc@2.1.5/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+func SpecHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", Spec)
+}
+
+func PageHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(redoc))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.gitSHA=$(git rev-parse HEAD)".
+// Without them the VCS stamp of `go build` is used where there is one.
+var (
+	version = "dev"
+	gitSHA  = ""
+)
+
+// how long a single dependency may take to answer a probe
+const probeTimeout = 2 * time.Second
+
+func buildInfo() (string, string) {
+	sha := gitSHA
+	if info, ok := debug.ReadBuildInfo(); ok && sha == "" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				sha = setting.Value
+			}
+		}
+	}
+
+	if sha == "" {
+		sha = "unknown"
+	}
+
+	return version, sha
+}
+
+// Healthz only tells that the process is up and serving, it never looks at
+// dependencies so a database outage doesn't get every replica restarted.
+func (app *Application) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz tells the load balancer whether to send us traffic: not while we
+// drain for a shutdown, are in maintenance or can't reach the database.
+// Without Redis we serve uncached, that is reported as degraded.
+func (app *Application) Readyz(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+	if app.draining.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	} else if app.Config.App.ServerStatus == "maintenance" {
+		checks["server"] = "maintenance"
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+	defer cancel()
+
+	checks["database"] = "ok"
+	if err := app.Model.PingDB(ctx); err != nil {
+		app.requestLog(c).Warn("Readiness: database unreachable: ", err)
+		checks["database"] = err.Error()
+		ready = false
+	}
+
+	status := "ready"
+	checks["redis"] = "ok"
+	if _, err := app.Model.PingRedis(ctx); err != nil {
+		app.requestLog(c).Warn("Readiness: Redis unreachable: ", err)
+		checks["redis"] = err.Error()
+		status = "degraded"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status, "checks": checks})
+}
+
+// Status is the detailed view for operators: what is running since when and
+// how its connections are doing.
+func (app *Application) Status(c *gin.Context) {
+	release, sha := buildInfo()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+	defer cancel()
+
+	db := gin.H{"status": "ok"}
+	if err := app.Model.PingDB(ctx); err != nil {
+		db["status"] = err.Error()
+	}
+
+	if stats, err := app.Model.DBStats(); err == nil {
+		db["open_connections"] = stats.OpenConnections
+		db["in_use"] = stats.InUse
+		db["idle"] = stats.Idle
+		db["max_open_connections"] = stats.MaxOpenConnections
+		db["wait_count"] = stats.WaitCount
+		db["wait_duration"] = stats.WaitDuration.String()
+	}
+
+	redis := gin.H{"status": "ok", "breaker": app.Model.CacheBreaker()}
+	latency, err := app.Model.PingRedis(ctx)
+	if err != nil {
+		redis["status"] = err.Error()
+	} else {
+		redis["latency"] = latency.String()
+	}
+
+	pool := app.Model.RedisPoolStats()
+	redis["total_connections"] = pool.TotalConns
+	redis["idle_connections"] = pool.IdleConns
+	redis["timeouts"] = pool.Timeouts
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{
+		"version":    release,
+		"git_sha":    sha,
+		"go_version": runtime.Version(),
+		"started_at": app.startedAt.Format(time.RFC3339),
+		"uptime":     time.Since(app.startedAt).Round(time.Second).String(),
+		"draining":   app.draining.Load(),
+		"database":   db,
+		"redis":      redis,
+	})
+}
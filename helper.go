@@ -2,18 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-)
-
-func (app *Application) ServerError(w http.ResponseWriter, err error) {
-	app.Logger.Error("Internal Server Error: ", err)
-	app.sendJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
-}
 
-func (app *Application) CustomError(w http.ResponseWriter, status int, msg string) {
-	app.Logger.Error("Internal Server Error: ", msg)
-	app.ErrorJSONResponse(w, status, msg)
-}
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/pkg"
+)
 
 func (app *Application) sendJSONResponse(w http.ResponseWriter, statusCode int, message any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -26,13 +22,55 @@ func (app *Application) sendJSONResponse(w http.ResponseWriter, statusCode int,
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (app *Application) ErrorJSONResponse(w http.ResponseWriter, statusCode int, message any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	resp := map[string]interface{}{
-		"status": false,
-		"error":  message,
+// problem writes an application/problem+json response and stops the
+// handler chain. Every error response goes through here.
+func (app *Application) problem(c *gin.Context, p *pkg.Problem) {
+	p.Instance = redactedPath(c)
+	p.RequestID = c.Writer.Header().Get(requestIDHeader)
+	c.Header("Content-Type", pkg.ProblemContentType)
+	c.Status(p.Status)
+	json.NewEncoder(c.Writer).Encode(p)
+	c.Abort()
+}
+
+// errorResponse reports err with the status and code of the mapping table in
+// pkg. Errors not in the table are logged and answered with a plain 500, their
+// message could leak internals.
+func (app *Application) errorResponse(c *gin.Context, err error) {
+	kind, known := pkg.KindOf(err)
+	if known {
+		app.requestLog(c).Warn(err)
+	} else {
+		app.requestLog(c).Error("Internal Server Error: ", err)
 	}
 
-	json.NewEncoder(w).Encode(resp)
+	app.problem(c, pkg.NewProblem(kind.Status, kind.Code, kind.Title, ""))
+}
+
+func (app *Application) badRequest(c *gin.Context, detail string) {
+	app.problem(c, pkg.NewProblem(http.StatusBadRequest, pkg.CodeBadRequest, "Bad Request", detail))
+}
+
+// bindError explains why a request body could not be decoded, down to the
+// field where that is known.
+func (app *Application) bindError(c *gin.Context, err error) {
+	app.requestLog(c).Warn("Loading Input Data Err :", err)
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		p := pkg.NewProblem(http.StatusBadRequest, pkg.CodeValidationFailed, "Invalid request body", "")
+		p.Errors = []pkg.FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("must be a %s", typeErr.Type)}}
+		app.problem(c, p)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		app.badRequest(c, "Request body must be a JSON object")
+	default:
+		app.badRequest(c, "Incorrect Input data provided")
+	}
+}
+
+func (app *Application) validationFailed(c *gin.Context, v *pkg.Validator) {
+	p := pkg.NewProblem(http.StatusBadRequest, pkg.CodeValidationFailed, "Validation failed", "")
+	p.Errors = v.FieldErrors()
+	app.problem(c, p)
 }
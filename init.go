@@ -1,66 +1,96 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"time"
 
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/metrics"
+	"github.com/iamgak/go-task/migrations"
 	"github.com/iamgak/go-task/models"
-	"github.com/iamgak/go-task/pkg"
-	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
-	"gorm.io/driver/mysql"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	otelgorm "gorm.io/plugin/opentelemetry/tracing"
 )
 
-func openDBORM() (*gorm.DB, error) {
-	err := godotenv.Load()
+func openDBORM(cfg config.DBConfig) (*gorm.DB, error) {
+	db, err := models.OpenDB(cfg, &gorm.Config{})
 	if err != nil {
-		return nil, pkg.ErrNoEnvFileFound
+		return nil, err
 	}
 
-	dbUser := os.Getenv("DB_USERNAME")
-	dbName := os.Getenv("DB_DATABASE")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	if dbUser == "" || dbName == "" || dbPassword == "" {
-		return nil, pkg.ErrNoEnvFileFound
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		return nil, err
 	}
 
-	dsn := fmt.Sprintf("%s:%s@/%s?parseTime=true", dbUser, dbPassword, dbName)
-	return gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	// statements are traced without their bound values, those include
+	// password hashes and tokens
+	return db, db.Use(otelgorm.NewPlugin(otelgorm.WithDBName(cfg.Database), otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics()))
 }
 
-func InitRedis() *redis.Client {
-	name := "localhost"
-	passw := ""
-	redis_port := 6379
+func InitRedis(cfg config.RedisConfig) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", name, redis_port),
-		Password: passw,
-		DB:       0,
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
 
-	return client
+	return client, redisotel.InstrumentTracing(client)
 }
 
-func MigrateDB(DB *gorm.DB) {
-	err := DB.AutoMigrate(&models.Task{})
-	if err != nil {
-		log.Fatal("Migration failed:", err)
+// pingRedis waits for Redis to answer, retrying with a doubling backoff.
+// Giving up isn't fatal, the caller runs without a cache until it's back.
+func pingRedis(ctx context.Context, client *redis.Client, retries int, logger *logrus.Logger) error {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		err := client.Ping(pingCtx).Err()
+		cancel()
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		logger.Warn("Redis unreachable, retrying in ", backoff, ": ", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, 8*time.Second)
 	}
+}
 
-	err = DB.AutoMigrate(&models.User{})
+// migrateOnStart applies pending migrations when DB_AUTO_MIGRATE is on.
+// Replicas starting together take turns, the migrator locks. With it off
+// the server refuses to run on an outdated schema.
+func migrateOnStart(ctx context.Context, cfg config.DBConfig, sqlDB *sql.DB, logger *logrus.Logger) error {
+	migrator, err := migrations.New(sqlDB, cfg.Driver, logger)
 	if err != nil {
-		log.Fatal("Migration failed:", err)
+		return err
 	}
 
-	err = DB.AutoMigrate(&models.UsersSession{})
-	if err != nil {
-		log.Fatal("Migration failed:", err)
+	if cfg.AutoMigrate {
+		applied, err := migrator.Up(ctx)
+		if applied > 0 {
+			logger.Info("Applied ", applied, " migrations")
+		}
+
+		return err
 	}
 
-	err = DB.AutoMigrate(&models.UserActivityLog{})
+	pending, err := migrator.Pending(ctx)
 	if err != nil {
-		log.Fatal("Migration failed:", err)
+		return err
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%d migrations pending, run migrate up", pending)
 	}
+
+	return nil
 }
@@ -0,0 +1,86 @@
+// Package logging carries the request-scoped logrus entry through contexts
+// and keeps credentials out of the logs.
+package logging
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type entryKey struct{}
+
+// Redacted replaces secrets in log output.
+const Redacted = "[REDACTED]"
+
+// sensitive are field, query parameter and route parameter names whose
+// values must never be logged.
+var sensitive = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"password":      true,
+	"passw":         true,
+	"repeat_passw":  true,
+	"token":         true,
+	"mfa_token":     true,
+	"secret":        true,
+	"code":          true,
+	"state":         true,
+}
+
+func IsSensitive(name string) bool {
+	return sensitive[strings.ToLower(name)]
+}
+
+// WithEntry returns a copy of ctx that carries entry.
+func WithEntry(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// FromContext returns the entry of the request ctx belongs to, with its
+// request ID and route, or an entry of fallback outside of requests.
+func FromContext(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry.WithContext(ctx)
+	}
+
+	return fallback.WithContext(ctx)
+}
+
+// RedactQuery masks the values of sensitive query parameters.
+func RedactQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+
+	redacted := url.Values{}
+	for name, values := range query {
+		if IsSensitive(name) {
+			redacted[name] = []string{Redacted}
+			continue
+		}
+
+		redacted[name] = values
+	}
+
+	return redacted.Encode()
+}
+
+// RedactHook masks sensitive fields whoever added them.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	for name := range entry.Data {
+		if IsSensitive(name) {
+			entry.Data[name] = Redacted
+		}
+	}
+
+	return nil
+}
@@ -1,72 +1,210 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
 	"github.com/iamgak/go-task/models"
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/iamgak/go-task/telemetry"
 	"github.com/sirupsen/logrus"
 )
 
 type Application struct {
-	Model           *models.Init
-	UserID          uint
-	isAuthenticated bool
-	Email           string
-	Logger          *logrus.Logger
+	Model     *models.Init
+	Config    *config.Config
+	Logger    *logrus.Logger
+	startedAt time.Time
+	// draining is set once shutdown began, /readyz fails from then on
+	draining atomic.Bool
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run loads the config and runs the command named by the first argument,
+// serve when there is none. The result is the process exit code.
+func run() int {
 	var logrusLogger = logrus.New()
-	logrusLogger.SetFormatter(&logrus.TextFormatter{}) // Use JSON format for structured logging
+	logrusLogger.SetFormatter(&logrus.JSONFormatter{}) // Use JSON format for structured logging
 	logrusLogger.SetLevel(logrus.InfoLevel)            // Log Info, Warning, and Error
+	logrusLogger.AddHook(logging.RedactHook{})
+
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "Optional YAML or TOML config file")
+	addr := flag.String("addr", "", "HTTP network address (default \":$PORT\")")
+	output := flag.String("output", "table", "Output of the admin commands, table or json")
+	flag.Usage = func() { printUsage(flag.CommandLine.Output()) }
+	flag.Parse()
+
+	name := flag.Arg(0)
+	if name == "" {
+		name = "serve"
+	}
 
+	cmd, ok := commands[name]
+	if !ok || (*output != "table" && *output != "json") {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logrusLogger.Error("Error loading configuration : ", err)
+		return 1
+	}
+
+	level, _ := logrus.ParseLevel(cfg.Log.Level)
+	logrusLogger.SetLevel(level)
+	if cfg.Log.Format == "text" {
+		logrusLogger.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	c := &cli{cfg: cfg, logger: logrusLogger, output: *output, addr: *addr, stdin: os.Stdin, stdout: os.Stdout}
+	var args []string
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
+	}
+
+	return cmd.run(c, args)
+}
+
+// runServe starts the API and blocks until it was stopped by SIGINT/SIGTERM
+// or failed.
+func runServe(c *cli, args []string) int {
+	if len(args) > 0 {
+		return c.usageError("usage: serve")
+	}
+
+	cfg, logrusLogger, addr := c.cfg, c.logger, c.addr
 	logrusLogger.Info("Task Web App startet \n")
-	err := godotenv.Load()
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", cfg.HTTP.Port)
+	}
+
+	logrusLogger.AddHook(telemetry.LogrusHook{})
+	release, _ := buildInfo()
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Tracing, release)
 	if err != nil {
-		logrusLogger.Error("Failed to get task: ", err)
-		log.Fatal("Error loading .env file:", err)
+		logrusLogger.Error("Error setting up tracing : ", err)
+		return 1
 	}
 
-	Port := os.Getenv("PORT")
-	addr := flag.String("addr", ":"+Port, "HTTP network address")
-	flag.Parse()
+	defer func() {
+		// flush the spans of the last requests
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logrusLogger.Error("Error flushing traces : ", err)
+		}
+	}()
 
-	dbORM, err := openDBORM()
+	dbORM, err := openDBORM(cfg.DB)
 	if err != nil {
 		logrusLogger.Error("Error creating db connection : ", err)
-		log.Fatal(err)
+		return 1
 	}
 
-	client := InitRedis()
-	if client == nil {
+	sqlDB, err := dbORM.DB()
+	if err != nil {
+		logrusLogger.Error("Error creating db connection : ", err)
+		return 1
+	}
+
+	defer sqlDB.Close()
+	if err := metrics.RegisterDB(sqlDB, cfg.DB.Database); err != nil {
+		logrusLogger.Error("Error registering db metrics : ", err)
+		return 1
+	}
+
+	client, err := InitRedis(cfg.Redis)
+	if err != nil {
 		logrusLogger.Error("Error creating redis connection : ", err)
-		panic(fmt.Errorf("redis client is %T", client))
+		return 1
 	}
 
+	defer client.Close()
+
 	app := Application{
-		Model:  models.Constructor(dbORM, client, logrusLogger),
-		Logger: logrusLogger,
+		Model:     models.Constructor(cfg, dbORM, client, logrusLogger),
+		Config:    cfg,
+		Logger:    logrusLogger,
+		startedAt: time.Now(),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := pingRedis(ctx, client, cfg.Redis.ConnectRetries, logrusLogger); err != nil {
+		logrusLogger.Warn("Redis unreachable, serving without a cache until it is back : ", err)
+	}
+
+	if err := migrateOnStart(ctx, cfg.DB, sqlDB, logrusLogger); err != nil {
+		logrusLogger.Error("Error migrating the database : ", err)
+		return 1
 	}
 
-	MigrateDB(dbORM)
+	if err := app.Model.Signer.Rotate(ctx); err != nil {
+		logrusLogger.Error("Error loading signing keys : ", err)
+		return 1
+	}
 
-	maxHeaderBytes := 1 << 20
 	server := &http.Server{
-		Addr:           *addr,
+		Addr:           addr,
 		Handler:        app.InitRouter(),
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: maxHeaderBytes,
+		ReadTimeout:    cfg.HTTP.ReadTimeout,
+		WriteTimeout:   cfg.HTTP.WriteTimeout,
+		MaxHeaderBytes: cfg.HTTP.MaxHeaderBytes,
+	}
+
+	supervisor := NewSupervisor(ctx, logrusLogger)
+	supervisor.Go("http", func(ctx context.Context) error {
+		logrusLogger.Info("start http server listening ", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+
+		return nil
+	})
+
+	supervisor.Go("http-drain", func(ctx context.Context) error {
+		<-ctx.Done()
+		// fail readiness first and keep serving a little, so load balancers
+		// stop sending traffic before the listener goes away
+		app.draining.Store(true)
+		logrusLogger.Info("shutting down, leaving ", cfg.HTTP.DrainDelay, " for load balancers to notice")
+		time.Sleep(cfg.HTTP.DrainDelay)
+
+		logrusLogger.Info("draining requests for up to ", cfg.HTTP.ShutdownTimeout)
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(drainCtx)
+	})
+
+	supervisor.Go("ratelimit-janitor", app.Model.Limiter.Run)
+	supervisor.Go("key-rotation", func(ctx context.Context) error {
+		return app.Model.Signer.Run(ctx, 10*time.Minute)
+	})
+
+	if sync := app.Model.CacheSync(); sync != nil {
+		supervisor.Go("cache-invalidation", sync)
+	}
+
+	if err := supervisor.Wait(); err != nil {
+		logrusLogger.Error("Task Web App stopped with an error: ", err)
+		return 1
 	}
 
-	logrusLogger.Info("start http server listening ", *addr)
-	server.ListenAndServe()
+	logrusLogger.Info("Task Web App stopped")
+	return 0
 }
@@ -3,20 +3,122 @@ package main
 import (
 	"context"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
-	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
 	"github.com/iamgak/go-task/models"
-	"github.com/joho/godotenv"
-	"golang.org/x/time/rate"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
 )
 
+// Keys of values LoginMiddleware and requireWorkspaceRole store on the gin
+// context.
+const (
+	ctxUserID        = "user_id"
+	ctxEmail         = "email"
+	ctxAPIToken      = "api_token"
+	ctxWorkspaceID   = "workspace_id"
+	ctxWorkspaceRole = "workspace_role"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// request IDs from upstream are kept only when they look like one, they end
+// up in every log line of the request
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestLogger gives every request an ID, puts a logrus entry carrying it
+// into the request context and logs one JSON line per request when it is
+// done. It replaces gin.Logger.
+func (app *Application) RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Header(requestIDHeader, requestID)
+		route := c.FullPath()
+		entry := app.Logger.WithFields(logrus.Fields{
+			"request_id": requestID,
+			"method":     c.Request.Method,
+			"route":      route,
+			"ip":         c.ClientIP(),
+		})
+
+		c.Request = c.Request.WithContext(logging.WithEntry(c.Request.Context(), entry))
+		c.Next()
+
+		fields := logrus.Fields{
+			"path":       redactedPath(c),
+			"status":     c.Writer.Status(),
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":      c.Writer.Size(),
+			"user_agent": c.Request.UserAgent(),
+		}
+
+		if query := logging.RedactQuery(c.Request.URL.Query()); query != "" {
+			fields["query"] = query
+		}
+
+		if userID, ok := c.Get(ctxUserID); ok {
+			fields["user_id"] = userID
+		}
+
+		if len(c.Errors) > 0 {
+			fields["errors"] = c.Errors.String()
+		}
+
+		entry = entry.WithContext(c.Request.Context()).WithFields(fields)
+		switch status := c.Writer.Status(); {
+		case status >= http.StatusInternalServerError:
+			entry.Error("request")
+		case status >= http.StatusBadRequest:
+			entry.Warn("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+// redactedPath is the request path with the values of sensitive route
+// parameters, like /unlock_account/:token, masked.
+func redactedPath(c *gin.Context) string {
+	path := c.Request.URL.Path
+	for _, param := range c.Params {
+		if logging.IsSensitive(param.Key) && param.Value != "" {
+			path = strings.Replace(path, param.Value, logging.Redacted, 1)
+		}
+	}
+
+	return path
+}
+
+// recovery turns panics into a 500 and logs them, with their stack, on the
+// request entry instead of gin's plain text stream.
+func (app *Application) recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		app.requestLog(c).WithField("panic", err).WithField("stack", string(debug.Stack())).Error("Recovered from panic")
+		app.problem(c, pkg.NewProblem(http.StatusInternalServerError, pkg.CodeInternal, "Internal Server Error", ""))
+	})
+}
+
+// requestLog is the logger handlers should use, it carries the request ID.
+func (app *Application) requestLog(c *gin.Context) *logrus.Entry {
+	return logging.FromContext(c.Request.Context(), app.Logger)
+}
+
 func secureHeaders() gin.HandlerFunc {
 	return (func(c *gin.Context) {
 		c.Header("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
@@ -32,158 +134,182 @@ func (app *Application) LoginMiddleware() gin.HandlerFunc {
 		// Get the token from the Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Access Denied")
-			app.Logger.Warning("Request without AuthHeader: ", authHeader)
-			c.Abort()
+			app.requestLog(c).Warning("Request without AuthHeader")
+			app.problem(c, pkg.NewProblem(http.StatusUnauthorized, pkg.CodeUnauthorized, "Access Denied", "Authorization header is missing"))
 			return
 		}
 
 		// Extract the token from the Authorization header
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == "" {
-			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Invalid Input Auth Header")
-			app.Logger.Warning("Invalid Auth Header: ", tokenString)
-			c.Abort()
+			app.requestLog(c).Warning("Invalid Auth Header")
+			app.problem(c, pkg.NewProblem(http.StatusUnauthorized, pkg.CodeUnauthorized, "Access Denied", "Authorization header must be Bearer <token>"))
 			return
 		}
 
-		err := godotenv.Load()
-		if err != nil {
-			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
-			app.Logger.Errorf("Error fetching info from env: %T ", err)
-			c.Abort()
+		if strings.HasPrefix(tokenString, models.APITokenPrefix) {
+			// without a database there are no API tokens to look up
+			if app.Model.UsersORM == nil {
+				app.errorResponse(c, pkg.ErrInvalidToken)
+				return
+			}
+
+			token, user, err := app.Model.UsersORM.AuthenticateAPIToken(c.Request.Context(), tokenString)
+			if err != nil {
+				app.requestLog(c).Warn("Error authenticating API token:", err)
+				app.errorResponse(c, pkg.ErrInvalidToken)
+				return
+			}
+
+			c.Set(ctxUserID, user.ID)
+			c.Set(ctxEmail, user.Email)
+			c.Set(ctxAPIToken, token)
+			c.Next()
 			return
 		}
 
-		// Parse the token
-		SIGNING_KEY := os.Getenv("SIGNING_KEY")
-		if SIGNING_KEY == "" {
-			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
-			app.Logger.Error("Error fetching info from env: ", SIGNING_KEY)
-			c.Abort()
+		claims, err := app.Model.Users.ParseToken(tokenString)
+		if err != nil || !claims.IsSession() {
+			app.requestLog(c).Warn("Error fetching info from token:", err)
+			app.errorResponse(c, pkg.ErrInvalidToken)
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(tokenString, &models.MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Verify the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("[error] Unexpected signing method: %v", token.Header["alg"])
-			}
-
-			// Return the secret key
-			return []byte(SIGNING_KEY), nil
-		})
+		// Set the user in the request context
+		c.Set(ctxUserID, claims.UserID)
+		c.Set(ctxEmail, claims.Email)
+		c.Next()
+	}
+}
 
-		if err != nil {
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
-			app.Logger.Error("Error fetching info from token:", err)
-			c.Abort()
+// requireScope lets session tokens through and API tokens only when they
+// were granted scope.
+func (app *Application) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, ok := c.Get(ctxAPIToken); ok && !token.(*models.APIToken).HasScope(scope) {
+			app.problem(c, pkg.NewProblem(http.StatusForbidden, pkg.CodeInsufficientScope, "Insufficient scope", "API token lacks the "+scope+" scope"))
 			return
 		}
 
-		// Check if the token is valid
-		if claims, ok := token.Claims.(*models.MyCustomClaims); ok && token.Valid {
-			// Set the username in the request context
-			app.UserID = claims.UserID
-			app.Email = claims.Email
-			app.isAuthenticated = true
+		c.Next()
+	}
+}
+
+// projectLogin logs in callers of the public task listing that narrow it
+// with project_id, projects are private to their user and ListTask checks
+// whose it is. Listings without it stay open to everybody.
+func (app *Application) projectLogin() gin.HandlerFunc {
+	login, scope := app.LoginMiddleware(), app.requireScope(models.ScopeTasksRead)
+	return func(c *gin.Context) {
+		if c.Query("project_id") == "" {
 			c.Next()
-		} else {
-			app.Logger.Warning("Invalid Token")
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
-			c.Abort()
 			return
 		}
+
+		login(c)
+		if !c.IsAborted() {
+			scope(c)
+		}
 	}
 }
 
-// func (app *Application) rateLimit() gin.HandlerFunc {
-// 	return func(c *gin.Context) {
-// 		var (
-// 			mu      sync.Mutex
-// 			clients = make(map[string]*rate.Limiter)
-// 		)
-// 		ip, _, err := net.SplitHostPort(c.RemoteIP())
-// 		if err != nil {
-// 			app.Logger.Errorf("Internal Error: %T", err)
-// 			app.sendJSONResponse(c.Writer, 500, "Internal Server Error")
-// 			return
-// 		}
-// 		mu.Lock()
-// 		if _, found := clients[ip]; !found {
-// 			clients[ip] = rate.NewLimiter(2, 4)
-// 		}
-// 		if !clients[ip].Allow() {
-// 			mu.Unlock()
-// 			app.Logger.Error("Too many Requests")
-// 			app.sendJSONResponse(c.Writer, 500, "Internal Server Error")
-// 			return
-// 		}
-// 		mu.Unlock()
-// 	}
-// }
-
-func (app *Application) rateLimiter() gin.HandlerFunc {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+// requireWorkspaceRole lets members of the workspace of the route through
+// when their role is at least least. To everybody else the workspace is not
+// found.
+func (app *Application) requireWorkspaceRole(least string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceID, err := strconv.ParseUint(c.Param("workspace"), 10, 32)
+		if err != nil {
+			app.badRequest(c, "workspace must be a number")
+			return
+		}
 
-	// Declare a mutex and a map to hold the clients' IP addresses and rate limiters.
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	go func() {
-		for {
-			// it will run until code run but take break every minute laziness
-			time.Sleep(time.Minute)
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+		member, err := app.Model.Workspaces.Member(c.Request.Context(), uint(workspaceID), c.GetUint(ctxUserID))
+		if err != nil {
+			app.errorResponse(c, err)
+			return
+		}
 
-			mu.Unlock()
+		if !models.WorkspaceRoleAllows(member.Role, least) {
+			app.errorResponse(c, pkg.ErrWorkspaceRole)
+			return
 		}
-	}()
 
+		c.Set(ctxWorkspaceID, member.WorkspaceID)
+		c.Set(ctxWorkspaceRole, member.Role)
+		c.Next()
+	}
+}
+
+// sessionOnly keeps API tokens away from account management, a leaked
+// token must not be able to mint more tokens or switch off MFA.
+func (app *Application) sessionOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip, _, err := net.SplitHostPort(c.ClientIP())
-		if err != nil {
-			ip := net.ParseIP(c.ClientIP())
-			if ip == nil {
-				app.Logger.Warn("Invalid IP address :", c.ClientIP())
-				app.ServerError(c.Writer, err)
-				return
-			}
+		if _, ok := c.Get(ctxAPIToken); ok {
+			app.problem(c, pkg.NewProblem(http.StatusForbidden, pkg.CodeForbidden, "Forbidden", "Not available to API tokens"))
+			return
 		}
-		// Lock the mutex to prevent this code from being executed concurrently.
 
-		mu.Lock()
-		if _, found := clients[ip]; !found {
-			// Create and add a new client struct to the map if it doesn't already exist.
-			clients[ip] = &client{
-				limiter: rate.NewLimiter(rate.Limit(5), 3),
-			}
+		c.Next()
+	}
+}
+
+// databaseOnly answers routes of the database backed models, MFA, single
+// sign-on, lockouts, API tokens and the signing keys, with 501 when the
+// models are the in-memory ones.
+func (app *Application) databaseOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.Model.UsersORM == nil || app.Model.Signer == nil {
+			app.errorResponse(c, pkg.ErrNeedsDatabase)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimiter applies the named budget of a route group. Identities are API
+// tokens or users once LoginMiddleware has run and client IPs otherwise.
+// Without a Limiter, as with the in-memory models, nothing is limited.
+func (app *Application) rateLimiter(name string, limit config.RateLimit) gin.HandlerFunc {
+	policy := models.RateLimitPolicy{Name: name, Limit: limit.Limit, Period: limit.Period, Burst: limit.Burst}
+	return func(c *gin.Context) {
+		if app.Model.Limiter == nil {
+			c.Next()
+			return
 		}
 
-		clients[ip].lastSeen = time.Now()
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
-			app.CustomError(c.Writer, http.StatusTooManyRequests, "Too, many request. Rate Limit Exceed")
+		result := app.Model.Limiter.Allow(c.Request.Context(), policy, rateLimitKey(c))
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", policy.Limit, int(policy.Period.Seconds()), policy.Burst))
+		if !result.Allowed {
+			metrics.RateLimitRejections.WithLabelValues(name).Inc()
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			app.requestLog(c).Warn("Rate limit ", name, " exceeded")
+			app.problem(c, pkg.NewProblem(http.StatusTooManyRequests, pkg.CodeRateLimited, "Too Many Requests", "Rate limit exceeded, retry later"))
 			return
 		}
 
-		mu.Unlock()
-		// c.Next()
+		c.Next()
+	}
+}
+
+func rateLimitKey(c *gin.Context) string {
+	if token, ok := c.Get(ctxAPIToken); ok {
+		return fmt.Sprintf("key:%d", token.(*models.APIToken).ID)
+	}
+
+	if userID, ok := c.Get(ctxUserID); ok {
+		return fmt.Sprintf("user:%d", userID)
 	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
 }
 
 func (app *Application) TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
@@ -197,24 +323,21 @@ func (app *Application) TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc
 		// Continue to the next handler
 		c.Next()
 
-		// If the context was canceled, return timeout error
-		if ctx.Err() == context.DeadlineExceeded {
-			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "request timeout"})
-			c.Abort()
+		// If the context was canceled, return timeout error unless the
+		// handler already answered
+		if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
+			app.problem(c, pkg.NewProblem(http.StatusGatewayTimeout, pkg.CodeTimeout, "Request timed out", ""))
 			return
 		}
 	}
 }
 
-func MaintenanceMiddleware() gin.HandlerFunc {
+func (app *Application) MaintenanceMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole := c.GetHeader("X-User-Role") // only open for me
 
-		if os.Getenv("SERVER_STATUS") == "maintenance" && userRole != "admin" {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"message": "The server is currently under maintenance. Please try again later.",
-			})
-			c.Abort()
+		if app.Config.App.ServerStatus == "maintenance" && userRole != "admin" {
+			app.problem(c, pkg.NewProblem(http.StatusServiceUnavailable, pkg.CodeMaintenance, "Service Unavailable", "The server is currently under maintenance. Please try again later."))
 			return
 		}
 		c.Next()
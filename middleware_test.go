@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/metrics"
+	"github.com/iamgak/go-task/models"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func testLimiter(t *testing.T, logger *logrus.Logger) (*models.RateLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return models.NewRateLimiter(client, logger), server
+}
+
+func serve(r http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader = strings.NewReader(string(encoded))
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body)
+	}
+}
+
+func TestPanicsAreCountedAs500(t *testing.T) {
+	r := testRouter(t)
+	r.GET("/panics", func(c *gin.Context) { panic("boom") })
+	requests := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/panics", "500")
+	before := testutil.ToFloat64(requests)
+	expectStatus(t, serve(r, http.MethodGet, "/panics", "", nil), http.StatusInternalServerError)
+	if got := testutil.ToFloat64(requests) - before; got != 1 {
+		t.Fatalf("counted %v panicking requests, want 1", got)
+	}
+}
+
+func TestLegacyRejectionsAreDeprecated(t *testing.T) {
+	_, r, _ := memoryApp(t)
+	routes := []struct{ method, path, successor string }{
+		{http.MethodPost, "/tasks/", "/v1/tasks"},
+		{http.MethodPut, "/tasks/update/7", "/v1/tasks/7"},
+		{http.MethodDelete, "/tasks/delete/7", "/v1/tasks/7"},
+		{http.MethodPost, "/me/tokens", "/v1/me/tokens"},
+	}
+
+	for _, route := range routes {
+		w := serve(r, route.method, route.path, "", nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+		if w.Header().Get("Deprecation") == "" || w.Header().Get("Sunset") == "" {
+			t.Errorf("%s %s rejected without Deprecation and Sunset headers", route.method, route.path)
+		}
+
+		if link, want := w.Header().Get("Link"), "<"+route.successor+`>; rel="successor-version"`; link != want {
+			t.Errorf("%s %s Link = %q, want %q", route.method, route.path, link, want)
+		}
+	}
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 20; i++ {
+		if w = serve(r, http.MethodPost, "/login", "", map[string]string{}); w.Code == http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if link := w.Header().Get("Link"); link != `</v1/login>; rel="successor-version"` {
+		t.Fatalf("rate limited legacy login Link = %q", link)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	_, r, _ := memoryApp(t)
+	// the login budget of config.Default: 10 a minute, 5 at once
+	for want := 4; want >= 0; want-- {
+		w := serve(r, http.MethodPost, "/v1/login", "", map[string]string{})
+		expectStatus(t, w, http.StatusBadRequest)
+		headers := w.Header()
+		if headers.Get("RateLimit-Limit") != "10" || headers.Get("RateLimit-Remaining") != strconv.Itoa(want) || headers.Get("RateLimit-Policy") != "10;w=60;burst=5" {
+			t.Fatalf("RateLimit headers = %v, want %d remaining", headers, want)
+		}
+
+		if reset, _ := strconv.Atoi(headers.Get("RateLimit-Reset")); reset < 1 {
+			t.Fatalf("RateLimit-Reset = %q", headers.Get("RateLimit-Reset"))
+		}
+	}
+
+	w := serve(r, http.MethodPost, "/v1/login", "", map[string]string{})
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 1 || retry > 6 {
+		t.Fatalf("Retry-After = %q, want up to the 6s interval", w.Header().Get("Retry-After"))
+	}
+
+	if w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("RateLimit-Remaining of a rejection = %q", w.Header().Get("RateLimit-Remaining"))
+	}
+
+	var problem pkg.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Code != pkg.CodeRateLimited {
+		t.Fatalf("rejection body = %s, want a %s problem", w.Body, pkg.CodeRateLimited)
+	}
+
+	// the limit is shared by the routes of the group, not per route
+	expectStatus(t, serve(r, http.MethodPost, "/v1/register", "", map[string]string{}), http.StatusTooManyRequests)
+}
+
+func expectProblem(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	expectStatus(t, w, status)
+	var problem pkg.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || problem.Code != code {
+		t.Fatalf("body = %s, want a %s problem", w.Body, code)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	app, r := ormApp(t)
+	userID, session := signUp(t, app.Model.Users, "ada@example.com")
+	tokens := map[string]string{}
+	var readID uint
+	for _, scope := range []string{models.ScopeTasksRead, models.ScopeTasksWrite} {
+		w := serve(r, http.MethodPost, "/v1/me/tokens", session, map[string]any{"name": scope, "scopes": []string{scope}})
+		expectStatus(t, w, http.StatusCreated)
+		var created struct {
+			Token    string          `json:"token"`
+			APIToken models.APIToken `json:"api_token"`
+		}
+
+		decode(t, w, &created)
+		tokens[scope] = created.Token
+		if scope == models.ScopeTasksRead {
+			readID = created.APIToken.ID
+		}
+	}
+
+	// the write token gets past the scope check, to the validation of the
+	// empty task
+	read, write := tokens[models.ScopeTasksRead], tokens[models.ScopeTasksWrite]
+	expectProblem(t, serve(r, http.MethodPost, "/v1/tasks", read, map[string]string{}), http.StatusForbidden, pkg.CodeInsufficientScope)
+	expectStatus(t, serve(r, http.MethodPost, "/v1/tasks", write, map[string]string{}), http.StatusBadRequest)
+
+	// a leaked token must not manage the account, whatever its scopes
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/v1/me/tokens"},
+		{http.MethodPost, "/v1/me/tokens"},
+		{http.MethodDelete, fmt.Sprintf("/v1/me/tokens/%d", readID)},
+		{http.MethodPost, "/v1/me/mfa/enroll"},
+		{http.MethodPost, "/me/tokens"},
+	} {
+		for _, token := range []string{read, write} {
+			w := serve(r, route.method, route.path, token, map[string]any{"name": "more", "scopes": []string{models.ScopeTasksWrite}})
+			expectProblem(t, w, http.StatusForbidden, pkg.CodeForbidden)
+		}
+	}
+
+	// nor the members of the workspaces, though tasks:write lets it change
+	// their tasks
+	w := serve(r, http.MethodPost, "/v1/workspaces", session, map[string]string{"name": "Team"})
+	expectStatus(t, w, http.StatusCreated)
+	var workspace models.Workspace
+	decode(t, w, &workspace)
+
+	base := fmt.Sprintf("/v1/workspaces/%d", workspace.ID)
+	expectStatus(t, serve(r, http.MethodPost, base+"/tasks", write, map[string]string{"title": "Taxes", "description": "File them", "status": "pending"}), http.StatusCreated)
+	member := fmt.Sprintf("%s/members/%d", base, userID)
+	for _, route := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodPost, base + "/invitations", map[string]string{"email": "mallory@example.com", "role": models.WorkspaceOwner}},
+		{http.MethodPatch, member, map[string]string{"role": models.WorkspaceViewer}},
+		{http.MethodDelete, member, nil},
+	} {
+		expectProblem(t, serve(r, route.method, route.path, write, route.body), http.StatusForbidden, pkg.CodeForbidden)
+	}
+
+	if _, err := app.Model.Workspaces.Member(context.Background(), workspace.ID, userID); err != nil {
+		t.Fatalf("owner is no longer a member: %v", err)
+	}
+
+	expectStatus(t, serve(r, http.MethodDelete, fmt.Sprintf("/v1/me/tokens/%d", readID), session, nil), http.StatusOK)
+	expectProblem(t, serve(r, http.MethodPost, "/v1/tasks", read, map[string]string{}), http.StatusUnauthorized, pkg.CodeInvalidToken)
+	expectStatus(t, serve(r, http.MethodGet, "/v1/me/tokens", session, nil), http.StatusOK)
+}
+
+func TestMemoryModelsWithoutTheDatabaseFeatures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	app := &Application{Model: models.NewMemoryInit(&cfg), Config: &cfg, Logger: quietLogger()}
+	r := app.InitRouter()
+	_, session := signUp(t, app.Model.Users, "ada@example.com")
+
+	expectProblem(t, serve(r, http.MethodPost, "/v1/tasks", models.APITokenPrefix+"leaked", map[string]string{}), http.StatusUnauthorized, pkg.CodeInvalidToken)
+	expectProblem(t, serve(r, http.MethodGet, "/.well-known/jwks.json", "", nil), http.StatusNotImplemented, pkg.CodeNotImplemented)
+	expectProblem(t, serve(r, http.MethodPost, "/v1/login/mfa", "", map[string]string{}), http.StatusNotImplemented, pkg.CodeNotImplemented)
+	expectProblem(t, serve(r, http.MethodPost, "/v1/me/mfa/enroll", session, nil), http.StatusNotImplemented, pkg.CodeNotImplemented)
+	expectProblem(t, serve(r, http.MethodGet, "/v1/me/tokens", session, nil), http.StatusNotImplemented, pkg.CodeNotImplemented)
+	expectProblem(t, serve(r, http.MethodGet, "/unlock_account/abc", "", nil), http.StatusNotImplemented, pkg.CodeNotImplemented)
+
+	// nothing is rate limited without a Limiter
+	w := serve(r, http.MethodGet, "/v1/tasks", session, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("RateLimit headers without a Limiter: %v", w.Header())
+	}
+
+	expectStatus(t, serve(r, http.MethodPost, "/v1/tasks", session, map[string]string{"title": "Taxes", "description": "File them", "status": "pending"}), http.StatusCreated)
+}
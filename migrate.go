@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/iamgak/go-task/migrations"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status | force <version>"
+
+// runMigrate is the migrate subcommand. It needs nothing but the database
+// and returns the process exit code.
+func runMigrate(c *cli, args []string) int {
+	if len(args) == 0 {
+		return c.usageError(migrateUsage)
+	}
+
+	cfg, logger := c.cfg, c.logger
+	db, err := openDBORM(cfg.DB)
+	if err != nil {
+		logger.Error("Error creating db connection : ", err)
+		return 1
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Error("Error creating db connection : ", err)
+		return 1
+	}
+
+	defer sqlDB.Close()
+	migrator, err := migrations.New(sqlDB, cfg.DB.Driver, logger)
+	if err != nil {
+		logger.Error("Error loading migrations : ", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			logger.Error("Migrating up failed : ", err)
+			return 1
+		}
+
+		logger.Info("Applied ", applied, " migrations")
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return c.usageError(migrateUsage)
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			logger.Error("Migrating down failed : ", err)
+			return 1
+		}
+
+		logger.Info("Reverted ", reverted, " migrations")
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			logger.Error("Reading migration status failed : ", err)
+			return 1
+		}
+
+		c.print(statuses, func(w io.Writer) {
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+			for _, status := range statuses {
+				state, appliedAt := "pending", ""
+				if status.Applied {
+					state, appliedAt = "applied", status.AppliedAt.Local().Format(time.RFC3339)
+				}
+
+				if status.Dirty {
+					state = "dirty"
+				}
+
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+			}
+		})
+	case args[0] == "force" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return c.usageError(migrateUsage)
+		}
+
+		if err := migrator.Force(ctx, version); err != nil {
+			logger.Error("Forcing the version failed : ", err)
+			return 1
+		}
+
+		logger.Info("Forced the schema to version ", version)
+	default:
+		return c.usageError(migrateUsage)
+	}
+
+	return 0
+}
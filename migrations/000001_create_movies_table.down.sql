@@ -1,3 +0,0 @@
-DROP TABLE users;
-DROP TABLE users_sessions;
-DROP TABLE tasks;
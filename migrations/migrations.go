@@ -0,0 +1,513 @@
+// Package migrations holds the versioned database schema and applies it.
+// Every driver has a directory of NNNNNN_name.up.sql and .down.sql files,
+// embedded in the binary. Applied versions are recorded in
+// schema_migrations.
+//
+// Statements in a file are separated by a semicolon at the end of a line.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+const lockName = "go_task_schema_migrations"
+
+// how long a replica waits for another to finish migrating
+const lockTimeout = 5 * time.Minute
+
+var (
+	fileName    = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	placeholder = regexp.MustCompile(`\?`)
+)
+
+var schemaTable = map[string]string{
+	"mysql":    "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, dirty BOOLEAN NOT NULL, applied_at DATETIME(3) NOT NULL)",
+	"postgres": "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name TEXT NOT NULL, dirty BOOLEAN NOT NULL, applied_at TIMESTAMPTZ NOT NULL)",
+	"sqlite":   "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, dirty NUMERIC NOT NULL, applied_at DATETIME NOT NULL)",
+}
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status is a migration and whether it is applied. Dirty ones failed half
+// way and need a look and `migrate force` before anything else runs.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	Dirty     bool       `json:"dirty"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Load reads the migrations of driver in version order.
+func Load(driver string) ([]Migration, error) {
+	if _, ok := schemaTable[driver]; !ok {
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s/%s: not NNNNNN_name.up.sql or .down.sql", driver, entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := files.ReadFile(path.Join(driver, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is both %s and %s", driver, version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.up = string(body)
+		} else {
+			migration.down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("%s: version %d needs an up and a down file", driver, migration.Version)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// statements splits a file at semicolons ending a line.
+func statements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+
+	return stmts
+}
+
+// Migrator applies the migrations of one database. Only one replica at a
+// time gets to, the others wait on an advisory lock.
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	logger     *logrus.Logger
+	migrations []Migration
+}
+
+func New(db *sql.DB, driver string, logger *logrus.Logger) (*Migrator, error) {
+	migrations, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, driver: driver, logger: logger, migrations: migrations}, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// bind turns ? placeholders into the $n ones Postgres wants.
+func (m *Migrator) bind(query string) string {
+	if m.driver != "postgres" {
+		return query
+	}
+
+	n := 0
+	return placeholder.ReplaceAllStringFunc(query, func(string) string {
+		n++
+		return "$" + strconv.Itoa(n)
+	})
+}
+
+// transactional drivers roll DDL back, MySQL commits every statement.
+func (m *Migrator) transactional() bool {
+	return m.driver != "mysql"
+}
+
+// lock takes the advisory lock on a connection of its own and creates the
+// bookkeeping table. Everything else must run on the returned connection.
+func (m *Migrator) lock(ctx context.Context) (*sql.Conn, func(), error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unlock := func() { conn.Close() }
+	switch m.driver {
+	case "mysql":
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&got); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+
+		if got.Int64 != 1 {
+			conn.Close()
+			return nil, nil, errors.New("timed out waiting for another replica to finish migrating")
+		}
+
+		unlock = func() {
+			conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+			conn.Close()
+		}
+	case "postgres":
+		key := advisoryKey()
+		lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+
+		unlock = func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+			conn.Close()
+		}
+	}
+
+	// SQLite has a single writer anyway and OpenDB gives it one connection
+
+	if _, err := conn.ExecContext(ctx, schemaTable[m.driver]); err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	return conn, unlock, nil
+}
+
+func advisoryKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}
+
+type record struct {
+	name      string
+	dirty     bool
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]record, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, dirty, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	records := map[int64]record{}
+	for rows.Next() {
+		var version int64
+		var r record
+		if err := rows.Scan(&version, &r.name, &r.dirty, &r.appliedAt); err != nil {
+			return nil, err
+		}
+
+		records[version] = r
+	}
+
+	return records, rows.Err()
+}
+
+func dirtyVersion(records map[int64]record) (int64, bool) {
+	for version, r := range records {
+		if r.dirty {
+			return version, true
+		}
+	}
+
+	return 0, false
+}
+
+// Up applies every pending migration and returns how many it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer unlock()
+	records, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if version, dirty := dirtyVersion(records); dirty {
+		return 0, fmt.Errorf("version %d is dirty, fix the schema by hand and run migrate force", version)
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := records[migration.Version]; ok {
+			continue
+		}
+
+		m.logger.Info("Applying migration ", migration.Version, " ", migration.Name)
+		if err := m.run(ctx, conn, migration, true); err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Down reverts the last steps applied migrations and returns how many it
+// reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer unlock()
+	records, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if version, dirty := dirtyVersion(records); dirty {
+		return 0, fmt.Errorf("version %d is dirty, fix the schema by hand and run migrate force", version)
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := records[migration.Version]; !ok {
+			continue
+		}
+
+		m.logger.Info("Reverting migration ", migration.Version, " ", migration.Name)
+		if err := m.run(ctx, conn, migration, false); err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// run applies or reverts one migration. On transactional drivers it and its
+// record commit together, on MySQL the record is marked dirty until every
+// statement went through.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	script := migration.down
+	if up {
+		script = migration.up
+	}
+
+	finish := func(db execer) error {
+		if _, err := db.ExecContext(ctx, m.bind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version); err != nil || !up {
+			return err
+		}
+
+		_, err := db.ExecContext(ctx, m.bind("INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, ?, ?)"), migration.Version, migration.Name, false, time.Now().UTC())
+		return err
+	}
+
+	if !m.transactional() {
+		if err := m.markDirty(ctx, conn, migration); err != nil {
+			return err
+		}
+
+		for _, stmt := range statements(script) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+
+		return finish(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	for _, stmt := range statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := finish(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) markDirty(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	result, err := conn.ExecContext(ctx, m.bind("UPDATE schema_migrations SET dirty = ? WHERE version = ?"), true, migration.Version)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, m.bind("INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, ?, ?)"), migration.Version, migration.Name, true, time.Now().UTC())
+	return err
+}
+
+// Status lists the known migrations and any applied by a newer binary.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+	records, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if r, ok := records[migration.Version]; ok {
+			appliedAt := r.appliedAt
+			status.Applied, status.Dirty, status.AppliedAt = true, r.dirty, &appliedAt
+			delete(records, migration.Version)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for version, r := range records {
+		appliedAt := r.appliedAt
+		statuses = append(statuses, Status{Version: version, Name: r.name, Applied: true, Dirty: r.dirty, AppliedAt: &appliedAt})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Pending counts the migrations Up would apply.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+// Force records the schema as being at version, clean, without running
+// anything. It is the way out after fixing a dirty migration by hand and
+// for adopting a database that already has the schema. 0 clears the
+// record.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	known := version == 0
+	for _, migration := range m.migrations {
+		known = known || migration.Version == version
+	}
+
+	if !known {
+		return fmt.Errorf("unknown version %d", version)
+	}
+
+	conn, unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.bind("DELETE FROM schema_migrations WHERE version > ? OR dirty = ?"), version, true); err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+
+		var exists int
+		err := tx.QueryRowContext(ctx, m.bind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), migration.Version).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if exists > 0 {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, m.bind("INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, ?, ?)"), migration.Version, migration.Name, false, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	m.logger.Info("Forced schema version ", version)
+	return tx.Commit()
+}
@@ -0,0 +1,273 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openSQLite(t *testing.T, log logger.Interface) *gorm.DB {
+	t.Helper()
+	db, err := models.OpenDB(config.DBConfig{Driver: "sqlite", Database: ":memory:"}, &gorm.Config{Logger: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+func newMigrator(t *testing.T, db *gorm.DB) *Migrator {
+	t.Helper()
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.WarnLevel)
+	m, err := New(sqlDB, "sqlite", log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// recorder keeps the statements GORM runs.
+type recorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *recorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+func TestEveryDriverHasTheSameVersions(t *testing.T) {
+	var want []string
+	for _, driver := range []string{"mysql", "postgres", "sqlite"} {
+		migrations, err := Load(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, migration := range migrations {
+			got = append(got, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+		}
+
+		if want == nil {
+			want = got
+		} else if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s has migrations %v, mysql has %v", driver, got, want)
+		}
+	}
+}
+
+// TestSchemaMatchesModels migrates one database and lets AutoMigrate build
+// another from the models, the two must have the same tables, columns and
+// indexes. AutoMigrate on the migrated one then has to find nothing to do.
+func TestSchemaMatchesModels(t *testing.T) {
+	ctx := context.Background()
+	migrated := openSQLite(t, logger.Discard)
+	if _, err := newMigrator(t, migrated).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reference := openSQLite(t, logger.Discard)
+	if err := reference.AutoMigrate(models.Tables...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := tables(t, migrated), tables(t, reference)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("migrations create tables %v, the models have %v", got, want)
+	}
+
+	for _, table := range want {
+		if got, want := columns(t, migrated, table), columns(t, reference, table); got != want {
+			t.Errorf("%s columns\nmigrated: %s\nmodels:   %s", table, got, want)
+		}
+
+		if got, want := indexes(t, migrated, table), indexes(t, reference, table); got != want {
+			t.Errorf("%s indexes\nmigrated: %s\nmodels:   %s", table, got, want)
+		}
+	}
+
+	rec := &recorder{Interface: logger.Discard}
+	if err := migrated.Session(&gorm.Session{Logger: rec}).AutoMigrate(models.Tables...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stmt := range rec.statements {
+		upper := strings.ToUpper(strings.TrimSpace(stmt))
+		if strings.HasPrefix(upper, "CREATE") || strings.HasPrefix(upper, "ALTER") || strings.HasPrefix(upper, "DROP") {
+			t.Errorf("AutoMigrate would change the migrated schema: %s", stmt)
+		}
+	}
+}
+
+func tables(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+	names, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept []string
+	for _, name := range names {
+		if name != "schema_migrations" && name != "sqlite_sequence" {
+			kept = append(kept, name)
+		}
+	}
+
+	sort.Strings(kept)
+	return kept
+}
+
+func columns(t *testing.T, db *gorm.DB, table string) string {
+	t.Helper()
+	types, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cols []string
+	for _, column := range types {
+		nullable, _ := column.Nullable()
+		primary, _ := column.PrimaryKey()
+		cols = append(cols, fmt.Sprintf("%s %s null=%t pk=%t", column.Name(), strings.ToLower(column.DatabaseTypeName()), nullable, primary))
+	}
+
+	sort.Strings(cols)
+	return strings.Join(cols, ", ")
+}
+
+func indexes(t *testing.T, db *gorm.DB, table string) string {
+	t.Helper()
+	found, err := db.Migrator().GetIndexes(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, index := range found {
+		unique, _ := index.Unique()
+		names = append(names, fmt.Sprintf("%s(%s) unique=%t", index.Name(), strings.Join(index.Columns(), ","), unique))
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t, logger.Discard)
+	m := newMigrator(t, db)
+
+	pending, err := m.Pending(ctx)
+	if err != nil || pending != len(m.migrations) {
+		t.Fatalf("Pending on an empty database = %d, %v, want %d", pending, err, len(m.migrations))
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != len(m.migrations) {
+		t.Fatalf("Up = %d, %v", n, err)
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("second Up = %d, %v, want nothing to do", n, err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range statuses {
+		if !status.Applied || status.Dirty || status.AppliedAt == nil {
+			t.Fatalf("after Up %+v", status)
+		}
+	}
+
+	if n, err := m.Down(ctx, len(m.migrations)); err != nil || n != len(m.migrations) {
+		t.Fatalf("Down = %d, %v", n, err)
+	}
+
+	if left := tables(t, db); len(left) != 0 {
+		t.Fatalf("tables left after Down: %v", left)
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != len(m.migrations) {
+		t.Fatalf("Up after Down = %d, %v", n, err)
+	}
+}
+
+func TestDirtyNeedsForce(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t, logger.Discard)
+	m := newMigrator(t, db)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	last := m.migrations[len(m.migrations)-1].Version
+	if err := db.Exec("UPDATE schema_migrations SET dirty = ? WHERE version = ?", true, last).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Fatalf("Up on a dirty database = %v", err)
+	}
+
+	if err := m.Force(ctx, 999999); err == nil {
+		t.Fatal("Force accepted an unknown version")
+	}
+
+	if err := m.Force(ctx, last); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, _ := m.Status(ctx)
+	for _, status := range statuses {
+		if !status.Applied || status.Dirty {
+			t.Fatalf("after Force %+v", status)
+		}
+	}
+
+	// forcing 0 forgets every version without touching the tables
+	if err := m.Force(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, _ := m.Pending(ctx); pending != len(m.migrations) {
+		t.Fatalf("Pending after Force 0 = %d", pending)
+	}
+
+	if len(tables(t, db)) == 0 {
+		t.Fatal("Force dropped tables")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	got := statements("-- comment\nCREATE TABLE a (\n    id INT\n);\nCREATE INDEX b ON a (id);\n\nDROP TABLE c")
+	want := []string{"CREATE TABLE a (\n    id INT\n)", "CREATE INDEX b ON a (id)", "DROP TABLE c"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("statements = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+-- The schema AutoMigrate used to create. IF NOT EXISTS lets databases it
+-- created adopt this migration.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    email VARCHAR(191) NOT NULL,
+    hash_passw LONGTEXT NOT NULL,
+    activation_token VARCHAR(191),
+    active BOOLEAN DEFAULT false,
+    mfa_enabled BOOLEAN DEFAULT false,
+    mfa_secret LONGTEXT,
+    verified_at DATETIME(3) NULL DEFAULT NULL,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL DEFAULT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_users_activation_token (activation_token),
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS users_sessions (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED,
+    login_token LONGTEXT NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_users_sessions_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_activity_logs (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED,
+    activity LONGTEXT NOT NULL,
+    superseded BOOLEAN DEFAULT false,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL DEFAULT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_user_activity_logs_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED,
+    code_hash LONGTEXT NOT NULL,
+    used_at DATETIME(3) NULL DEFAULT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_user_recovery_codes_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    title LONGTEXT NOT NULL,
+    description LONGTEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    is_deleted BOOLEAN DEFAULT false,
+    due_at DATETIME(3) NULL DEFAULT NULL,
+    version BIGINT UNSIGNED DEFAULT 1,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL DEFAULT NULL,
+    deleted_at DATETIME(3) NULL DEFAULT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_tasks_user_id (user_id),
+    CONSTRAINT chk_tasks_status CHECK (status IN ('pending', 'in progress', 'completed'))
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name LONGTEXT NOT NULL,
+    prefix LONGTEXT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    scopes LONGTEXT NOT NULL,
+    expires_at DATETIME(3) NULL DEFAULT NULL,
+    last_used_at DATETIME(3) NULL DEFAULT NULL,
+    revoked_at DATETIME(3) NULL DEFAULT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_api_tokens_user_id (user_id),
+    UNIQUE INDEX idx_api_tokens_token_hash (token_hash)
+);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    kid VARCHAR(64) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    activates_at DATETIME(3) NOT NULL,
+    retires_at DATETIME(3) NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_signing_keys_activates_at (activates_at),
+    UNIQUE INDEX idx_signing_keys_kid (kid)
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_user_identities_user_id (user_id),
+    UNIQUE INDEX idx_provider_subject (provider, subject)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE tasks
+    DROP INDEX idx_tasks_project_id,
+    DROP COLUMN project_id;
+DROP TABLE projects;
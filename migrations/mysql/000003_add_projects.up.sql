@@ -0,0 +1,16 @@
+CREATE TABLE projects (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    color VARCHAR(7),
+    archived BOOLEAN DEFAULT false,
+    position BIGINT DEFAULT 0,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL DEFAULT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_projects_user_id (user_id)
+);
+
+ALTER TABLE tasks
+    ADD COLUMN project_id BIGINT UNSIGNED NULL,
+    ADD INDEX idx_tasks_project_id (project_id);
@@ -0,0 +1,6 @@
+ALTER TABLE tasks
+    DROP INDEX idx_tasks_workspace_id,
+    DROP COLUMN workspace_id;
+DROP TABLE workspace_invitations;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
@@ -0,0 +1,39 @@
+CREATE TABLE workspaces (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE workspace_members (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    workspace_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_workspace_members_member (workspace_id, user_id),
+    INDEX idx_workspace_members_user_id (user_id),
+    CONSTRAINT chk_workspace_members_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+
+CREATE TABLE workspace_invitations (
+    id BIGINT UNSIGNED AUTO_INCREMENT,
+    workspace_id BIGINT UNSIGNED NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    invited_by BIGINT UNSIGNED NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    accepted_at DATETIME(3) NULL DEFAULT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    INDEX idx_workspace_invitations_workspace_id (workspace_id),
+    UNIQUE INDEX idx_workspace_invitations_token_hash (token_hash),
+    CONSTRAINT chk_workspace_invitations_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+
+ALTER TABLE tasks
+    ADD COLUMN workspace_id BIGINT UNSIGNED NULL,
+    ADD INDEX idx_tasks_workspace_id (workspace_id);
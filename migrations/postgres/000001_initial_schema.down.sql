@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_activity_logs;
+DROP TABLE IF EXISTS users_sessions;
+DROP TABLE IF EXISTS users;
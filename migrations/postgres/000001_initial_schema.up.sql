@@ -0,0 +1,107 @@
+-- The schema AutoMigrate used to create. IF NOT EXISTS lets databases it
+-- created adopt this migration.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL,
+    email TEXT NOT NULL,
+    hash_passw TEXT NOT NULL,
+    activation_token TEXT,
+    active BOOLEAN DEFAULT false,
+    mfa_enabled BOOLEAN DEFAULT false,
+    mfa_secret TEXT,
+    verified_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+CREATE INDEX IF NOT EXISTS idx_users_activation_token ON users (activation_token);
+
+CREATE TABLE IF NOT EXISTS users_sessions (
+    id BIGSERIAL,
+    user_id BIGINT,
+    login_token TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_users_sessions_user_id ON users_sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS user_activity_logs (
+    id BIGSERIAL,
+    user_id BIGINT,
+    activity TEXT NOT NULL,
+    superseded BOOLEAN DEFAULT false,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_activity_logs_user_id ON user_activity_logs (user_id);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGSERIAL,
+    user_id BIGINT,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id BIGSERIAL,
+    user_id BIGINT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    is_deleted BOOLEAN DEFAULT false,
+    due_at TIMESTAMPTZ DEFAULT NULL,
+    version BIGINT DEFAULT 1,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NULL,
+    deleted_at TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT chk_tasks_status CHECK (status IN ('pending', 'in progress', 'completed'))
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks (user_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id BIGSERIAL,
+    user_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    last_used_at TIMESTAMPTZ DEFAULT NULL,
+    revoked_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id BIGSERIAL,
+    kid VARCHAR(64) NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    activates_at TIMESTAMPTZ NOT NULL,
+    retires_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_activates_at ON signing_keys (activates_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_kid ON signing_keys (kid);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id BIGSERIAL,
+    user_id BIGINT NOT NULL,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_provider_subject ON user_identities (provider, subject);
@@ -0,0 +1,15 @@
+CREATE TABLE projects (
+    id BIGSERIAL,
+    user_id BIGINT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    color VARCHAR(7),
+    archived BOOLEAN DEFAULT false,
+    position BIGINT DEFAULT 0,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_projects_user_id ON projects (user_id);
+
+ALTER TABLE tasks ADD COLUMN project_id BIGINT;
+CREATE INDEX idx_tasks_project_id ON tasks (project_id);
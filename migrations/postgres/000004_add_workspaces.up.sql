@@ -0,0 +1,38 @@
+CREATE TABLE workspaces (
+    id BIGSERIAL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE workspace_members (
+    id BIGSERIAL,
+    workspace_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id),
+    CONSTRAINT chk_workspace_members_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+CREATE UNIQUE INDEX idx_workspace_members_member ON workspace_members (workspace_id, user_id);
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE workspace_invitations (
+    id BIGSERIAL,
+    workspace_id BIGINT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    invited_by BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ DEFAULT NULL,
+    created_at TIMESTAMPTZ,
+    PRIMARY KEY (id),
+    CONSTRAINT chk_workspace_invitations_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+CREATE INDEX idx_workspace_invitations_workspace_id ON workspace_invitations (workspace_id);
+CREATE UNIQUE INDEX idx_workspace_invitations_token_hash ON workspace_invitations (token_hash);
+
+ALTER TABLE tasks ADD COLUMN workspace_id BIGINT;
+CREATE INDEX idx_tasks_workspace_id ON tasks (workspace_id);
@@ -0,0 +1,18 @@
+CREATE TABLE users_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    hash_passw TEXT NOT NULL,
+    activation_token TEXT,
+    active NUMERIC DEFAULT false,
+    mfa_enabled NUMERIC DEFAULT false,
+    mfa_secret TEXT,
+    verified_at DATETIME DEFAULT NULL,
+    created_at DATETIME,
+    updated_at DATETIME DEFAULT NULL,
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+INSERT INTO users_old (id, email, hash_passw, activation_token, active, mfa_enabled, mfa_secret, verified_at, created_at, updated_at)
+    SELECT id, email, hash_passw, activation_token, active, mfa_enabled, mfa_secret, verified_at, created_at, updated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+CREATE INDEX IF NOT EXISTS idx_users_activation_token ON users (activation_token);
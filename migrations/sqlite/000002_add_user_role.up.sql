@@ -0,0 +1,23 @@
+-- SQLite can't add table constraints, the table is rebuilt with it
+-- "user" is written the way GORM writes string defaults so AutoMigrate reads
+-- it back unchanged; SQLite takes it as a literal.
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    hash_passw TEXT NOT NULL,
+    activation_token TEXT,
+    active NUMERIC DEFAULT false,
+    mfa_enabled NUMERIC DEFAULT false,
+    mfa_secret TEXT,
+    role TEXT NOT NULL DEFAULT "user",
+    verified_at DATETIME DEFAULT NULL,
+    created_at DATETIME,
+    updated_at DATETIME DEFAULT NULL,
+    CONSTRAINT uni_users_email UNIQUE (email),
+    CONSTRAINT chk_users_role CHECK (role IN ('user', 'admin'))
+);
+INSERT INTO users_new (id, email, hash_passw, activation_token, active, mfa_enabled, mfa_secret, verified_at, created_at, updated_at)
+    SELECT id, email, hash_passw, activation_token, active, mfa_enabled, mfa_secret, verified_at, created_at, updated_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX IF NOT EXISTS idx_users_activation_token ON users (activation_token);
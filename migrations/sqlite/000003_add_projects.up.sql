@@ -0,0 +1,14 @@
+CREATE TABLE projects (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    color TEXT,
+    archived NUMERIC DEFAULT false,
+    position INTEGER DEFAULT 0,
+    created_at DATETIME,
+    updated_at DATETIME DEFAULT NULL
+);
+CREATE INDEX idx_projects_user_id ON projects (user_id);
+
+ALTER TABLE tasks ADD COLUMN project_id INTEGER;
+CREATE INDEX idx_tasks_project_id ON tasks (project_id);
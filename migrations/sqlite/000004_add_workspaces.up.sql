@@ -0,0 +1,35 @@
+CREATE TABLE workspaces (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at DATETIME,
+    updated_at DATETIME DEFAULT NULL
+);
+
+CREATE TABLE workspace_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    created_at DATETIME,
+    CONSTRAINT chk_workspace_members_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+CREATE UNIQUE INDEX idx_workspace_members_member ON workspace_members (workspace_id, user_id);
+CREATE INDEX idx_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE workspace_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER NOT NULL,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    invited_by INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    accepted_at DATETIME DEFAULT NULL,
+    created_at DATETIME,
+    CONSTRAINT chk_workspace_invitations_role CHECK (role IN ('viewer', 'editor', 'owner'))
+);
+CREATE INDEX idx_workspace_invitations_workspace_id ON workspace_invitations (workspace_id);
+CREATE UNIQUE INDEX idx_workspace_invitations_token_hash ON workspace_invitations (token_hash);
+
+ALTER TABLE tasks ADD COLUMN workspace_id INTEGER;
+CREATE INDEX idx_tasks_workspace_id ON tasks (workspace_id);
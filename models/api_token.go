@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/pkg"
+)
+
+const (
+	// APITokenPrefix marks personal access tokens so LoginMiddleware can tell
+	// them apart from JWTs without a database round trip.
+	APITokenPrefix = "gt_"
+
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+
+	apiTokenMaxDays = 365
+)
+
+var apiTokenScopes = []string{ScopeTasksRead, ScopeTasksWrite}
+
+// CreateAPIToken returns the plain token next to the stored row. Only its
+// hash is kept, so this is the one time it can be shown to the user.
+func (m *UserModelORM) CreateAPIToken(ctx context.Context, userID uint, input *APITokenStruct) (string, *APIToken, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plain := APITokenPrefix + secret
+	token := APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    plain[:len(APITokenPrefix)+6],
+		TokenHash: hashToken(plain),
+		Scopes:    input.Scopes,
+	}
+
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := m.db.WithContext(ctx).Create(&token).Error; err != nil {
+		return "", nil, err
+	}
+
+	activity := UserActivityLog{UserID: userID, Activity: "API Token Created"}
+	return plain, &token, m.UserActivityLog(&activity)
+}
+
+func (m *UserModelORM) ListAPITokens(ctx context.Context, userID uint) ([]*APIToken, error) {
+	var tokens []*APIToken
+	err := m.db.WithContext(ctx).Where("user_id = ? AND revoked_at IS NULL", userID).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (m *UserModelORM) RevokeAPIToken(ctx context.Context, userID, tokenID uint) error {
+	result := m.db.WithContext(ctx).Model(&APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return pkg.ErrNoRecord
+	}
+
+	activity := UserActivityLog{UserID: userID, Activity: "API Token Revoked"}
+	return m.UserActivityLog(&activity)
+}
+
+// AuthenticateAPIToken resolves a plain token to its row and owner. Usage
+// is recorded at most once a minute to keep reads from turning into writes.
+func (m *UserModelORM) AuthenticateAPIToken(ctx context.Context, plain string) (*APIToken, *User, error) {
+	var token APIToken
+	err := m.db.WithContext(ctx).Where("token_hash = ? AND revoked_at IS NULL", hashToken(plain)).First(&token).Error
+	if err != nil {
+		return nil, nil, pkg.ErrInvalidToken
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(now) {
+		return nil, nil, pkg.ErrInvalidToken
+	}
+
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "email", "active").First(&user, token.UserID).Error; err != nil || !user.Active {
+		return nil, nil, pkg.ErrInvalidToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		token.LastUsedAt = &now
+		if err := m.db.WithContext(ctx).Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			logging.FromContext(ctx, m.logger).Warn("Failed to record API token usage: ", err)
+		}
+	}
+
+	return &token, &user, nil
+}
+
+func (m *UserModelORM) ValidateAPITokenData(input *APITokenStruct) *pkg.Validator {
+	validator := &pkg.Validator{
+		Errors: make(map[string]string),
+	}
+
+	validator.CheckField(validator.NotBlank(input.Name), "name", "Please, fill the name field")
+	validator.CheckField(validator.MaxChars(input.Name, 100), "name", "Name should be at most 100 characters")
+	validator.CheckField(len(input.Scopes) > 0, "scopes", "Please, choose at least one scope")
+	for _, scope := range input.Scopes {
+		validator.CheckField(validator.PermittedValue(scope, apiTokenScopes...), "scopes", "Invalid scope "+scope)
+	}
+
+	validator.CheckField(input.ExpiresInDays >= 0 && input.ExpiresInDays <= apiTokenMaxDays, "expires_in_days", "Expiry should be between 0 (never) and 365 days")
+	return validator
+}
+
+// hashToken is what is stored of tokens handed out, API tokens and
+// workspace invitations.
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-task/pkg"
+)
+
+func TestAPITokens(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	ada := activeUser(t, m, "ada@example.com")
+	bob := activeUser(t, m, "bob@example.com")
+
+	plain, token, err := m.CreateAPIToken(ctx, ada.ID, &APITokenStruct{Name: " ci ", Scopes: []string{ScopeTasksRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(plain, APITokenPrefix) || token.Prefix != plain[:len(APITokenPrefix)+6] || token.Name != "ci" || token.ExpiresAt != nil {
+		t.Fatalf("CreateAPIToken = %q, %+v", plain, token)
+	}
+
+	var stored APIToken
+	m.db.First(&stored, token.ID)
+	if stored.TokenHash == plain || stored.TokenHash != hashToken(plain) {
+		t.Fatalf("stored token hash = %q, want the hash of the token", stored.TokenHash)
+	}
+
+	found, user, err := m.AuthenticateAPIToken(ctx, plain)
+	if err != nil || found.ID != token.ID || user.ID != ada.ID || user.Email != "ada@example.com" {
+		t.Fatalf("AuthenticateAPIToken = %+v, %+v, %v", found, user, err)
+	}
+
+	if !found.HasScope(ScopeTasksRead) || found.HasScope(ScopeTasksWrite) {
+		t.Fatalf("scopes = %v, want only %s", found.Scopes, ScopeTasksRead)
+	}
+
+	if m.db.First(&stored, token.ID); stored.LastUsedAt == nil {
+		t.Fatal("use of the token wasn't recorded")
+	}
+
+	for _, other := range []string{"", plain + "x", APITokenPrefix + strings.Repeat("0", 64)} {
+		if _, _, err := m.AuthenticateAPIToken(ctx, other); !errors.Is(err, pkg.ErrInvalidToken) {
+			t.Fatalf("AuthenticateAPIToken(%q) = %v, want ErrInvalidToken", other, err)
+		}
+	}
+
+	if tokens, err := m.ListAPITokens(ctx, bob.ID); err != nil || len(tokens) != 0 {
+		t.Fatalf("tokens of another user = %v, %v", tokens, err)
+	}
+
+	if err := m.RevokeAPIToken(ctx, bob.ID, token.ID); !errors.Is(err, pkg.ErrNoRecord) {
+		t.Fatalf("RevokeAPIToken by another user = %v, want ErrNoRecord", err)
+	}
+
+	if err := m.RevokeAPIToken(ctx, ada.ID, token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.AuthenticateAPIToken(ctx, plain); !errors.Is(err, pkg.ErrInvalidToken) {
+		t.Fatalf("AuthenticateAPIToken of a revoked token = %v, want ErrInvalidToken", err)
+	}
+
+	if err := m.RevokeAPIToken(ctx, ada.ID, token.ID); !errors.Is(err, pkg.ErrNoRecord) {
+		t.Fatalf("revoking twice = %v, want ErrNoRecord", err)
+	}
+
+	if tokens, _ := m.ListAPITokens(ctx, ada.ID); len(tokens) != 0 {
+		t.Fatalf("ListAPITokens after the revocation = %v", tokens)
+	}
+
+	t.Run("expiry", func(t *testing.T) {
+		plain, token, err := m.CreateAPIToken(ctx, ada.ID, &APITokenStruct{Name: "short", Scopes: []string{ScopeTasksWrite}, ExpiresInDays: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token.ExpiresAt == nil || time.Until(*token.ExpiresAt) < 23*time.Hour {
+			t.Fatalf("ExpiresAt = %v, want a day from now", token.ExpiresAt)
+		}
+
+		if _, _, err := m.AuthenticateAPIToken(ctx, plain); err != nil {
+			t.Fatal(err)
+		}
+
+		m.db.Model(&APIToken{}).Where("id = ?", token.ID).Update("expires_at", time.Now().Add(-time.Minute))
+		if _, _, err := m.AuthenticateAPIToken(ctx, plain); !errors.Is(err, pkg.ErrInvalidToken) {
+			t.Fatalf("AuthenticateAPIToken of an expired token = %v, want ErrInvalidToken", err)
+		}
+	})
+
+	t.Run("inactive owner", func(t *testing.T) {
+		plain, _, err := m.CreateAPIToken(ctx, bob.ID, &APITokenStruct{Name: "bob", Scopes: []string{ScopeTasksRead}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.SetActive(ctx, bob.ID, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := m.AuthenticateAPIToken(ctx, plain); !errors.Is(err, pkg.ErrInvalidToken) {
+			t.Fatalf("AuthenticateAPIToken of a deactivated user = %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+func TestValidateAPITokenData(t *testing.T) {
+	m := &UserModelORM{}
+	tests := []struct {
+		input APITokenStruct
+		field string
+	}{
+		{APITokenStruct{Name: "ci", Scopes: []string{ScopeTasksRead, ScopeTasksWrite}, ExpiresInDays: apiTokenMaxDays}, ""},
+		{APITokenStruct{Name: " ", Scopes: []string{ScopeTasksRead}}, "name"},
+		{APITokenStruct{Name: "ci"}, "scopes"},
+		{APITokenStruct{Name: "ci", Scopes: []string{"admin"}}, "scopes"},
+		{APITokenStruct{Name: "ci", Scopes: []string{ScopeTasksRead}, ExpiresInDays: apiTokenMaxDays + 1}, "expires_in_days"},
+		{APITokenStruct{Name: "ci", Scopes: []string{ScopeTasksRead}, ExpiresInDays: -1}, "expires_in_days"},
+	}
+
+	for _, tt := range tests {
+		errs := m.ValidateAPITokenData(&tt.input).Errors
+		if tt.field == "" && len(errs) != 0 || tt.field != "" && errs[tt.field] == "" {
+			t.Errorf("ValidateAPITokenData(%+v) = %v, want an error on %q", tt.input, errs, tt.field)
+		}
+	}
+}
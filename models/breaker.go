@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/iamgak/go-task/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCacheUnavailable is what BreakerCache returns while its circuit is
+// open, without asking the cache behind it.
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+// BreakerCache is a circuit breaker in front of a Cache. After failures
+// errors in a row it stops calling the cache for cooldown, then lets a
+// single call through to probe it: one that works closes the circuit, one
+// that fails opens it again. Misses aren't failures, and neither are calls
+// cut short by their caller's context, a client hanging up or a request
+// timing out says nothing about the cache.
+type BreakerCache struct {
+	Cache
+	failures int
+	cooldown time.Duration
+	logger   *logrus.Logger
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+func NewBreakerCache(cache Cache, failures int, cooldown time.Duration, logger *logrus.Logger) *BreakerCache {
+	return &BreakerCache{Cache: cache, failures: failures, cooldown: cooldown, logger: logger}
+}
+
+// State is closed, open or half-open, the last while a probe is out.
+func (b *BreakerCache) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.probing:
+		return "half-open"
+	case !b.openUntil.IsZero():
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// allow tells whether a call may go through to the cache.
+func (b *BreakerCache) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return ErrCacheUnavailable
+	}
+
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a call that went through with ctx.
+func (b *BreakerCache) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && err != ErrCacheMiss && ctx.Err() != nil {
+		// no verdict, the next call may probe again
+		b.probing = false
+		return
+	}
+
+	if err == nil || err == ErrCacheMiss {
+		if !b.openUntil.IsZero() {
+			b.logger.Info("Cache reachable again, closing the circuit")
+			metrics.CacheBreakerOpen.Set(0)
+		}
+
+		b.consecutive, b.openUntil, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	b.consecutive++
+	if b.probing || b.consecutive >= b.failures {
+		if b.openUntil.IsZero() {
+			b.logger.Warn("Cache failing, opening the circuit for ", b.cooldown, ": ", err)
+			metrics.CacheBreakerOpen.Set(1)
+		}
+
+		b.openUntil, b.probing = time.Now().Add(b.cooldown), false
+	}
+}
+
+func (b *BreakerCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	value, err := b.Cache.Get(ctx, key)
+	b.record(ctx, err)
+	return value, err
+}
+
+func (b *BreakerCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.call(ctx, func() error { return b.Cache.Set(ctx, key, value, ttl) })
+}
+
+func (b *BreakerCache) Delete(ctx context.Context, keys ...string) error {
+	return b.call(ctx, func() error { return b.Cache.Delete(ctx, keys...) })
+}
+
+func (b *BreakerCache) Incr(ctx context.Context, key string) (int64, error) {
+	if err := b.allow(); err != nil {
+		return 0, err
+	}
+
+	n, err := b.Cache.Incr(ctx, key)
+	b.record(ctx, err)
+	return n, err
+}
+
+func (b *BreakerCache) Clear(ctx context.Context) error {
+	return b.call(ctx, func() error { return b.Cache.Clear(ctx) })
+}
+
+func (b *BreakerCache) Stats(ctx context.Context) (*CacheStats, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	stats, err := b.Cache.Stats(ctx)
+	b.record(ctx, err)
+	return stats, err
+}
+
+func (b *BreakerCache) call(ctx context.Context, fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	b.record(ctx, err)
+	return err
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/iamgak/go-task/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// flakyCache fails every call while down and counts the calls it got.
+type flakyCache struct {
+	Cache
+	down  bool
+	calls int
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.calls++
+	if c.down {
+		return nil, errors.New("connection refused")
+	}
+
+	return c.Cache.Get(ctx, key)
+}
+
+func TestBreakerCache(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyCache{Cache: NewMemoryCache(), down: true}
+	breaker := NewBreakerCache(flaky, 3, 20*time.Millisecond, logrus.New())
+	for i := 0; i < 3; i++ {
+		breaker.Get(ctx, "tasks:id:1")
+	}
+
+	if state := breaker.State(); state != "open" {
+		t.Fatalf("state after 3 failures = %s, want open", state)
+	}
+
+	if _, err := breaker.Get(ctx, "tasks:id:1"); err != ErrCacheUnavailable || flaky.calls != 3 {
+		t.Fatalf("Get on an open circuit = %v after %d calls, want ErrCacheUnavailable after 3", err, flaky.calls)
+	}
+
+	// the probe after the cooldown fails and opens the circuit again
+	time.Sleep(25 * time.Millisecond)
+	breaker.Get(ctx, "tasks:id:1")
+	if state := breaker.State(); state != "open" || flaky.calls != 4 {
+		t.Fatalf("state after a failed probe = %s after %d calls, want open after 4", state, flaky.calls)
+	}
+
+	flaky.down = false
+	time.Sleep(25 * time.Millisecond)
+	if _, err := breaker.Get(ctx, "tasks:id:1"); err != ErrCacheMiss {
+		t.Fatalf("probe = %v, want ErrCacheMiss", err)
+	}
+
+	if state := breaker.State(); state != "closed" {
+		t.Fatalf("state after a probe that worked = %s, want closed", state)
+	}
+}
+
+func TestTasksSurviveRedisOutage(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	quiet := logrus.New()
+	quiet.SetLevel(logrus.ErrorLevel)
+	cache := NewBreakerCache(&RedisStruct{client: client, logger: quiet}, 2, time.Hour, quiet)
+	repo := &TaskModelORM{db: testDB(t), cache: cache, logger: quiet, cacheTTL: config.Default().Cache}
+
+	task := newTask(1, "before", "pending")
+	if err := repo.CreateTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.TaskById(ctx, 0, int(task.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	if err := repo.UpdateTask(ctx, int(task.ID), newTask(1, "during", "pending")); err != nil {
+		t.Fatalf("UpdateTask with Redis down = %v", err)
+	}
+
+	if got, err := repo.TaskById(ctx, 0, int(task.ID)); err != nil || got.Title != "during" {
+		t.Fatalf("TaskById with Redis down = %+v, %v", got, err)
+	}
+
+	if tasks, err := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10}); err != nil || len(tasks) != 1 {
+		t.Fatalf("TaskListing with Redis down = %v, %v", tasks, err)
+	}
+
+	if cache.State() != "open" {
+		t.Fatalf("circuit is %s with Redis down, want open", cache.State())
+	}
+
+	// Redis comes back with the entry cached before the update, the
+	// invalidation left over has to drop it before it is read
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !server.Exists(taskCacheKey(0, task.ID)) {
+		t.Fatal("Redis lost the entry cached before the outage")
+	}
+
+	cache.mu.Lock()
+	cache.openUntil = time.Now()
+	cache.mu.Unlock()
+
+	if got, err := repo.TaskById(ctx, 0, int(task.ID)); err != nil || got.Title != "during" {
+		t.Fatalf("TaskById after Redis came back = %+v, %v, want the updated task", got, err)
+	}
+
+	if cache.State() != "closed" {
+		t.Fatalf("circuit is %s after Redis came back, want closed", cache.State())
+	}
+}
+
+func TestBreakerIgnoresCallerContext(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	quiet := logrus.New()
+	quiet.SetLevel(logrus.ErrorLevel)
+	breaker := NewBreakerCache(&RedisStruct{client: client, logger: quiet}, 2, time.Hour, quiet)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		for _, ctx := range []context.Context{canceled, expired} {
+			if _, err := breaker.Get(ctx, "tasks:id:1"); err == nil || err == ErrCacheMiss {
+				t.Fatalf("Get with a done context = %v, want its error", err)
+			}
+
+			if err := breaker.Set(ctx, "tasks:id:1", []byte("{}"), time.Minute); err == nil {
+				t.Fatal("Set with a done context succeeded")
+			}
+		}
+	}
+
+	if state := breaker.State(); state != "closed" {
+		t.Fatalf("state after calls cut short by their callers = %s, want closed", state)
+	}
+
+	// a probe its caller gives up on leaves the circuit open for the next one
+	breaker.mu.Lock()
+	breaker.openUntil = time.Now()
+	breaker.mu.Unlock()
+	breaker.Get(canceled, "tasks:id:1")
+	if state := breaker.State(); state != "open" {
+		t.Fatalf("state after a canceled probe = %s, want open", state)
+	}
+
+	if _, err := breaker.Get(context.Background(), "tasks:id:1"); err != ErrCacheMiss {
+		t.Fatalf("next probe = %v, want ErrCacheMiss", err)
+	}
+
+	if state := breaker.State(); state != "closed" {
+		t.Fatalf("state after the next probe = %s, want closed", state)
+	}
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is what Cache.Get returns for keys it doesn't hold.
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache keeps the JSON of read results, TaskModelORM uses it in front of
+// the database.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Incr adds one to the counter at key and returns the new value, a
+	// missing counter starts from zero. Counters don't expire.
+	Incr(ctx context.Context, key string) (int64, error)
+	// Clear drops everything the application cached, tasks included.
+	Clear(ctx context.Context) error
+	Stats(ctx context.Context) (*CacheStats, error)
+}
+
+// CacheStats is what the cache holds. Keys are counted by kind, the part
+// after "tasks:", hits and misses since the cache started.
+type CacheStats struct {
+	Keys       map[string]int64 `json:"keys"`
+	Hits       int64            `json:"hits"`
+	Misses     int64            `json:"misses"`
+	UsedMemory int64            `json:"used_memory_bytes"`
+	// Local is the in-process tier of a TieredCache
+	Local *CacheStats `json:"local,omitempty"`
+}
+
+// cacheKeyKind is "id" for "tasks:id:1", "listing" for the listings and
+// "gen" for their generation, in every workspace alike.
+func cacheKeyKind(key string) string {
+	parts := strings.SplitN(key, ":", 5)
+	if len(parts) >= 4 && parts[1] == "ws" {
+		return parts[3]
+	}
+
+	if len(parts) < 2 {
+		return "other"
+	}
+
+	return parts[1]
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// MemoryCache is a Cache in process memory. Bounded, it evicts the least
+// recently used entries once keys and values take more than maxBytes.
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	entries  map[string]*list.Element
+	// recency has the most recently used entry in front
+	recency *list.List
+	hits    int64
+	misses  int64
+}
+
+// NewMemoryCache is unbounded, for tests.
+func NewMemoryCache() *MemoryCache {
+	return NewLRUCache(0)
+}
+
+// NewLRUCache holds up to maxBytes of keys and values, 0 is unbounded.
+func NewLRUCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{maxBytes: maxBytes, entries: make(map[string]*list.Element), recency: list.New()}
+}
+
+// lookup returns the live entry at key and marks it used. Callers hold mu.
+func (c *MemoryCache) lookup(key string) *memoryEntry {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if entry.expired(time.Now()) {
+		c.remove(element)
+		return nil
+	}
+
+	c.recency.MoveToFront(element)
+	return entry
+}
+
+// store puts the entry in and evicts until it fits. Callers hold mu.
+func (c *MemoryCache) store(entry *memoryEntry) {
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+
+	if c.maxBytes > 0 && entry.size() > c.maxBytes {
+		return
+	}
+
+	c.entries[entry.key] = c.recency.PushFront(entry)
+	c.used += entry.size()
+	for c.maxBytes > 0 && c.used > c.maxBytes {
+		c.remove(c.recency.Back())
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	entry := c.recency.Remove(element).(*memoryEntry)
+	delete(c.entries, entry.key)
+	c.used -= entry.size()
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.lookup(key)
+	if entry == nil {
+		c.misses++
+		return nil, ErrCacheMiss
+	}
+
+	c.hits++
+	return append([]byte(nil), entry.value...), nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := &memoryEntry{key: key, value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	c.store(entry)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+
+	return nil
+}
+
+func (c *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	if entry := c.lookup(key); entry != nil {
+		var err error
+		if n, err = strconv.ParseInt(string(entry.value), 10, 64); err != nil {
+			return 0, fmt.Errorf("%s is not a counter: %w", key, err)
+		}
+	}
+
+	n++
+	c.store(&memoryEntry{key: key, value: []byte(strconv.FormatInt(n, 10))})
+	return n, nil
+}
+
+func (c *MemoryCache) Clear(ctx context.Context) error {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.recency.Init()
+	c.used = 0
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *MemoryCache) Stats(ctx context.Context) (*CacheStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &CacheStats{Keys: make(map[string]int64), Hits: c.hits, Misses: c.misses, UsedMemory: c.used}
+	now := time.Now()
+	for key, element := range c.entries {
+		if !element.Value.(*memoryEntry).expired(now) {
+			stats.Keys[cacheKeyKind(key)]++
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"github.com/iamgak/go-task/config"
+	_ "github.com/lib/pq"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// OpenDB connects to the database DB_DRIVER selects. Postgres goes through
+// lib/pq, SQLite through a pure Go driver so no C toolchain is needed.
+func OpenDB(cfg config.DBConfig, gormConfig *gorm.Config) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case "mysql":
+		dialector = mysql.Open(cfg.DSN())
+	case "postgres":
+		dialector = postgres.New(postgres.Config{DriverName: "postgres", DSN: cfg.DSN()})
+	case "sqlite":
+		dialector = sqlite.Open(cfg.DSN())
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+
+	db, err := gorm.Open(dialector, gormConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Driver == "sqlite" {
+		// SQLite takes one writer at a time, and every connection to
+		// :memory: would open a database of its own
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// Tables are the models that have a table of their own. The migrations
+// must create exactly these, the schema test in migrations/ holds them to it.
+var Tables = []interface{}{
+	&User{},
+	&UsersSession{},
+	&UserActivityLog{},
+	&UserRecoveryCode{},
+	&Task{},
+	&Project{},
+	&Workspace{},
+	&WorkspaceMember{},
+	&WorkspaceInvitation{},
+	&APIToken{},
+	&SigningKey{},
+	&UserIdentity{},
+}
@@ -2,8 +2,17 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/iamgak/go-task/pkg"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Pages hold defaultPageSize tasks unless they ask for 1 to maxPageSize.
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
 )
 
 type Filters struct {
@@ -14,14 +23,25 @@ type Filters struct {
 	SortOrder string
 	DueAfter  string
 	DueBefore string
+	// ProjectID selects the tasks of one project, archived or not. Without
+	// it the tasks of archived projects are left out.
+	ProjectID uint
+	// WorkspaceID selects the tasks of the workspace, 0 the personal ones.
+	WorkspaceID uint
 }
 
+// limit is never below 1, GORM leaves LIMIT out for negative ones.
 func (f Filters) limit() int {
-	return f.PageSize
+	if f.PageSize < 1 {
+		return defaultPageSize
+	}
+
+	return min(f.PageSize, maxPageSize)
 }
 
+// offset counts pages from 1, lower pages are the first.
 func (f Filters) offset() int {
-	return (f.CurrPage - 1) * f.PageSize
+	return (max(f.CurrPage, 1) - 1) * f.limit()
 }
 
 func (f Filters) sortDirection() string {
@@ -45,26 +65,77 @@ func (f Filters) sortColumn() string {
 		}
 	}
 
-	return ""
+	return "id"
+}
+
+// dueRange is the due_date_after and due_date_before bounds, zero when not
+// given. Both are midnight UTC of the day.
+func (f Filters) dueRange() (time.Time, time.Time) {
+	var after, before time.Time
+	if f.DueAfter != "" {
+		after, _ = time.Parse("2006-01-02", f.DueAfter)
+	}
+
+	if f.DueBefore != "" {
+		before, _ = time.Parse("2006-01-02", f.DueBefore)
+	}
+
+	return after, before
 }
 
-func (f Filters) otherConditions() string {
-	where := ""
+// apply narrows, sorts and pages a task query. Values are bound, never
+// spliced into the SQL.
+func (f Filters) apply(db *gorm.DB) *gorm.DB {
 	if f.ValidStatus() {
-		where += fmt.Sprintf(" AND status = '%s'", f.Status)
+		db = db.Where("status = ?", f.Status)
 	}
 
-	if f.DueAfter != "" {
-		where += fmt.Sprintf(" AND due_at >= '%s'", f.DueAfter)
-	} else if f.DueBefore != "" {
-		where += fmt.Sprintf(" AND due_at <= '%s'", f.DueAfter)
+	after, before := f.dueRange()
+	if !after.IsZero() {
+		db = db.Where("due_at >= ?", after)
+	}
+
+	if !before.IsZero() {
+		db = db.Where("due_at <= ?", before)
 	}
 
-	if f.sortColumn() != "" {
-		where = fmt.Sprintf(" %s ORDER BY '%s' %s LIMIT %d OFFSET %d", where, f.SortBy, f.sortDirection(), f.limit(), f.offset())
+	db = inWorkspace(db, f.WorkspaceID)
+	if f.ProjectID != 0 {
+		db = db.Where("project_id = ?", f.ProjectID)
 	} else {
-		where = fmt.Sprintf(" %s ORDER BY id DESC LIMIT %d OFFSET %d", where, f.limit(), f.offset())
+		db = db.Where("(project_id IS NULL OR project_id NOT IN (SELECT id FROM projects WHERE archived = ?))", true)
+	}
+
+	desc := f.sortDirection() == "desc"
+	db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: f.sortColumn()}, Desc: desc})
+	if f.sortColumn() != "id" {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc})
+	}
+
+	return db.Limit(f.limit()).Offset(f.offset())
+}
+
+// cacheKey names the page the filters select, filters that select the same
+// page share it. The workspace is left to the prefix of the listing key.
+func (f Filters) cacheKey() string {
+	after, before := f.dueRange()
+	status := ""
+	if f.ValidStatus() {
+		status = f.Status
+	}
+
+	key := fmt.Sprintf("status=%s:sort=%s:%s:limit=%d:offset=%d", status, f.sortColumn(), f.sortDirection(), f.limit(), f.offset())
+	if !after.IsZero() {
+		key += ":after=" + after.Format("2006-01-02")
+	}
+
+	if !before.IsZero() {
+		key += ":before=" + before.Format("2006-01-02")
+	}
+
+	if f.ProjectID != 0 {
+		key += fmt.Sprintf(":project=%d", f.ProjectID)
 	}
 
-	return where
+	return key
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PingDB checks that the database answers before ctx is done.
+func (m *Init) PingDB(ctx context.Context) error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// PingRedis checks that Redis answers before ctx is done and reports the
+// round trip it took.
+func (m *Init) PingRedis(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := m.redisClient.Ping(ctx).Err()
+	return time.Since(start), err
+}
+
+func (m *Init) DBStats() (sql.DBStats, error) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+
+	return sqlDB.Stats(), nil
+}
+
+func (m *Init) RedisPoolStats() *redis.PoolStats {
+	return m.redisClient.PoolStats()
+}
+
+// CacheBreaker is the state of the circuit breaker in front of the cache,
+// closed when there is none.
+func (m *Init) CacheBreaker() string {
+	if m.breaker == nil {
+		return "closed"
+	}
+
+	return m.breaker.State()
+}
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"context"
+	"strconv"
+
 	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/config"
 	"github.com/iamgak/go-task/pkg"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -11,31 +15,112 @@ import (
 type Init struct {
 	// Task TaskModel
 	// Users        UserModel
-	UsersORM     UserModelORM
-	Redis        RedisStruct
-	TaskModelORM TaskModelORM
+	Tasks      TaskRepository
+	Projects   ProjectRepository
+	Workspaces WorkspaceRepository
+	Users      UserRepository
+	Cache      Cache
+	// UsersORM is Users when it is backed by the database, it also has MFA,
+	// single sign-on, API tokens and lockouts
+	UsersORM    *UserModelORM
+	Limiter     *RateLimiter
+	Signer      *TokenSigner
+	db          *gorm.DB
+	redisClient *redis.Client
+	breaker     *BreakerCache
+	tiered      *TieredCache
+}
+
+func Constructor(cfg *config.Config, dbORM *gorm.DB, redis *redis.Client, Logger *logrus.Logger) *Init {
+	cache, breaker, tiered := newCache(cfg.Cache, redis, Logger)
+	signer := NewTokenSigner(dbORM, Logger, cfg.JWT)
+	mailer := NewMailer(cfg.Mail, Logger)
+	users := &UserModelORM{
+		db:         dbORM,
+		redis:      redis,
+		logger:     Logger,
+		mailer:     mailer,
+		signer:     signer,
+		identity:   NewOIDCProviderFromConfig(cfg.OIDC),
+		appURL:     cfg.App.URL,
+		sessionTTL: cfg.JWT.SessionTTL,
+	}
+
+	tasks := &TaskModelORM{db: dbORM, cache: cache, logger: Logger, cacheTTL: cfg.Cache}
+	return &Init{
+		Tasks:       tasks,
+		Projects:    tasks,
+		Workspaces:  &WorkspaceModelORM{db: dbORM, logger: Logger, mailer: mailer, appURL: cfg.App.URL},
+		Users:       users,
+		Cache:       cache,
+		breaker:     breaker,
+		tiered:      tiered,
+		UsersORM:    users,
+		Limiter:     NewRateLimiter(redis, Logger),
+		Signer:      signer,
+		db:          dbORM,
+		redisClient: redis,
+	}
+}
+
+// newCache builds the cache CacheConfig.Backend names. Redis is behind a
+// circuit breaker, returned as well, and tiered is set for the tiered
+// backend only.
+func newCache(cfg config.CacheConfig, client *redis.Client, logger *logrus.Logger) (cache Cache, breaker *BreakerCache, tiered *TieredCache) {
+	local := NewLRUCache(int64(cfg.LocalMaxMB) << 20)
+	if cfg.Backend == "memory" {
+		return local, nil, nil
+	}
+
+	breaker = NewBreakerCache(&RedisStruct{client: client, logger: logger}, cfg.BreakerFailures, cfg.BreakerCooldown, logger)
+	if cfg.Backend == "tiered" {
+		tiered = NewTieredCache(local, breaker, client, cfg.LocalTTL, logger)
+		return tiered, breaker, tiered
+	}
+
+	return breaker, breaker, nil
+}
+
+// CacheSync keeps the local tier of a tiered cache in step with the other
+// replicas until ctx is done, it is nil for the other backends.
+func (m *Init) CacheSync() func(ctx context.Context) error {
+	if m.tiered == nil {
+		return nil
+	}
+
+	return m.tiered.Run
 }
 
-func Constructor(dbORM *gorm.DB, redis *redis.Client, Logger *logrus.Logger) *Init {
-	RedisClient := RedisStruct{client: redis, logger: Logger}
+// NewMemoryInit keeps everything in process memory, for tests. Only the
+// repositories and the cache are set, there is no database or Redis behind
+// the rest: without UsersORM and Signer the routes needing them answer 501
+// and API tokens are rejected, without a Limiter nothing is rate limited.
+// Mail is only logged.
+func NewMemoryInit(cfg *config.Config) *Init {
+	logger := logrus.New()
+	tasks := NewMemoryTaskRepository()
+	users := NewMemoryUserRepository(cfg.JWT.SessionTTL)
 	return &Init{
-		// Task: TaskModel{db: db, redis: RedisClient, logger: Logger},
-		// Users:        UserModel{db: db, redis: redis, logger: Logger},
-		UsersORM:     UserModelORM{db: dbORM, redis: redis, logger: Logger},
-		TaskModelORM: TaskModelORM{db: dbORM, redis: RedisClient, logger: Logger},
-		// Review: ReviewModel{db: db, redis: rd},
+		Tasks:      tasks,
+		Projects:   tasks,
+		Workspaces: NewMemoryWorkspaceRepository(users, &LogMailer{logger: logger}, cfg.App.URL, logger),
+		Users:      users,
+		Cache:      NewMemoryCache(),
 	}
 }
 
 func NewFilters(c *gin.Context) *Filters {
 	var validator *pkg.Validator
+	// a project_id that isn't a number is ignored, like the other filters
+	projectID, _ := strconv.ParseUint(c.Query("project_id"), 10, 32)
 	return &Filters{
-		PageSize:  validator.ReadInt(c.Query("limit"), 10),
+		PageSize:  validator.ReadInt(c.Query("limit"), defaultPageSize),
 		CurrPage:  validator.ReadInt(c.Query("page"), 1),
 		Status:    validator.ReadString(c.Query("status"), ""),
 		SortOrder: validator.ReadString(c.Query("sort_order"), "desc"),
 		SortBy:    validator.ReadString(c.Query("sort_by"), "id"),
 		DueAfter:  validator.GetValidDate(c.Query("due_date_after")),
 		DueBefore: validator.GetValidDate(c.Query("due_date_before")),
+		ProjectID: uint(projectID),
 	}
 }
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailureWindow = 15 * time.Minute
+	loginDelayAfter    = 3  // failures per email before attempts get spaced out
+	loginLockAfter     = 5  // failures per email before the account is locked
+	loginIPLockAfter   = 20 // failures per IP, across all emails
+	loginLockDuration  = 15 * time.Minute
+	loginMaxDelay      = time.Minute
+)
+
+func loginFailKey(kind, value string) string {
+	return fmt.Sprintf("login:fail:%s:%s", kind, value)
+}
+
+func loginLockKey(email string) string {
+	return "login:lock:" + email
+}
+
+func loginDelayKey(email string) string {
+	return "login:delay:" + email
+}
+
+func loginUnlockKey(token string) string {
+	return "login:unlock:" + token
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginAllowed refuses attempts for locked accounts, for IPs that
+// failed too often and for emails that are still inside their progressive
+// delay. Redis failures let the attempt through, bcrypt stays the last line.
+func (m *UserModelORM) checkLoginAllowed(ctx context.Context, email, ip string) error {
+	locked, err := m.redis.Exists(ctx, loginLockKey(email), loginDelayKey(email)).Result()
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("Login throttling unavailable: ", err)
+		return nil
+	}
+
+	if locked > 0 {
+		if m.redis.Exists(ctx, loginLockKey(email)).Val() > 0 {
+			return pkg.ErrAccountLocked
+		}
+
+		return pkg.ErrTooManyAttempts
+	}
+
+	ipFailures, err := m.redis.Get(ctx, loginFailKey("ip", ip)).Int()
+	if err != nil && err != redis.Nil {
+		logging.FromContext(ctx, m.logger).Warn("Login throttling unavailable: ", err)
+		return nil
+	}
+
+	if ipFailures >= loginIPLockAfter {
+		return pkg.ErrTooManyAttempts
+	}
+
+	return nil
+}
+
+func (m *UserModelORM) recordLoginFailure(ctx context.Context, email, ip string, user *User) {
+	var emailFailures *redis.IntCmd
+	_, err := m.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		emailFailures = pipe.Incr(ctx, loginFailKey("email", email))
+		pipe.Expire(ctx, loginFailKey("email", email), loginFailureWindow)
+		pipe.Incr(ctx, loginFailKey("ip", ip))
+		pipe.Expire(ctx, loginFailKey("ip", ip), loginFailureWindow)
+		return nil
+	})
+
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("Failed to record login failure: ", err)
+		return
+	}
+
+	failures := emailFailures.Val()
+	switch {
+	case failures >= loginLockAfter:
+		m.lockAccount(ctx, email, user)
+	case failures >= loginDelayAfter:
+		delay := time.Second << (failures - loginDelayAfter)
+		if delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+
+		if err := m.redis.Set(ctx, loginDelayKey(email), 1, delay).Err(); err != nil {
+			logging.FromContext(ctx, m.logger).Warn("Failed to set login delay: ", err)
+		}
+	}
+}
+
+func (m *UserModelORM) resetLoginFailures(ctx context.Context, email string) {
+	if err := m.redis.Del(ctx, loginFailKey("email", email), loginDelayKey(email)).Err(); err != nil {
+		logging.FromContext(ctx, m.logger).Warn("Failed to reset login failures: ", err)
+	}
+}
+
+func (m *UserModelORM) lockAccount(ctx context.Context, email string, user *User) {
+	if err := m.redis.Set(ctx, loginLockKey(email), 1, loginLockDuration).Err(); err != nil {
+		logging.FromContext(ctx, m.logger).Warn("Failed to lock account: ", err)
+		return
+	}
+
+	// unknown emails are locked as well so probing them looks the same, but
+	// there is nobody to log or notify
+	if user == nil {
+		return
+	}
+
+	activity := UserActivityLog{UserID: user.ID, Activity: "Account Locked"}
+	if err := m.UserActivityLog(&activity); err != nil {
+		logging.FromContext(ctx, m.logger).Error("Failed to log account lock: ", err)
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Error("Failed to generate unlock token: ", err)
+		return
+	}
+
+	if err := m.redis.Set(ctx, loginUnlockKey(token), email, loginLockDuration).Err(); err != nil {
+		logging.FromContext(ctx, m.logger).Error("Failed to store unlock token: ", err)
+		return
+	}
+
+	body := fmt.Sprintf("Your account was locked after %d failed login attempts. It unlocks automatically in %s.\n\n"+
+		"If this was you, you can unlock it right away:\n%s/unlock_account/%s\n\n"+
+		"If it wasn't, somebody is guessing your password and you should change it.",
+		loginLockAfter, loginLockDuration, m.appURL, token)
+
+	go func() {
+		if err := m.mailer.Send(context.Background(), user.Email, "Your account has been locked", body); err != nil {
+			logging.FromContext(ctx, m.logger).Error("Failed to send unlock email: ", err)
+		}
+	}()
+}
+
+// UnlockAccount lifts a lockout with the token from the unlock email.
+func (m *UserModelORM) UnlockAccount(ctx context.Context, token string) error {
+	email, err := m.redis.GetDel(ctx, loginUnlockKey(token)).Result()
+	if err == redis.Nil {
+		return pkg.ErrNoRecord
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := m.redis.Del(ctx, loginLockKey(email), loginFailKey("email", email), loginDelayKey(email)).Err(); err != nil {
+		return err
+	}
+
+	var user User
+	if err := m.db.WithContext(ctx).Select("id").Where("email = ?", email).First(&user).Error; err != nil {
+		return err
+	}
+
+	activity := UserActivityLog{UserID: user.ID, Activity: "Account Unlocked"}
+	return m.UserActivityLog(&activity)
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-task/pkg"
+)
+
+// activeUser registers email with password Secret123! and activates it.
+func activeUser(t *testing.T, m *UserModelORM, email string) *User {
+	t.Helper()
+	ctx := context.Background()
+	if err := m.RegisterUser(ctx, email, "Secret123!", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := m.UserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetActive(ctx, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+var unlockLink = regexp.MustCompile(`/unlock_account/([0-9a-f]+)`)
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	mail := make(mailbox, 1)
+	m.mailer = mail
+	activeUser(t, m, "ada@example.com")
+	login := func(password string) error {
+		_, _, err := m.LoginUser(ctx, &UserStruct{Email: "ada@example.com", Passw: password}, "10.0.0.1")
+		return err
+	}
+
+	// waitOutDelay drops the progressive delay after checking its length
+	waitOutDelay := func(want time.Duration) {
+		t.Helper()
+		if ttl := m.redis.TTL(ctx, loginDelayKey("ada@example.com")).Val(); ttl != want {
+			t.Fatalf("login delay = %v, want %v", ttl, want)
+		}
+
+		if err := login("Secret123!"); !errors.Is(err, pkg.ErrTooManyAttempts) {
+			t.Fatalf("login within the delay = %v, want ErrTooManyAttempts", err)
+		}
+
+		m.redis.Del(ctx, loginDelayKey("ada@example.com"))
+	}
+
+	for i := 1; i <= loginLockAfter; i++ {
+		if err := login("Wrong-password-1"); !errors.Is(err, pkg.ErrInvalidCredentials) {
+			t.Fatalf("failure %d = %v, want ErrInvalidCredentials", i, err)
+		}
+
+		if i >= loginDelayAfter && i < loginLockAfter {
+			waitOutDelay(time.Second << (i - loginDelayAfter))
+		}
+	}
+
+	if err := login("Secret123!"); !errors.Is(err, pkg.ErrAccountLocked) {
+		t.Fatalf("login with the right password while locked = %v, want ErrAccountLocked", err)
+	}
+
+	var token string
+	select {
+	case sent := <-mail:
+		match := unlockLink.FindStringSubmatch(sent[1])
+		if sent[0] != "ada@example.com" || match == nil {
+			t.Fatalf("lock mail to %s: %q", sent[0], sent[1])
+		}
+
+		token = match[1]
+	case <-time.After(time.Second):
+		t.Fatal("no unlock mail was sent")
+	}
+
+	if err := m.UnlockAccount(ctx, "not-a-token"); !errors.Is(err, pkg.ErrNoRecord) {
+		t.Fatalf("UnlockAccount with a bad token = %v, want ErrNoRecord", err)
+	}
+
+	if err := m.UnlockAccount(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UnlockAccount(ctx, token); !errors.Is(err, pkg.ErrNoRecord) {
+		t.Fatalf("reusing the unlock token = %v, want ErrNoRecord", err)
+	}
+
+	if err := login("Secret123!"); err != nil {
+		t.Fatalf("login after the unlock = %v", err)
+	}
+
+	// a successful login starts the count over
+	for i := 1; i < loginDelayAfter; i++ {
+		login("Wrong-password-1")
+	}
+
+	if err := login("Secret123!"); err != nil {
+		t.Fatalf("login after %d fresh failures = %v", loginDelayAfter-1, err)
+	}
+}
+
+func TestLoginOfInactiveAccounts(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	m.mailer = make(mailbox, 1)
+	for _, email := range []string{"ada@example.com", "grace@example.com"} {
+		if err := m.RegisterUser(ctx, email, "Secret123!", "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := m.LoginUser(ctx, &UserStruct{Email: "grace@example.com", Passw: "Secret123!"}, "10.0.0.1"); !errors.Is(err, pkg.ErrAccountInActive) {
+		t.Fatalf("login with the right password = %v, want ErrAccountInActive", err)
+	}
+
+	// wrong guesses don't tell the account is inactive and count towards
+	// its lock
+	for i := 1; i <= loginLockAfter; i++ {
+		if _, _, err := m.LoginUser(ctx, &UserStruct{Email: "ada@example.com", Passw: "Wrong-password-1"}, "10.0.0.1"); !errors.Is(err, pkg.ErrInvalidCredentials) {
+			t.Fatalf("failure %d = %v, want ErrInvalidCredentials", i, err)
+		}
+
+		m.redis.Del(ctx, loginDelayKey("ada@example.com"))
+	}
+
+	if _, _, err := m.LoginUser(ctx, &UserStruct{Email: "ada@example.com", Passw: "Secret123!"}, "10.0.0.1"); !errors.Is(err, pkg.ErrAccountLocked) {
+		t.Fatalf("login after %d failures = %v, want ErrAccountLocked", loginLockAfter, err)
+	}
+}
+
+func TestLoginLockoutOfUnknownEmails(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	mail := make(mailbox, 1)
+	m.mailer = mail
+	for i := 0; i < loginLockAfter; i++ {
+		m.LoginUser(ctx, &UserStruct{Email: "nobody@example.com", Passw: "Wrong-password-1"}, "10.0.0.1")
+		m.redis.Del(ctx, loginDelayKey("nobody@example.com"))
+	}
+
+	if _, _, err := m.LoginUser(ctx, &UserStruct{Email: "nobody@example.com", Passw: "Wrong-password-1"}, "10.0.0.1"); !errors.Is(err, pkg.ErrAccountLocked) {
+		t.Fatalf("login of a probed unknown email = %v, want ErrAccountLocked like a real account", err)
+	}
+
+	select {
+	case sent := <-mail:
+		t.Fatalf("mail sent for an unknown email: %v", sent)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLoginLockoutPerIP(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	activeUser(t, m, "ada@example.com")
+	for i := 0; i < loginIPLockAfter; i++ {
+		m.LoginUser(ctx, &UserStruct{Email: fmt.Sprintf("guess-%d@example.com", i), Passw: "Wrong-password-1"}, "10.0.0.1")
+	}
+
+	creds := &UserStruct{Email: "ada@example.com", Passw: "Secret123!"}
+	if _, _, err := m.LoginUser(ctx, creds, "10.0.0.1"); !errors.Is(err, pkg.ErrTooManyAttempts) {
+		t.Fatalf("login from an IP that guessed %d emails = %v, want ErrTooManyAttempts", loginIPLockAfter, err)
+	}
+
+	if _, _, err := m.LoginUser(ctx, creds, "10.0.0.2"); err != nil {
+		t.Fatalf("login from another IP = %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/iamgak/go-task/config"
+	"github.com/sirupsen/logrus"
+)
+
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+// LogMailer only logs outgoing mail, it is used when no SMTP server is
+// configured.
+type LogMailer struct {
+	logger *logrus.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.logger.WithFields(logrus.Fields{"to": to, "subject": subject}).Info("Mail not sent, SMTP_HOST is not configured")
+	return nil
+}
+
+func NewMailer(cfg config.MailConfig, logger *logrus.Logger) Mailer {
+	if cfg.SMTPHost == "" {
+		return &LogMailer{logger: logger}
+	}
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	return &SMTPMailer{addr: fmt.Sprintf("%s:%d", cfg.SMTPHost, cfg.SMTPPort), from: cfg.From, auth: auth}
+}
@@ -0,0 +1,870 @@
+package models
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryTaskRepository keeps tasks in a map. It has the semantics of
+// TaskModelORM, the conformance tests hold both to them.
+type MemoryTaskRepository struct {
+	mu            sync.RWMutex
+	nextID        uint
+	tasks         map[uint]*Task
+	nextProjectID uint
+	projects      map[uint]*Project
+}
+
+func NewMemoryTaskRepository() *MemoryTaskRepository {
+	return &MemoryTaskRepository{tasks: make(map[uint]*Task), projects: make(map[uint]*Project)}
+}
+
+func (r *MemoryTaskRepository) TaskById(ctx context.Context, workspaceID uint, taskID int) (*Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	task, ok := r.tasks[uint(taskID)]
+	if !ok || task.IsDeleted || workspaceID != taskWorkspace(task) {
+		return nil, pkg.ErrNoRecord
+	}
+
+	return copyTask(task), nil
+}
+
+func (r *MemoryTaskRepository) TaskListing(ctx context.Context, f *Filters) ([]*Task, error) {
+	dueAfter, dueBefore := f.dueRange()
+	r.mu.RLock()
+	tasks := make([]*Task, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		switch {
+		case task.IsDeleted:
+		case taskWorkspace(task) != f.WorkspaceID:
+		case f.ValidStatus() && task.Status != f.Status:
+		case !dueAfter.IsZero() && (task.DueAt == nil || task.DueAt.Before(dueAfter)):
+		case !dueBefore.IsZero() && (task.DueAt == nil || task.DueAt.After(dueBefore)):
+		case f.ProjectID != 0 && (task.ProjectID == nil || *task.ProjectID != f.ProjectID):
+		case f.ProjectID == 0 && task.ProjectID != nil && r.projects[*task.ProjectID].Archived:
+		default:
+			tasks = append(tasks, copyTask(task))
+		}
+	}
+	r.mu.RUnlock()
+
+	column := f.sortColumn()
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if f.sortDirection() == "desc" {
+			a, b = b, a
+		}
+
+		switch column {
+		case "due_at":
+			return timeBefore(a.DueAt, b.DueAt, a.ID < b.ID)
+		case "created_at":
+			return timeBefore(a.CreatedAt, b.CreatedAt, a.ID < b.ID)
+		case "updated_at":
+			return timeBefore(a.UpdatedAt, b.UpdatedAt, a.ID < b.ID)
+		default:
+			return a.ID < b.ID
+		}
+	})
+
+	if f.offset() >= len(tasks) {
+		return tasks[:0], nil
+	}
+
+	tasks = tasks[f.offset():]
+	if f.limit() < len(tasks) {
+		tasks = tasks[:f.limit()]
+	}
+
+	return tasks, nil
+}
+
+// timeBefore orders like SQL does, NULL first, and ties by tie.
+func timeBefore(a, b *time.Time, tie bool) bool {
+	switch {
+	case a == nil && b == nil:
+		return tie
+	case a == nil || b == nil:
+		return a == nil
+	case a.Equal(*b):
+		return tie
+	default:
+		return a.Before(*b)
+	}
+}
+
+func (r *MemoryTaskRepository) CreateTask(ctx context.Context, task *Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.validProject(task) {
+		return pkg.ErrUnknownProject
+	}
+
+	r.nextID++
+	now := time.Now()
+	task.ID = r.nextID
+	task.Version = 1
+	task.CreatedAt = &now
+	r.tasks[task.ID] = copyTask(task)
+	return nil
+}
+
+func (r *MemoryTaskRepository) UpdateTask(ctx context.Context, id int, task *Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.tasks[uint(id)]
+	if !r.validProject(task) {
+		return pkg.ErrUnknownProject
+	}
+
+	if !ok || !writable(stored, taskWorkspace(task), task.UserID) {
+		return pkg.ErrInvalidUserFound
+	}
+
+	now := time.Now()
+	stored.ProjectID = copyID(task.ProjectID)
+	stored.Title = task.Title
+	stored.Description = task.Description
+	stored.Status = task.Status
+	stored.UpdatedAt = &now
+	stored.Version++
+	if task.ClearDueAt {
+		stored.DueAt = nil
+	} else if task.DueAt != nil && !task.DueAt.IsZero() {
+		due := *task.DueAt
+		stored.DueAt = &due
+	}
+
+	return nil
+}
+
+func (r *MemoryTaskRepository) SoftDelete(ctx context.Context, workspaceID, userID, taskID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.tasks[taskID]
+	if !ok || !writable(stored, workspaceID, userID) {
+		return pkg.ErrInvalidUserFound
+	}
+
+	now := time.Now()
+	stored.IsDeleted = true
+	stored.DeletedAt = &now
+	return nil
+}
+
+func (r *MemoryTaskRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var purged int64
+	for id, task := range r.tasks {
+		if task.IsDeleted && task.DeletedAt != nil && task.DeletedAt.Before(before) {
+			delete(r.tasks, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (r *MemoryTaskRepository) ValidateTaskData(task *Task, updated bool) *pkg.Validator {
+	return validateTaskData(task, updated)
+}
+
+func copyTask(task *Task) *Task {
+	copied := *task
+	copied.ProjectID = copyID(task.ProjectID)
+	copied.WorkspaceID = copyID(task.WorkspaceID)
+	return &copied
+}
+
+// writable is the check of writableTasks: a live task of the workspace,
+// or of the user for personal tasks.
+func writable(task *Task, workspaceID, userID uint) bool {
+	if task.IsDeleted || taskWorkspace(task) != workspaceID {
+		return false
+	}
+
+	return workspaceID != 0 || task.UserID == userID
+}
+
+// validProject is the check of checkProject. Callers hold mu.
+func (r *MemoryTaskRepository) validProject(task *Task) bool {
+	if task.ProjectID == nil {
+		return true
+	}
+
+	return task.WorkspaceID == nil && r.ownedProject(task.UserID, *task.ProjectID) != nil
+}
+
+func copyID(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+
+	copied := *id
+	return &copied
+}
+
+// ownedProject is the project if it belongs to the user. Callers hold mu.
+func (r *MemoryTaskRepository) ownedProject(userID, projectID uint) *Project {
+	project, ok := r.projects[projectID]
+	if !ok || project.UserID != userID {
+		return nil
+	}
+
+	return project
+}
+
+// withCounts copies the project and counts its live tasks. Callers hold mu.
+func (r *MemoryTaskRepository) withCounts(project *Project) *Project {
+	copied := *project
+	copied.Counts = newTaskCounts()
+	for _, task := range r.tasks {
+		if !task.IsDeleted && task.ProjectID != nil && *task.ProjectID == project.ID {
+			copied.Counts[task.Status]++
+		}
+	}
+
+	return &copied
+}
+
+func (r *MemoryTaskRepository) ProjectById(ctx context.Context, userID, projectID uint) (*Project, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	project := r.ownedProject(userID, projectID)
+	if project == nil {
+		return nil, pkg.ErrNoRecord
+	}
+
+	return r.withCounts(project), nil
+}
+
+func (r *MemoryTaskRepository) ProjectListing(ctx context.Context, userID uint, archived bool) ([]*Project, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	projects := make([]*Project, 0)
+	for _, project := range r.projects {
+		if project.UserID == userID && (archived || !project.Archived) {
+			projects = append(projects, r.withCounts(project))
+		}
+	}
+
+	sort.Slice(projects, func(i, j int) bool {
+		if projects[i].Position != projects[j].Position {
+			return projects[i].Position < projects[j].Position
+		}
+
+		return projects[i].ID < projects[j].ID
+	})
+
+	return projects, nil
+}
+
+func (r *MemoryTaskRepository) CreateProject(ctx context.Context, project *Project) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextProjectID++
+	now := time.Now()
+	project.ID = r.nextProjectID
+	project.CreatedAt = &now
+	project.Counts = newTaskCounts()
+	stored := *project
+	stored.Counts = nil
+	r.projects[project.ID] = &stored
+	return nil
+}
+
+func (r *MemoryTaskRepository) UpdateProject(ctx context.Context, id uint, project *Project) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := r.ownedProject(project.UserID, id)
+	if stored == nil {
+		return pkg.ErrInvalidUserFound
+	}
+
+	now := time.Now()
+	stored.Name = project.Name
+	stored.Color = project.Color
+	stored.Archived = project.Archived
+	stored.Position = project.Position
+	stored.UpdatedAt = &now
+	return nil
+}
+
+func (r *MemoryTaskRepository) DeleteProject(ctx context.Context, userID, projectID uint, tasks ProjectTasks) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ownedProject(userID, projectID) == nil {
+		return pkg.ErrInvalidUserFound
+	}
+
+	moveTo := tasks.MoveTo
+	if tasks.Delete {
+		moveTo = nil
+	} else if moveTo != nil && (*moveTo == projectID || r.ownedProject(userID, *moveTo) == nil) {
+		return pkg.ErrUnknownProject
+	}
+
+	now := time.Now()
+	for _, task := range r.tasks {
+		if task.ProjectID == nil || *task.ProjectID != projectID {
+			continue
+		}
+
+		if tasks.Delete && !task.IsDeleted {
+			task.IsDeleted = true
+			task.DeletedAt = &now
+		}
+
+		task.ProjectID = copyID(moveTo)
+	}
+
+	delete(r.projects, projectID)
+	return nil
+}
+
+func (r *MemoryTaskRepository) ValidateProjectData(project *Project) *pkg.Validator {
+	return validateProjectData(project)
+}
+
+// MemoryUserRepository keeps accounts in a map and signs its session
+// tokens with a key of its own. It doesn't do MFA, so LoginUser never asks
+// for a second factor.
+type MemoryUserRepository struct {
+	mu         sync.RWMutex
+	nextID     uint
+	users      map[uint]*User
+	activities []UserActivityLog
+	// sessions are the session tokens handed out, by user
+	sessions   map[string]uint
+	key        ed25519.PrivateKey
+	sessionTTL time.Duration
+}
+
+func NewMemoryUserRepository(sessionTTL time.Duration) *MemoryUserRepository {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("generating signing key: %v", err))
+	}
+
+	return &MemoryUserRepository{users: make(map[uint]*User), sessions: make(map[string]uint), key: key, sessionTTL: sessionTTL}
+}
+
+// lookup finds a user by address. Callers hold mu.
+func (r *MemoryUserRepository) lookup(email string) *User {
+	email = strings.TrimSpace(email)
+	for _, user := range r.users {
+		if user.Email == email {
+			return user
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryUserRepository) RegisterUser(ctx context.Context, email, password, ip string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	token, err := randomToken(20)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if r.lookup(email) != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("email %q is already registered", email)
+	}
+
+	r.nextID++
+	now := time.Now()
+	user := &User{ID: r.nextID, Email: email, HashPassw: string(hashedPassword), ActivationToken: token, Role: RoleUser, CreatedAt: &now}
+	r.users[user.ID] = user
+	r.mu.Unlock()
+	return r.UserActivityLog(&UserActivityLog{UserID: user.ID, Activity: "New User Register"})
+}
+
+func (r *MemoryUserRepository) ActivateAccount(token string) error {
+	r.mu.Lock()
+	var activated *User
+	for _, user := range r.users {
+		if token != "" && user.ActivationToken == token {
+			activated = user
+			break
+		}
+	}
+
+	if activated == nil {
+		r.mu.Unlock()
+		return pkg.ErrNoRecord
+	}
+
+	activated.ActivationToken = ""
+	activated.Active = true
+	activated.VerifiedAt = time.Now()
+	r.mu.Unlock()
+	return r.UserActivityLog(&UserActivityLog{UserID: activated.ID, Activity: "Account Activated"})
+}
+
+func (r *MemoryUserRepository) LoginUser(ctx context.Context, creds *UserStruct, ip string) (string, bool, error) {
+	r.mu.RLock()
+	found := r.lookup(creds.Email)
+	var user User
+	if found != nil {
+		user = *found
+	}
+	r.mu.RUnlock()
+
+	if found == nil {
+		return "", false, pkg.ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(creds.Passw)); err != nil {
+		return "", false, pkg.ErrInvalidCredentials
+	}
+
+	if !user.Active {
+		return "", false, pkg.ErrAccountInActive
+	}
+
+	now := time.Now()
+	claims := MyCustomClaims{
+		Email:   user.Email,
+		UserID:  user.ID,
+		Purpose: tokenPurposeSession,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(r.sessionTTL).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &claims).SignedString(r.key)
+	if err != nil {
+		return "", false, err
+	}
+
+	r.mu.Lock()
+	r.sessions[token] = user.ID
+	r.mu.Unlock()
+	return token, false, r.UserActivityLog(&UserActivityLog{UserID: user.ID, Activity: "Logged In"})
+}
+
+func (r *MemoryUserRepository) ParseToken(tokenString string) (*MyCustomClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodEdDSA.Alg() {
+			return nil, fmt.Errorf("[error] Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return r.key.Public(), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*MyCustomClaims)
+	if !ok || !token.Valid {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	r.mu.RLock()
+	_, live := r.sessions[tokenString]
+	r.mu.RUnlock()
+	if claims.IsSession() && !live {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// endSessions logs the user out everywhere, r.mu must be held.
+func (r *MemoryUserRepository) endSessions(userID uint) {
+	for token, owner := range r.sessions {
+		if owner == userID {
+			delete(r.sessions, token)
+		}
+	}
+}
+
+func (r *MemoryUserRepository) UserByEmail(ctx context.Context, email string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user := r.lookup(email)
+	if user == nil {
+		return nil, pkg.ErrNoRecord
+	}
+
+	copied := *user
+	return &copied, nil
+}
+
+func (r *MemoryUserRepository) UserActivityLog(activity *UserActivityLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for i := range r.activities {
+		previous := &r.activities[i]
+		if previous.UserID == activity.UserID && previous.Activity == activity.Activity && !previous.Superseded {
+			previous.Superseded = true
+			previous.UpdatedAt = &now
+		}
+	}
+
+	activity.ID = uint(len(r.activities) + 1)
+	activity.CreatedAt = &now
+	r.activities = append(r.activities, *activity)
+	return nil
+}
+
+func (r *MemoryUserRepository) ValidateUserData(user *UserStruct, register bool) *pkg.Validator {
+	return validateUserData(user, register, func(email string) bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.lookup(email) != nil
+	})
+}
+
+// update runs change on the user under the lock, it returns pkg.ErrNoRecord
+// when there is no such user.
+func (r *MemoryUserRepository) update(userID uint, change func(user *User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userID]
+	if !ok {
+		return pkg.ErrNoRecord
+	}
+
+	change(user)
+	now := time.Now()
+	user.UpdatedAt = &now
+	return nil
+}
+
+func (r *MemoryUserRepository) SetActive(ctx context.Context, userID uint, active bool) error {
+	activity := "Account Deactivated"
+	if active {
+		activity = "Account Activated"
+	}
+
+	err := r.update(userID, func(user *User) {
+		user.Active = active
+		if active {
+			user.ActivationToken = ""
+			user.VerifiedAt = time.Now()
+		} else {
+			r.endSessions(userID)
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return r.UserActivityLog(&UserActivityLog{UserID: userID, Activity: activity})
+}
+
+func (r *MemoryUserRepository) SetPassword(ctx context.Context, userID uint, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	err = r.update(userID, func(user *User) {
+		user.HashPassw = string(hashedPassword)
+		r.endSessions(userID)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return r.UserActivityLog(&UserActivityLog{UserID: userID, Activity: "Password Changed"})
+}
+
+func (r *MemoryUserRepository) SetRole(ctx context.Context, userID uint, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	if err := r.update(userID, func(user *User) { user.Role = role }); err != nil {
+		return err
+	}
+
+	return r.UserActivityLog(&UserActivityLog{UserID: userID, Activity: "Role Changed"})
+}
+
+// MemoryWorkspaceRepository keeps workspaces, their members and
+// invitations in maps. It looks the emails of members up in users.
+type MemoryWorkspaceRepository struct {
+	mu               sync.RWMutex
+	users            *MemoryUserRepository
+	mailer           Mailer
+	logger           *logrus.Logger
+	appURL           string
+	nextID           uint
+	workspaces       map[uint]*Workspace
+	nextMemberID     uint
+	members          map[uint][]*WorkspaceMember
+	nextInvitationID uint
+	invitations      map[string]*WorkspaceInvitation
+}
+
+func NewMemoryWorkspaceRepository(users *MemoryUserRepository, mailer Mailer, appURL string, logger *logrus.Logger) *MemoryWorkspaceRepository {
+	return &MemoryWorkspaceRepository{
+		users:       users,
+		mailer:      mailer,
+		appURL:      appURL,
+		logger:      logger,
+		workspaces:  make(map[uint]*Workspace),
+		members:     make(map[uint][]*WorkspaceMember),
+		invitations: make(map[string]*WorkspaceInvitation),
+	}
+}
+
+// member is the membership of the user, nil for others. Callers hold mu.
+func (r *MemoryWorkspaceRepository) member(workspaceID, userID uint) *WorkspaceMember {
+	for _, member := range r.members[workspaceID] {
+		if member.UserID == userID {
+			return member
+		}
+	}
+
+	return nil
+}
+
+// addMember makes the user a member. Callers hold mu.
+func (r *MemoryWorkspaceRepository) addMember(workspaceID, userID uint, role string) *WorkspaceMember {
+	r.nextMemberID++
+	now := time.Now()
+	member := &WorkspaceMember{ID: r.nextMemberID, WorkspaceID: workspaceID, UserID: userID, Role: role, CreatedAt: &now}
+	r.members[workspaceID] = append(r.members[workspaceID], member)
+	return member
+}
+
+func (r *MemoryWorkspaceRepository) email(userID uint) string {
+	r.users.mu.RLock()
+	defer r.users.mu.RUnlock()
+	if user, ok := r.users.users[userID]; ok {
+		return user.Email
+	}
+
+	return ""
+}
+
+func (r *MemoryWorkspaceRepository) CreateWorkspace(ctx context.Context, workspace *Workspace, ownerID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	now := time.Now()
+	workspace.ID = r.nextID
+	workspace.CreatedAt = &now
+	workspace.Role = WorkspaceOwner
+	stored := *workspace
+	stored.Role = ""
+	r.workspaces[workspace.ID] = &stored
+	r.addMember(workspace.ID, ownerID, WorkspaceOwner)
+	return nil
+}
+
+func (r *MemoryWorkspaceRepository) WorkspaceListing(ctx context.Context, userID uint) ([]*Workspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	workspaces := make([]*Workspace, 0)
+	for id, workspace := range r.workspaces {
+		if member := r.member(id, userID); member != nil {
+			copied := *workspace
+			copied.Role = member.Role
+			workspaces = append(workspaces, &copied)
+		}
+	}
+
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].Name != workspaces[j].Name {
+			return workspaces[i].Name < workspaces[j].Name
+		}
+
+		return workspaces[i].ID < workspaces[j].ID
+	})
+
+	return workspaces, nil
+}
+
+func (r *MemoryWorkspaceRepository) WorkspaceById(ctx context.Context, workspaceID uint) (*Workspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	workspace, ok := r.workspaces[workspaceID]
+	if !ok {
+		return nil, pkg.ErrNoRecord
+	}
+
+	copied := *workspace
+	for _, member := range r.members[workspaceID] {
+		listed := *member
+		listed.Email = r.email(member.UserID)
+		copied.Members = append(copied.Members, &listed)
+	}
+
+	return &copied, nil
+}
+
+func (r *MemoryWorkspaceRepository) Member(ctx context.Context, workspaceID, userID uint) (*WorkspaceMember, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	member := r.member(workspaceID, userID)
+	if member == nil {
+		return nil, pkg.ErrNoRecord
+	}
+
+	copied := *member
+	return &copied, nil
+}
+
+// keepOwner is keepOwner of WorkspaceModelORM. Callers hold mu.
+func (r *MemoryWorkspaceRepository) keepOwner(workspaceID, userID uint, role string) (*WorkspaceMember, error) {
+	member := r.member(workspaceID, userID)
+	if member == nil {
+		return nil, pkg.ErrNoRecord
+	}
+
+	if member.Role != WorkspaceOwner || role == WorkspaceOwner {
+		return member, nil
+	}
+
+	owners := 0
+	for _, other := range r.members[workspaceID] {
+		if other.Role == WorkspaceOwner {
+			owners++
+		}
+	}
+
+	if owners < 2 {
+		return nil, pkg.ErrLastOwner
+	}
+
+	return member, nil
+}
+
+func (r *MemoryWorkspaceRepository) SetMemberRole(ctx context.Context, workspaceID, userID uint, role string) error {
+	if !ValidWorkspaceRole(role) {
+		return fmt.Errorf("unknown workspace role %q", role)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	member, err := r.keepOwner(workspaceID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	member.Role = role
+	return nil
+}
+
+func (r *MemoryWorkspaceRepository) RemoveMember(ctx context.Context, workspaceID, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	member, err := r.keepOwner(workspaceID, userID, "")
+	if err != nil {
+		return err
+	}
+
+	members := r.members[workspaceID]
+	for i := range members {
+		if members[i] == member {
+			r.members[workspaceID] = append(members[:i], members[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryWorkspaceRepository) Invite(ctx context.Context, invitation *WorkspaceInvitation) error {
+	invitation.Email = normaliseEmail(invitation.Email)
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	workspace, ok := r.workspaces[invitation.WorkspaceID]
+	if !ok {
+		r.mu.Unlock()
+		return pkg.ErrNoRecord
+	}
+
+	for _, member := range r.members[invitation.WorkspaceID] {
+		if normaliseEmail(r.email(member.UserID)) == invitation.Email {
+			r.mu.Unlock()
+			return pkg.ErrAlreadyMember
+		}
+	}
+
+	r.nextInvitationID++
+	now := time.Now()
+	invitation.ID = r.nextInvitationID
+	invitation.TokenHash = hashToken(token)
+	invitation.ExpiresAt = now.AddDate(0, 0, invitationDays)
+	invitation.CreatedAt = &now
+	stored := *invitation
+	r.invitations[invitation.TokenHash] = &stored
+	named := *workspace
+	r.mu.Unlock()
+
+	sendInvitation(ctx, r.mailer, r.logger, r.appURL, &named, invitation, token)
+	return nil
+}
+
+func (r *MemoryWorkspaceRepository) AcceptInvitation(ctx context.Context, token string, userID uint, email string) (*WorkspaceMember, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invitation, ok := r.invitations[hashToken(token)]
+	if !ok || invitation.AcceptedAt != nil || !invitation.ExpiresAt.After(time.Now()) || invitation.Email != normaliseEmail(email) {
+		return nil, pkg.ErrNoRecord
+	}
+
+	if r.member(invitation.WorkspaceID, userID) != nil {
+		return nil, pkg.ErrAlreadyMember
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+	member := *r.addMember(invitation.WorkspaceID, userID, invitation.Role)
+	member.Email = email
+	return &member, nil
+}
+
+func (r *MemoryWorkspaceRepository) WorkspaceIDs(ctx context.Context) ([]uint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]uint, 0, len(r.workspaces))
+	for id := range r.workspaces {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (r *MemoryWorkspaceRepository) ValidateWorkspaceData(workspace *Workspace) *pkg.Validator {
+	return validateWorkspaceData(workspace)
+}
+
+func (r *MemoryWorkspaceRepository) ValidateInvitationData(invitation *WorkspaceInvitation) *pkg.Validator {
+	return validateInvitationData(invitation)
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaIssuer         = "go-task"
+	mfaPendingTTL     = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// EnrollMFA generates a new TOTP secret for the user and returns it with its
+// provisioning URI. It is stored but not enforced until ConfirmMFA sees a
+// valid code for it.
+func (m *UserModelORM) EnrollMFA(ctx context.Context, userID uint) (string, string, error) {
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return "", "", err
+	}
+
+	if user.MFAEnabled {
+		return "", "", pkg.ErrMFAAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: mfaIssuer, AccountName: user.Email})
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := m.db.WithContext(ctx).Model(&user).Update("mfa_secret", key.Secret()).Error; err != nil {
+		return "", "", err
+	}
+
+	return key.Secret(), provisioningURI(user.Email, key.Secret()), nil
+}
+
+// MFAQRCode renders the provisioning URI of the pending secret as a PNG.
+func (m *UserModelORM) MFAQRCode(ctx context.Context, userID uint) ([]byte, error) {
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled || user.MFASecret == "" {
+		return nil, pkg.ErrNoRecord
+	}
+
+	key, err := otp.NewKeyFromURL(provisioningURI(user.Email, user.MFASecret))
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ConfirmMFA turns MFA on once the user proves the authenticator works and
+// hands out the recovery codes. They are only ever returned here.
+func (m *UserModelORM) ConfirmMFA(ctx context.Context, userID uint, code string) ([]string, error) {
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, pkg.ErrMFAAlreadyEnabled
+	}
+
+	if user.MFASecret == "" {
+		return nil, pkg.ErrMFANotEnabled
+	}
+
+	if !m.validTOTP(ctx, &user, code) {
+		return nil, pkg.ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("mfa_enabled", true).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserRecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		for i := range codes {
+			code, err := generateRecoveryCode()
+			if err != nil {
+				return err
+			}
+
+			codes[i] = code
+			if err := tx.Create(&UserRecoveryCode{UserID: user.ID, CodeHash: hashRecoveryCode(code)}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	activity := UserActivityLog{UserID: user.ID, Activity: "MFA Enabled"}
+	return codes, m.UserActivityLog(&activity)
+}
+
+// DisableMFA needs both factors, so a stolen session alone can't remove it.
+func (m *UserModelORM) DisableMFA(ctx context.Context, userID uint, password, code string) error {
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	if !user.MFAEnabled {
+		return pkg.ErrMFANotEnabled
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(password)); err != nil {
+		return pkg.ErrInvalidCredentials
+	}
+
+	if !m.validTOTP(ctx, &user, code) {
+		return pkg.ErrInvalidMFACode
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"mfa_enabled": false, "mfa_secret": ""}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&UserRecoveryCode{}).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	activity := UserActivityLog{UserID: user.ID, Activity: "MFA Disabled"}
+	return m.UserActivityLog(&activity)
+}
+
+// LoginMFA exchanges the pending token LoginUser handed out, plus a TOTP or
+// recovery code, for a session token.
+func (m *UserModelORM) LoginMFA(ctx context.Context, mfaToken, code, ip string) (string, error) {
+	claims, err := m.ParseToken(mfaToken)
+	if err != nil || claims.Purpose != tokenPurposeMFA {
+		return "", pkg.ErrInvalidMFACode
+	}
+
+	email := normaliseEmail(claims.Email)
+	if err := m.checkLoginAllowed(ctx, email, ip); err != nil {
+		return "", err
+	}
+
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, claims.UserID).Error; err != nil {
+		return "", err
+	}
+
+	if !user.MFAEnabled {
+		return "", pkg.ErrMFANotEnabled
+	}
+
+	if !m.validTOTP(ctx, &user, code) {
+		used, err := m.useRecoveryCode(ctx, user.ID, code)
+		if err != nil {
+			return "", err
+		}
+
+		if !used {
+			m.recordLoginFailure(ctx, email, ip, &user)
+			return "", pkg.ErrInvalidMFACode
+		}
+	}
+
+	m.resetLoginFailures(ctx, email)
+	return m.completeLogin(ctx, &user, "mfa")
+}
+
+// validTOTP accepts a code for the current step or one step either side,
+// and only once, so a code read over someone's shoulder can't be replayed.
+func (m *UserModelORM) validTOTP(ctx context.Context, user *User, code string) bool {
+	code = strings.TrimSpace(code)
+	valid, err := totp.ValidateCustom(code, user.MFASecret, time.Now(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+
+	if err != nil || !valid {
+		return false
+	}
+
+	fresh, err := m.redis.SetNX(ctx, fmt.Sprintf("mfa:used:%d:%s", user.ID, code), 1, 90*time.Second).Result()
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("MFA replay check unavailable: ", err)
+		return true
+	}
+
+	return fresh
+}
+
+func (m *UserModelORM) useRecoveryCode(ctx context.Context, userID uint, code string) (bool, error) {
+	result := m.db.WithContext(ctx).Model(&UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	activity := UserActivityLog{UserID: userID, Activity: "Recovery Code Used"}
+	return true, m.UserActivityLog(&activity)
+}
+
+func provisioningURI(email, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", mfaIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", "6")
+	params.Set("period", "30")
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + mfaIssuer + ":" + email, RawQuery: params.Encode()}
+	return uri.String()
+}
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// recovery codes carry 50 random bits, a fast hash is enough to keep them
+// from being read back out of the database
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-task/pkg"
+	"github.com/pquerna/otp/totp"
+)
+
+// totpCode is the code of secret steps periods of 30s away from now. Each
+// one is only accepted once, so every check takes another step.
+func totpCode(t *testing.T, secret string, steps int) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, time.Now().Add(time.Duration(steps)*30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestMFA(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	user := activeUser(t, m, "ada@example.com")
+	creds := &UserStruct{Email: "ada@example.com", Passw: "Secret123!"}
+
+	secret, uri, err := m.EnrollMFA(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(uri, "otpauth://totp/go-task:ada@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("provisioning URI = %q", uri)
+	}
+
+	if png, err := m.MFAQRCode(ctx, user.ID); err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatalf("MFAQRCode = %.8q, %v", png, err)
+	}
+
+	// enrolling alone doesn't change how the user logs in
+	if _, mfaRequired, err := m.LoginUser(ctx, creds, "10.0.0.1"); err != nil || mfaRequired {
+		t.Fatalf("login before the confirmation = %v, %v, want a session", mfaRequired, err)
+	}
+
+	if _, err := m.ConfirmMFA(ctx, user.ID, "not-a-code"); !errors.Is(err, pkg.ErrInvalidMFACode) {
+		t.Fatalf("ConfirmMFA with a wrong code = %v, want ErrInvalidMFACode", err)
+	}
+
+	recoveryCodes, err := m.ConfirmMFA(ctx, user.ID, totpCode(t, secret, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distinct := map[string]bool{}
+	for _, code := range recoveryCodes {
+		distinct[code] = true
+	}
+
+	if len(recoveryCodes) != recoveryCodeCount || len(distinct) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %v, want %d distinct ones", recoveryCodes, recoveryCodeCount)
+	}
+
+	if _, _, err := m.EnrollMFA(ctx, user.ID); !errors.Is(err, pkg.ErrMFAAlreadyEnabled) {
+		t.Fatalf("EnrollMFA with MFA on = %v, want ErrMFAAlreadyEnabled", err)
+	}
+
+	if _, err := m.MFAQRCode(ctx, user.ID); !errors.Is(err, pkg.ErrNoRecord) {
+		t.Fatalf("MFAQRCode with MFA on = %v, want ErrNoRecord", err)
+	}
+
+	pending, mfaRequired, err := m.LoginUser(ctx, creds, "10.0.0.1")
+	if err != nil || !mfaRequired {
+		t.Fatalf("login with MFA on = %v, %v, want an MFA challenge", mfaRequired, err)
+	}
+
+	if claims, err := m.ParseToken(pending); err != nil || claims.IsSession() {
+		t.Fatalf("pending token = %+v, %v, want it to be no session", claims, err)
+	}
+
+	code := totpCode(t, secret, 0)
+	session, err := m.LoginMFA(ctx, pending, code, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims, err := m.ParseToken(session); err != nil || !claims.IsSession() || claims.UserID != user.ID {
+		t.Fatalf("token of LoginMFA = %+v, %v", claims, err)
+	}
+
+	if _, err := m.LoginMFA(ctx, pending, code, "10.0.0.1"); !errors.Is(err, pkg.ErrInvalidMFACode) {
+		t.Fatalf("replaying a TOTP code = %v, want ErrInvalidMFACode", err)
+	}
+
+	if _, err := m.LoginMFA(ctx, session, totpCode(t, secret, 1), "10.0.0.1"); !errors.Is(err, pkg.ErrInvalidMFACode) {
+		t.Fatalf("LoginMFA with a session token = %v, want ErrInvalidMFACode", err)
+	}
+
+	t.Run("recovery codes", func(t *testing.T) {
+		// typed the way they are read out, in capitals and with spaces
+		typed := strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", " - "))
+		if _, err := m.LoginMFA(ctx, pending, typed, "10.0.0.1"); err != nil {
+			t.Fatalf("LoginMFA with a recovery code = %v", err)
+		}
+
+		if _, err := m.LoginMFA(ctx, pending, recoveryCodes[0], "10.0.0.1"); !errors.Is(err, pkg.ErrInvalidMFACode) {
+			t.Fatalf("reusing a recovery code = %v, want ErrInvalidMFACode", err)
+		}
+
+		if _, err := m.LoginMFA(ctx, pending, recoveryCodes[1], "10.0.0.1"); err != nil {
+			t.Fatalf("LoginMFA with another recovery code = %v", err)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		if err := m.DisableMFA(ctx, user.ID, "Wrong-password-1", totpCode(t, secret, 1)); !errors.Is(err, pkg.ErrInvalidCredentials) {
+			t.Fatalf("DisableMFA with a wrong password = %v, want ErrInvalidCredentials", err)
+		}
+
+		if err := m.DisableMFA(ctx, user.ID, "Secret123!", "not-a-code"); !errors.Is(err, pkg.ErrInvalidMFACode) {
+			t.Fatalf("DisableMFA with a wrong code = %v, want ErrInvalidMFACode", err)
+		}
+
+		if err := m.DisableMFA(ctx, user.ID, "Secret123!", totpCode(t, secret, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, mfaRequired, err := m.LoginUser(ctx, creds, "10.0.0.1"); err != nil || mfaRequired {
+			t.Fatalf("login after DisableMFA = %v, %v, want a session", mfaRequired, err)
+		}
+
+		var left int64
+		m.db.Model(&UserRecoveryCode{}).Where("user_id = ?", user.ID).Count(&left)
+		if left != 0 {
+			t.Fatalf("%d recovery codes left after DisableMFA", left)
+		}
+
+		if err := m.DisableMFA(ctx, user.ID, "Secret123!", totpCode(t, secret, -1)); !errors.Is(err, pkg.ErrMFANotEnabled) {
+			t.Fatalf("DisableMFA twice = %v, want ErrMFANotEnabled", err)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// ExternalIdentity is what an identity provider vouches for after a login.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// IdentityProvider is an external login, an authorization code flow with
+// PKCE ending in a verified identity.
+type IdentityProvider interface {
+	Name() string
+	AuthCodeURL(state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, nonce, verifier string) (*ExternalIdentity, error)
+}
+
+// OIDCProvider is an OpenID Connect IdentityProvider. Discovery happens on
+// first use, so an unreachable IdP doesn't keep the API from starting.
+type OIDCProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	mu           sync.Mutex
+	oauth        *oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+func NewOIDCProvider(name, issuer, clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{name: name, issuer: issuer, clientID: clientID, clientSecret: clientSecret, redirectURL: redirectURL}
+}
+
+// NewOIDCProviderFromConfig returns nil when no issuer is configured.
+func NewOIDCProviderFromConfig(cfg config.OIDCConfig) IdentityProvider {
+	if cfg.Issuer == "" {
+		return nil
+	}
+
+	return NewOIDCProvider(cfg.ProviderName, cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.RedirectURL)
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.clientID})
+	return p.oauth, p.verifier, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	config, _, err := p.discover(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*ExternalIdentity, error) {
+	config, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &ExternalIdentity{
+		Provider:      p.name,
+		Subject:       idToken.Subject,
+		Email:         normaliseEmail(claims.Email),
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+type oidcState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + state
+}
+
+// StartOIDCLogin returns the IdP URL to send the browser to and the state
+// the callback has to come back with.
+func (m *UserModelORM) StartOIDCLogin(ctx context.Context) (string, string, error) {
+	if m.identity == nil {
+		return "", "", pkg.ErrSSODisabled
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	pending, _ := json.Marshal(oidcState{Nonce: nonce, Verifier: verifier})
+	if err := m.redis.Set(ctx, oidcStateKey(state), pending, oidcStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+
+	url, err := m.identity.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	return url, state, nil
+}
+
+// FinishOIDCLogin completes the flow started by StartOIDCLogin for the
+// linked, possibly just provisioned, user. Like LoginUser it returns a
+// session token, or an MFA pending token and true when the user has MFA
+// turned on.
+func (m *UserModelORM) FinishOIDCLogin(ctx context.Context, state, code string) (string, bool, error) {
+	if m.identity == nil {
+		return "", false, pkg.ErrSSODisabled
+	}
+
+	raw, err := m.redis.GetDel(ctx, oidcStateKey(state)).Result()
+	if err == redis.Nil {
+		return "", false, pkg.ErrInvalidToken
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	var pending oidcState
+	if err := json.Unmarshal([]byte(raw), &pending); err != nil {
+		return "", false, err
+	}
+
+	identity, err := m.identity.Exchange(ctx, code, pending.Nonce, pending.Verifier)
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("OIDC exchange failed: ", err)
+		return "", false, pkg.ErrInvalidToken
+	}
+
+	user, err := m.linkIdentity(ctx, identity)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !user.Active {
+		return "", false, pkg.ErrAccountInActive
+	}
+
+	if user.MFAEnabled {
+		token, err := m.generateToken(user.Email, user.ID, tokenPurposeMFA, mfaPendingTTL)
+		return token, true, err
+	}
+
+	token, err := m.completeLogin(ctx, user, "oidc")
+	return token, false, err
+}
+
+// linkIdentity finds the user behind an external identity. Unknown
+// identities with an email the IdP verified get a new, already active
+// account. They are never linked to an existing account of that email, an
+// IdP asserting an address must not be enough to take the account over.
+func (m *UserModelORM) linkIdentity(ctx context.Context, ext *ExternalIdentity) (*User, error) {
+	var user User
+	var activity string
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var identity UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", ext.Provider, ext.Subject).First(&identity).Error
+		if err == nil {
+			return tx.First(&user, identity.UserID).Error
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if !ext.EmailVerified || strings.TrimSpace(ext.Email) == "" {
+			return pkg.ErrUnverifiedEmail
+		}
+
+		err = tx.Where("email = ?", ext.Email).First(&user).Error
+		if err == nil {
+			return pkg.ErrAccountExists
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		secret, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+
+		// SSO users have no password of their own, this one is never shown
+		hashedPassword, err := m.GeneratePassword(secret[:20])
+		if err != nil {
+			return err
+		}
+
+		user = User{Email: ext.Email, HashPassw: string(hashedPassword), Active: true, VerifiedAt: time.Now()}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		activity = "New User Register via SSO"
+		metrics.Registrations.Inc()
+
+		identity = UserIdentity{UserID: user.ID, Provider: ext.Provider, Subject: ext.Subject, Email: ext.Email}
+		return tx.Create(&identity).Error
+	})
+
+	if err != nil || activity == "" {
+		return &user, err
+	}
+
+	log := UserActivityLog{UserID: user.ID, Activity: activity}
+	return &user, m.UserActivityLog(&log)
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-task/pkg"
+	"golang.org/x/oauth2"
+)
+
+// mockOIDCServer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier against the challenge it was sent.
+type mockOIDCServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDCServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "valid-code" || oauth2.S256ChallengeFromVerifier(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.URL,
+			"aud":   "client",
+			"sub":   "user-42",
+			"nonce": m.nonce,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+		}
+
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user approving the login at the IdP.
+func (m *mockOIDCServer) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected a S256 PKCE challenge, got %q", authURL)
+	}
+
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	server := newMockOIDCServer(t)
+	server.claims = jwt.MapClaims{"email": " Someone@Example.com", "email_verified": true}
+	provider := NewOIDCProvider("mock", server.URL, "client", "secret", "http://localhost/auth/oidc/callback")
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := provider.AuthCodeURL("state", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.authorize(t, authURL)
+	identity, err := provider.Exchange(context.Background(), "valid-code", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ExternalIdentity{Provider: "mock", Subject: "user-42", Email: "someone@example.com", EmailVerified: true}
+	if *identity != want {
+		t.Fatalf("got %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCProviderExchangeRejects(t *testing.T) {
+	server := newMockOIDCServer(t)
+	provider := NewOIDCProvider("mock", server.URL, "client", "secret", "http://localhost/auth/oidc/callback")
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := provider.AuthCodeURL("state", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.authorize(t, authURL)
+	tests := []struct {
+		name     string
+		code     string
+		nonce    string
+		verifier string
+	}{
+		{"wrong verifier", "valid-code", "nonce-1", oauth2.GenerateVerifier()},
+		{"wrong code", "other-code", "nonce-1", verifier},
+		{"replayed nonce", "valid-code", "nonce-2", verifier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.Exchange(context.Background(), tt.code, tt.nonce, tt.verifier); err == nil {
+				t.Fatal("expected the exchange to fail")
+			}
+		})
+	}
+}
+
+// fixedIdentity is an IdentityProvider every login at ends as identity.
+type fixedIdentity struct {
+	identity ExternalIdentity
+}
+
+func (p *fixedIdentity) Name() string { return p.identity.Provider }
+
+func (p *fixedIdentity) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + state, nil
+}
+
+func (p *fixedIdentity) Exchange(ctx context.Context, code, nonce, verifier string) (*ExternalIdentity, error) {
+	identity := p.identity
+	return &identity, nil
+}
+
+func TestFinishOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	m := testUsersORM(t)
+	provider := &fixedIdentity{ExternalIdentity{Provider: "mock", Subject: "user-42", Email: "sso@example.com", EmailVerified: true}}
+	m.identity = provider
+	login := func() (string, bool, error) {
+		_, state, err := m.StartOIDCLogin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return m.FinishOIDCLogin(ctx, state, "code")
+	}
+
+	token, mfaRequired, err := login()
+	if err != nil || mfaRequired {
+		t.Fatalf("first login = %v, %v, want a session", mfaRequired, err)
+	}
+
+	if claims, err := m.ParseToken(token); err != nil || !claims.IsSession() || claims.Email != "sso@example.com" {
+		t.Fatalf("first login token = %+v, %v", claims, err)
+	}
+
+	if _, _, err := m.FinishOIDCLogin(ctx, "unknown-state", "code"); !errors.Is(err, pkg.ErrInvalidToken) {
+		t.Fatalf("login with an unknown state = %v, want ErrInvalidToken", err)
+	}
+
+	t.Run("mfa", func(t *testing.T) {
+		if err := m.db.Model(&User{}).Where("email = ?", "sso@example.com").Update("mfa_enabled", true).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		token, mfaRequired, err := login()
+		if err != nil || !mfaRequired {
+			t.Fatalf("login with MFA on = %v, %v, want an MFA challenge", mfaRequired, err)
+		}
+
+		if claims, err := m.ParseToken(token); err != nil || claims.IsSession() {
+			t.Fatalf("login with MFA on issued a session token: %+v, %v", claims, err)
+		}
+	})
+
+	t.Run("existing account", func(t *testing.T) {
+		if err := m.RegisterUser(ctx, "taken@example.com", "Secret123!", "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+
+		provider.identity = ExternalIdentity{Provider: "mock", Subject: "attacker", Email: "taken@example.com", EmailVerified: true}
+		if _, _, err := login(); !errors.Is(err, pkg.ErrAccountExists) {
+			t.Fatalf("login as an existing account = %v, want ErrAccountExists", err)
+		}
+
+		var linked int64
+		m.db.Model(&UserIdentity{}).Where("subject = ?", "attacker").Count(&linked)
+		if linked != 0 {
+			t.Fatal("the identity was linked to the existing account")
+		}
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		provider.identity = ExternalIdentity{Provider: "mock", Subject: "user-43", Email: "new@example.com"}
+		if _, _, err := login(); !errors.Is(err, pkg.ErrUnverifiedEmail) {
+			t.Fatalf("login with an unverified email = %v, want ErrUnverifiedEmail", err)
+		}
+	})
+}
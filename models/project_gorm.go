@@ -0,0 +1,244 @@
+package models
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/iamgak/go-task/pkg"
+	"gorm.io/gorm"
+)
+
+// Project groups tasks of one user. Tasks of an archived project are left
+// out of listings unless these ask for the project.
+type Project struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"-" binding:"-"`
+	Name      string     `gorm:"size:100;not null" json:"name"`
+	Color     string     `gorm:"size:7" json:"color,omitempty"`
+	Archived  bool       `gorm:"default:false" json:"archived"`
+	Position  int        `gorm:"default:0" json:"position"`
+	CreatedAt *time.Time `json:"created_at,omitempty" binding:"-"`
+	UpdatedAt *time.Time `gorm:"default:null" json:"updated_at,omitempty" binding:"-"`
+	// Counts are the live tasks of the project by status
+	Counts map[string]int64 `gorm:"-" json:"counts"`
+}
+
+// ProjectTasks is what DeleteProject does with the tasks of the project:
+// soft delete them with Delete, otherwise move them to the project MoveTo,
+// or out of any project when it is nil.
+type ProjectTasks struct {
+	Delete bool
+	MoveTo *uint
+}
+
+// taskStatuses are the statuses the tasks table allows.
+var taskStatuses = []string{"pending", "in progress", "completed"}
+
+// newTaskCounts has every status, so a project without tasks counts zeros.
+func newTaskCounts() map[string]int64 {
+	counts := make(map[string]int64, len(taskStatuses))
+	for _, status := range taskStatuses {
+		counts[status] = 0
+	}
+
+	return counts
+}
+
+func (c *TaskModelORM) ProjectById(ctx context.Context, userID, projectID uint) (*Project, error) {
+	var project Project
+	result := c.db.WithContext(ctx).Where("id = ? AND user_id = ?", projectID, userID).First(&project)
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, pkg.ErrNoRecord
+	}
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &project, c.countTasks(ctx, []*Project{&project})
+}
+
+func (c *TaskModelORM) ProjectListing(ctx context.Context, userID uint, archived bool) ([]*Project, error) {
+	query := c.db.WithContext(ctx).Where("user_id = ?", userID)
+	if !archived {
+		query = query.Where("archived = ?", false)
+	}
+
+	var projects []*Project
+	if err := query.Order("position").Order("id").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+
+	return projects, c.countTasks(ctx, projects)
+}
+
+// countTasks fills in the Counts of the projects with one query.
+func (c *TaskModelORM) countTasks(ctx context.Context, projects []*Project) error {
+	byID := make(map[uint]*Project, len(projects))
+	ids := make([]uint, 0, len(projects))
+	for _, project := range projects {
+		project.Counts = newTaskCounts()
+		byID[project.ID] = project
+		ids = append(ids, project.ID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		ProjectID uint
+		Status    string
+		Count     int64
+	}
+
+	err := c.db.WithContext(ctx).
+		Model(&Task{}).
+		Select("project_id, status, COUNT(*) AS count").
+		Where("project_id IN ? AND is_deleted = ?", ids, false).
+		Group("project_id, status").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		byID[row.ProjectID].Counts[row.Status] = row.Count
+	}
+
+	return nil
+}
+
+func (c *TaskModelORM) CreateProject(ctx context.Context, project *Project) error {
+	if err := c.db.WithContext(ctx).Create(project).Error; err != nil {
+		return err
+	}
+
+	project.Counts = newTaskCounts()
+	return nil
+}
+
+func (c *TaskModelORM) UpdateProject(ctx context.Context, id uint, project *Project) error {
+	c.mute.Lock()
+	defer c.mute.Unlock()
+	updates := map[string]interface{}{
+		"name":       project.Name,
+		"color":      project.Color,
+		"archived":   project.Archived,
+		"position":   project.Position,
+		"updated_at": time.Now(),
+	}
+
+	result := c.db.WithContext(ctx).
+		Model(&Project{}).
+		Where("id = ? AND user_id = ?", id, project.UserID).
+		Updates(updates)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return pkg.ErrInvalidUserFound
+	}
+
+	// archiving shows or hides its tasks in the listings
+	c.invalidate(ctx, 0)
+	return nil
+}
+
+func (c *TaskModelORM) DeleteProject(ctx context.Context, userID, projectID uint, tasks ProjectTasks) error {
+	c.mute.Lock()
+	defer c.mute.Unlock()
+	var moved []uint
+	err := c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var project Project
+		if err := tx.Where("id = ? AND user_id = ?", projectID, userID).First(&project).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return pkg.ErrInvalidUserFound
+			}
+
+			return err
+		}
+
+		if !tasks.Delete && tasks.MoveTo != nil {
+			if *tasks.MoveTo == projectID {
+				return pkg.ErrUnknownProject
+			}
+
+			if err := ownsProject(tx, userID, *tasks.MoveTo); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&Task{}).Where("project_id = ? AND is_deleted = ?", projectID, false).Pluck("id", &moved).Error; err != nil {
+			return err
+		}
+
+		if tasks.Delete {
+			now := time.Now()
+			err := tx.Model(&Task{}).
+				Where("project_id = ? AND is_deleted = ?", projectID, false).
+				Updates(map[string]interface{}{"deleted_at": now, "is_deleted": true}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// deleted tasks leave the project too, nothing may point at it
+		moveTo := tasks.MoveTo
+		if tasks.Delete {
+			moveTo = nil
+		}
+
+		if err := tx.Model(&Task{}).Where("project_id = ?", projectID).Update("project_id", moveTo).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&project).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	c.invalidate(ctx, 0, moved...)
+	return nil
+}
+
+// ownsProject returns pkg.ErrUnknownProject unless the project exists and
+// belongs to the user.
+func ownsProject(db *gorm.DB, userID, projectID uint) error {
+	var count int64
+	if err := db.Model(&Project{}).Where("id = ? AND user_id = ?", projectID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return pkg.ErrUnknownProject
+	}
+
+	return nil
+}
+
+func (c *TaskModelORM) ValidateProjectData(project *Project) *pkg.Validator {
+	return validateProjectData(project)
+}
+
+var projectColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func validateProjectData(project *Project) *pkg.Validator {
+	validator := &pkg.Validator{
+		Errors: make(map[string]string),
+	}
+
+	validator.CheckField(validator.NotBlank(project.Name), "name", "Please, fill the name field")
+	validator.CheckField(validator.MaxChars(project.Name, 100), "name", "Name must not be longer than 100 characters")
+	if project.Color != "" {
+		validator.CheckField(projectColor.MatchString(project.Color), "color", "Color must look like #1a2b3c")
+	}
+
+	validator.CheckField(project.Position >= 0, "position", "Position must not be negative")
+	return validator
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitPolicy is the request budget of one group of routes: Limit
+// requests per Period, of which up to Burst may arrive back to back.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+func (p RateLimitPolicy) interval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// gcraScript implements the generic cell rate algorithm. The key holds the
+// theoretical arrival time (TAT) in milliseconds of redis server time, so
+// every replica shares one budget regardless of its own clock.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+local new_tat = tat + interval
+local allow_at = new_tat - interval * burst
+if now < allow_at then
+	return {0, 0, allow_at - now, tat - now}
+end
+redis.call("SET", KEYS[1], new_tat, "PX", new_tat - now)
+return {1, math.floor((now - allow_at) / interval), 0, new_tat - now}
+`)
+
+type localLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter checks budgets in redis and falls back to per process token
+// buckets whenever redis can't be reached.
+type RateLimiter struct {
+	client *redis.Client
+	logger *logrus.Logger
+	mu     sync.Mutex
+	local  map[string]*localLimiter
+}
+
+func NewRateLimiter(client *redis.Client, logger *logrus.Logger) *RateLimiter {
+	return &RateLimiter{client: client, logger: logger, local: make(map[string]*localLimiter)}
+}
+
+// Run forgets idle in-memory buckets every minute until ctx is done.
+func (r *RateLimiter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.cleanup(3 * time.Minute)
+		}
+	}
+}
+
+func (r *RateLimiter) Allow(ctx context.Context, policy RateLimitPolicy, key string) *RateLimitResult {
+	key = "ratelimit:" + policy.Name + ":" + key
+	res, err := gcraScript.Run(ctx, r.client, []string{key}, policy.interval().Milliseconds(), policy.Burst).Int64Slice()
+	if err != nil {
+		logging.FromContext(ctx, r.logger).Warn("Rate limiter falling back to memory: ", err)
+		return r.allowLocal(policy, key)
+	}
+
+	return &RateLimitResult{
+		Allowed:    res[0] == 1,
+		Limit:      policy.Limit,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		ResetAfter: time.Duration(res[3]) * time.Millisecond,
+	}
+}
+
+func (r *RateLimiter) allowLocal(policy RateLimitPolicy, key string) *RateLimitResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.local[key]; !found {
+		r.local[key] = &localLimiter{
+			limiter: rate.NewLimiter(rate.Every(policy.interval()), policy.Burst),
+		}
+	}
+
+	client := r.local[key]
+	client.lastSeen = time.Now()
+	result := &RateLimitResult{Limit: policy.Limit}
+	reservation := client.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		result.RetryAfter = delay
+		result.ResetAfter = time.Duration(policy.Burst) * policy.interval()
+		return result
+	}
+
+	result.Allowed = true
+	tokens := client.limiter.Tokens()
+	if tokens > 0 {
+		result.Remaining = int(tokens)
+	}
+
+	result.ResetAfter = time.Duration((float64(policy.Burst) - tokens) * float64(policy.interval()))
+	return result
+}
+
+// cleanup forgets the in-memory buckets of clients that haven't been seen
+// within maxIdle.
+func (r *RateLimiter) cleanup(maxIdle time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, client := range r.local {
+		if time.Since(client.lastSeen) > maxIdle {
+			delete(r.local, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+func testRateLimiter(t *testing.T) (*RateLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewRateLimiter(client, logger), server
+}
+
+// tenPerMinute lets a request through every 6 seconds, 5 of them at once.
+var tenPerMinute = RateLimitPolicy{Name: "test", Limit: 10, Period: time.Minute, Burst: 5}
+
+func TestRateLimiterAllow(t *testing.T) {
+	ctx := context.Background()
+	limiter, server := testRateLimiter(t)
+	now := time.Now()
+	server.SetTime(now)
+
+	for want := 4; want >= 0; want-- {
+		result := limiter.Allow(ctx, tenPerMinute, "ip:1")
+		if !result.Allowed || result.Remaining != want || result.Limit != 10 {
+			t.Fatalf("request within the burst = %+v, want allowed with %d remaining", result, want)
+		}
+	}
+
+	result := limiter.Allow(ctx, tenPerMinute, "ip:1")
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter != 6*time.Second || result.ResetAfter != 30*time.Second {
+		t.Fatalf("request past the burst = %+v, want it denied for 6s", result)
+	}
+
+	if result := limiter.Allow(ctx, tenPerMinute, "ip:2"); !result.Allowed || result.Remaining != 4 {
+		t.Fatalf("another client = %+v, want its own budget", result)
+	}
+
+	if result := limiter.Allow(ctx, RateLimitPolicy{Name: "other", Limit: 10, Period: time.Minute, Burst: 5}, "ip:1"); !result.Allowed {
+		t.Fatalf("another policy = %+v, want its own budget", result)
+	}
+
+	// the budget refills one request per interval of redis time
+	server.SetTime(now.Add(6 * time.Second))
+	if result := limiter.Allow(ctx, tenPerMinute, "ip:1"); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request an interval later = %+v, want it allowed", result)
+	}
+
+	if result := limiter.Allow(ctx, tenPerMinute, "ip:1"); result.Allowed {
+		t.Fatalf("second request an interval later = %+v, want it denied", result)
+	}
+}
+
+func TestRateLimiterFallsBackToMemory(t *testing.T) {
+	ctx := context.Background()
+	limiter, server := testRateLimiter(t)
+	server.Close()
+
+	for i := 0; i < tenPerMinute.Burst; i++ {
+		if result := limiter.Allow(ctx, tenPerMinute, "ip:1"); !result.Allowed {
+			t.Fatalf("request %d within the burst = %+v, want it allowed", i+1, result)
+		}
+	}
+
+	result := limiter.Allow(ctx, tenPerMinute, "ip:1")
+	if result.Allowed || result.RetryAfter <= 0 {
+		t.Fatalf("request past the burst without redis = %+v, want it denied", result)
+	}
+
+	if result := limiter.Allow(ctx, tenPerMinute, "ip:2"); !result.Allowed {
+		t.Fatalf("another client without redis = %+v, want its own budget", result)
+	}
+
+	limiter.cleanup(0)
+	if result := limiter.Allow(ctx, tenPerMinute, "ip:1"); !result.Allowed {
+		t.Fatalf("request after the idle bucket was dropped = %+v, want a fresh budget", result)
+	}
+}
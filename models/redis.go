@@ -2,7 +2,8 @@ package models
 
 import (
 	"context"
-	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -14,51 +15,113 @@ type RedisStruct struct {
 	logger *logrus.Logger
 }
 
-func (c *RedisStruct) getRedis(ctx context.Context, key string) (interface{}, error) {
-	val, err := c.client.Get(ctx, key).Result()
+func (c *RedisStruct) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+
 	return val, err
 }
 
-func (c *RedisStruct) setRedis(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	err := c.client.Set(ctx, key, value, expiration).Err()
-	return err
+func (c *RedisStruct) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
 }
 
-func (c *RedisStruct) deleteRedis(ctx context.Context, key string) error {
-	err := c.client.Del(ctx, key).Err()
-	return err
+func (c *RedisStruct) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return c.client.Del(ctx, keys...).Err()
 }
+
 func (c *RedisStruct) Publish(ctx context.Context, msg []byte) error {
 	// msg := []byte("New to-do item added")
 	err := c.client.Publish(ctx, "todo.notifications", msg).Err()
 	return err
 }
 
-func (c *RedisStruct) Subscribe(ctx context.Context) {
-	// msg := []byte("New to-do item added")
+// Subscribe logs notifications until ctx is done.
+func (c *RedisStruct) Subscribe(ctx context.Context) error {
 	sub := c.client.Subscribe(ctx, "todo.notifications")
+	defer sub.Close()
 	ch := sub.Channel()
-	for msg := range ch {
-		c.logger.Info("Received message:", msg)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			c.logger.Info("Received message:", msg)
+		}
 	}
 }
 
-func (m *RedisStruct) FlushCache(ctx context.Context) error {
-	recordType := "tasks:listing:"
-	pattern := fmt.Sprintf("%s:*", recordType)
-	keys, err := m.client.Keys(ctx, pattern).Result()
-	if err != nil {
-		m.logger.Errorf("Error fetching keys:%T", err)
+func (c *RedisStruct) Incr(ctx context.Context, key string) (int64, error) {
+	return c.client.Incr(ctx, key).Result()
+}
+
+// Clear deletes the "tasks:" keys. SCAN walks them in batches, so Redis
+// keeps serving the API meanwhile.
+func (m *RedisStruct) Clear(ctx context.Context) error {
+	iter := m.client.Scan(ctx, 0, "tasks:*", 500).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			if err := m.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+
+			keys = keys[:0]
+		}
+	}
+
+	if err := iter.Err(); err != nil {
 		return err
 	}
 
-	if len(keys) > 0 {
-		_, err := m.client.Del(ctx, keys...).Result()
-		if err != nil {
-			m.logger.Errorf("Error deleting cache keys:%T", err)
-			return err
+	return m.Delete(ctx, keys...)
+}
+
+// Stats counts the "tasks:" keys. Hits, misses and memory are those INFO
+// reports for the whole Redis server.
+func (m *RedisStruct) Stats(ctx context.Context) (*CacheStats, error) {
+	stats := &CacheStats{Keys: make(map[string]int64)}
+	iter := m.client.Scan(ctx, 0, "tasks:*", 500).Iterator()
+	for iter.Next(ctx) {
+		stats.Keys[cacheKeyKind(iter.Val())]++
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	info, err := m.client.Info(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(info, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch name {
+		case "keyspace_hits":
+			stats.Hits = n
+		case "keyspace_misses":
+			stats.Misses = n
+		case "used_memory":
+			stats.UsedMemory = n
 		}
 	}
 
-	return err
+	return stats, nil
 }
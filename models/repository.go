@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/iamgak/go-task/pkg"
+)
+
+// TaskRepository stores tasks. TaskModelORM keeps them in the database
+// behind a Cache, MemoryTaskRepository in a map.
+//
+// Tasks are personal or belong to a workspace. A workspace id of 0 stands
+// for the personal tasks, those are changed by their user only. Tasks of a
+// workspace are changed by whoever the caller let through, checking the
+// membership is up to it.
+type TaskRepository interface {
+	// TaskById returns pkg.ErrNoRecord for unknown and deleted tasks and for
+	// those of another workspace.
+	TaskById(ctx context.Context, workspaceID uint, taskID int) (*Task, error)
+	TaskListing(ctx context.Context, f *Filters) ([]*Task, error)
+	CreateTask(ctx context.Context, task *Task) error
+	// CreateTask and UpdateTask return pkg.ErrUnknownProject unless the
+	// project of the task belongs to the user, tasks of a workspace aren't
+	// in projects.
+	// UpdateTask and SoftDelete return pkg.ErrInvalidUserFound unless the
+	// task exists in the workspace, or for personal tasks belongs to the
+	// user. UpdateTask finds it in task.WorkspaceID.
+	UpdateTask(ctx context.Context, id int, task *Task) error
+	SoftDelete(ctx context.Context, workspaceID, userID, taskID uint) error
+	ValidateTaskData(task *Task, updated bool) *pkg.Validator
+	// PurgeDeleted removes tasks soft deleted before the given time for
+	// good and returns how many there were.
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ProjectRepository stores the projects tasks are grouped in, each belongs
+// to one user and is only found for them. The task repositories implement
+// it too, changing a project changes what task reads return.
+type ProjectRepository interface {
+	// ProjectById returns pkg.ErrNoRecord for unknown projects and those of
+	// other users. It, ProjectListing and CreateProject fill in Counts.
+	ProjectById(ctx context.Context, userID, projectID uint) (*Project, error)
+	// ProjectListing orders by Position, archived projects are only listed
+	// with archived.
+	ProjectListing(ctx context.Context, userID uint, archived bool) ([]*Project, error)
+	CreateProject(ctx context.Context, project *Project) error
+	// UpdateProject and DeleteProject return pkg.ErrInvalidUserFound unless
+	// the project exists and belongs to the user.
+	UpdateProject(ctx context.Context, id uint, project *Project) error
+	// DeleteProject returns pkg.ErrUnknownProject when the tasks are to be
+	// moved to a project that isn't another of the user's.
+	DeleteProject(ctx context.Context, userID, projectID uint, tasks ProjectTasks) error
+	ValidateProjectData(project *Project) *pkg.Validator
+}
+
+// WorkspaceRepository stores the workspaces and who is a member with which
+// role. It doesn't check who asks, the handlers do from Member.
+type WorkspaceRepository interface {
+	// CreateWorkspace makes the user its owner.
+	CreateWorkspace(ctx context.Context, workspace *Workspace, ownerID uint) error
+	// WorkspaceListing is the workspaces of the user, with its Role.
+	WorkspaceListing(ctx context.Context, userID uint) ([]*Workspace, error)
+	// WorkspaceById fills in the Members. It and Member return
+	// pkg.ErrNoRecord for unknown workspaces and users that aren't members.
+	WorkspaceById(ctx context.Context, workspaceID uint) (*Workspace, error)
+	Member(ctx context.Context, workspaceID, userID uint) (*WorkspaceMember, error)
+	// SetMemberRole and RemoveMember return pkg.ErrLastOwner rather than
+	// leave the workspace without an owner.
+	SetMemberRole(ctx context.Context, workspaceID, userID uint, role string) error
+	RemoveMember(ctx context.Context, workspaceID, userID uint) error
+	// Invite mails a link with the token of the invitation to its email,
+	// it returns pkg.ErrAlreadyMember if a member has that email.
+	Invite(ctx context.Context, invitation *WorkspaceInvitation) error
+	// AcceptInvitation returns pkg.ErrNoRecord for unknown, used and
+	// expired tokens and for invitations to another email.
+	AcceptInvitation(ctx context.Context, token string, userID uint, email string) (*WorkspaceMember, error)
+	WorkspaceIDs(ctx context.Context) ([]uint, error)
+	ValidateWorkspaceData(workspace *Workspace) *pkg.Validator
+	ValidateInvitationData(invitation *WorkspaceInvitation) *pkg.Validator
+}
+
+// UserRepository is the account lifecycle: registering, activating and
+// logging in. MFA, single sign-on, API tokens and lockouts are only offered
+// by UserModelORM.
+type UserRepository interface {
+	RegisterUser(ctx context.Context, email, password, ip string) error
+	// ActivateAccount returns pkg.ErrNoRecord for unknown tokens.
+	ActivateAccount(token string) error
+	// LoginUser returns a session token, or an MFA pending token and true
+	// when the user has MFA turned on.
+	LoginUser(ctx context.Context, creds *UserStruct, ip string) (string, bool, error)
+	ParseToken(tokenString string) (*MyCustomClaims, error)
+	// UserByEmail returns pkg.ErrNoRecord when nobody has the address.
+	UserByEmail(ctx context.Context, email string) (*User, error)
+	UserActivityLog(activity *UserActivityLog) error
+	ValidateUserData(user *UserStruct, register bool) *pkg.Validator
+	// SetActive, SetPassword and SetRole are for administrators, they skip
+	// the mails and checks of the self service flows. They return
+	// pkg.ErrNoRecord for unknown users. A new password or deactivation ends
+	// the sessions of the user, deactivation revokes its API tokens too.
+	SetActive(ctx context.Context, userID uint, active bool) error
+	SetPassword(ctx context.Context, userID uint, password string) error
+	SetRole(ctx context.Context, userID uint, role string) error
+}
+
+var (
+	_ TaskRepository      = (*TaskModelORM)(nil)
+	_ TaskRepository      = (*MemoryTaskRepository)(nil)
+	_ ProjectRepository   = (*TaskModelORM)(nil)
+	_ ProjectRepository   = (*MemoryTaskRepository)(nil)
+	_ WorkspaceRepository = (*WorkspaceModelORM)(nil)
+	_ WorkspaceRepository = (*MemoryWorkspaceRepository)(nil)
+	_ UserRepository      = (*UserModelORM)(nil)
+	_ UserRepository      = (*MemoryUserRepository)(nil)
+	_ Cache               = (*RedisStruct)(nil)
+	_ Cache               = (*MemoryCache)(nil)
+)
@@ -0,0 +1,1071 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/migrations"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// noCache misses every lookup, so the GORM repository is checked against
+// the database alone.
+type noCache struct{}
+
+func (noCache) Get(context.Context, string) ([]byte, error)              { return nil, ErrCacheMiss }
+func (noCache) Set(context.Context, string, []byte, time.Duration) error { return nil }
+func (noCache) Delete(context.Context, ...string) error                  { return nil }
+func (noCache) Incr(context.Context, string) (int64, error)              { return 1, nil }
+func (noCache) Clear(context.Context) error                              { return nil }
+func (noCache) Stats(context.Context) (*CacheStats, error)               { return &CacheStats{}, nil }
+
+// testDB is a fresh in-memory SQLite database with the migrated schema.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := OpenDB(config.DBConfig{Driver: "sqlite", Database: ":memory:"}, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quiet := logrus.New()
+	quiet.SetLevel(logrus.WarnLevel)
+	migrator, err := migrations.New(sqlDB, "sqlite", quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func taskRepositories() map[string]func(t *testing.T) TaskRepository {
+	return map[string]func(t *testing.T) TaskRepository{
+		"memory": func(t *testing.T) TaskRepository { return NewMemoryTaskRepository() },
+		"gorm": func(t *testing.T) TaskRepository {
+			return &TaskModelORM{db: testDB(t), cache: noCache{}, logger: logrus.New(), cacheTTL: config.Default().Cache}
+		},
+	}
+}
+
+func userRepositories() map[string]func(t *testing.T) UserRepository {
+	return map[string]func(t *testing.T) UserRepository{
+		"memory": func(t *testing.T) UserRepository { return NewMemoryUserRepository(time.Hour) },
+		"gorm":   func(t *testing.T) UserRepository { return testUsersORM(t) },
+	}
+}
+
+// testUsersORM is a UserModelORM on a fresh database and Redis, with a
+// signing key in place.
+func testUsersORM(t *testing.T) *UserModelORM {
+	t.Helper()
+	db := testDB(t)
+	log := logrus.New()
+	signer := NewTokenSigner(db, log, config.JWTConfig{
+		Algorithm:   "EdDSA",
+		Issuer:      "go-task",
+		Audience:    "go-task",
+		KeyRotation: time.Hour,
+		SessionTTL:  time.Hour,
+	})
+
+	if err := signer.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &UserModelORM{db: db, redis: testRedis(t), logger: log, mailer: &LogMailer{logger: log}, signer: signer, sessionTTL: time.Hour}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+	if _, err := cache.Get(ctx, "tasks:id:1"); err != ErrCacheMiss {
+		t.Fatalf("Get of a missing key = %v, want ErrCacheMiss", err)
+	}
+
+	cache.Set(ctx, "tasks:id:1", []byte(`{"id":1}`), time.Minute)
+	cache.Set(ctx, "tasks:listing:a", []byte(`[]`), time.Minute)
+	cache.Set(ctx, "expired", []byte(`x`), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if value, err := cache.Get(ctx, "tasks:id:1"); err != nil || string(value) != `{"id":1}` {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+
+	if _, err := cache.Get(ctx, "expired"); err != ErrCacheMiss {
+		t.Fatalf("Get of an expired key = %v, want ErrCacheMiss", err)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if n, err := cache.Incr(ctx, "tasks:gen:listing"); err != nil || n != want {
+			t.Fatalf("Incr = %d, %v, want %d", n, err, want)
+		}
+	}
+
+	if _, err := cache.Incr(ctx, "tasks:id:1"); err == nil {
+		t.Fatal("Incr of a value that isn't a number succeeded")
+	}
+
+	cache.Delete(ctx, "tasks:id:1")
+	if _, err := cache.Get(ctx, "tasks:id:1"); err != ErrCacheMiss {
+		t.Fatal("Delete kept the key")
+	}
+}
+
+func TestLRUCacheEvicts(t *testing.T) {
+	ctx := context.Background()
+	// room for three entries of a 10 byte key and a 10 byte value
+	cache := NewLRUCache(60)
+	for _, key := range []string{"tasks:id:1", "tasks:id:2", "tasks:id:3"} {
+		cache.Set(ctx, key, []byte("0123456789"), time.Minute)
+	}
+
+	// using the oldest entry leaves the second the least recently used
+	cache.Get(ctx, "tasks:id:1")
+	cache.Set(ctx, "tasks:id:4", []byte("0123456789"), time.Minute)
+	if _, err := cache.Get(ctx, "tasks:id:2"); err != ErrCacheMiss {
+		t.Fatal("the least recently used entry wasn't evicted")
+	}
+
+	for _, key := range []string{"tasks:id:1", "tasks:id:3", "tasks:id:4"} {
+		if _, err := cache.Get(ctx, key); err != nil {
+			t.Fatalf("%s was evicted: %v", key, err)
+		}
+	}
+
+	if stats, _ := cache.Stats(ctx); stats.UsedMemory > 60 {
+		t.Fatalf("the cache uses %d bytes, more than its 60", stats.UsedMemory)
+	}
+
+	// a value larger than the whole cache isn't kept
+	cache.Set(ctx, "tasks:id:5", make([]byte, 100), time.Minute)
+	if _, err := cache.Get(ctx, "tasks:id:5"); err != ErrCacheMiss {
+		t.Fatal("an entry larger than the cache was kept")
+	}
+}
+
+func TestCacheClearAndStats(t *testing.T) {
+	for name, open := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := open(t)
+			cache.Set(ctx, "tasks:id:1", []byte(`{"id":1}`), time.Minute)
+			cache.Set(ctx, "tasks:id:2", []byte(`{"id":2}`), time.Minute)
+			cache.Set(ctx, "tasks:listing:a", []byte(`[]`), time.Minute)
+
+			stats, err := cache.Stats(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.Keys["id"] != 2 || stats.Keys["listing"] != 1 {
+				t.Fatalf("Stats keys = %v, want 2 id and 1 listing", stats.Keys)
+			}
+
+			if err := cache.Clear(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{"tasks:id:1", "tasks:listing:a"} {
+				if _, err := cache.Get(ctx, key); err != ErrCacheMiss {
+					t.Fatalf("Clear kept %s", key)
+				}
+			}
+		})
+	}
+}
+
+func newTask(userID uint, title, status string) *Task {
+	return &Task{UserID: userID, Title: title, Description: title + " description", Status: status}
+}
+
+func TestTaskRepositoryConformance(t *testing.T) {
+	for name, open := range taskRepositories() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("create and read", func(t *testing.T) {
+				repo := open(t)
+				task := newTask(1, "write tests", "pending")
+				if err := repo.CreateTask(ctx, task); err != nil {
+					t.Fatal(err)
+				}
+
+				if task.ID == 0 {
+					t.Fatal("CreateTask didn't assign an ID")
+				}
+
+				got, err := repo.TaskById(ctx, 0, int(task.ID))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.Title != "write tests" || got.Status != "pending" || got.UserID != 1 || got.Version != 1 {
+					t.Fatalf("TaskById = %+v", got)
+				}
+
+				if _, err := repo.TaskById(ctx, 0, int(task.ID)+1000); !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("TaskById of a missing task = %v, want ErrNoRecord", err)
+				}
+			})
+
+			t.Run("update", func(t *testing.T) {
+				repo := open(t)
+				task := newTask(1, "draft", "pending")
+				repo.CreateTask(ctx, task)
+
+				due := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+				update := &Task{UserID: 1, Title: "final", Description: "done", Status: "completed", DueAt: &due}
+				if err := repo.UpdateTask(ctx, int(task.ID), update); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := repo.TaskById(ctx, 0, int(task.ID))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.Title != "final" || got.Status != "completed" || got.Version != 2 || got.DueAt == nil || got.UpdatedAt == nil {
+					t.Fatalf("TaskById after UpdateTask = %+v", got)
+				}
+
+				// without a due date the old one stays
+				if err := repo.UpdateTask(ctx, int(task.ID), &Task{UserID: 1, Title: "again", Description: "done", Status: "completed"}); err != nil {
+					t.Fatal(err)
+				}
+
+				if got, _ := repo.TaskById(ctx, 0, int(task.ID)); got.DueAt == nil || got.Version != 3 {
+					t.Fatalf("UpdateTask without due_at = %+v", got)
+				}
+
+				if err := repo.UpdateTask(ctx, int(task.ID), &Task{UserID: 1, Title: "again", Description: "done", Status: "completed", ClearDueAt: true}); err != nil {
+					t.Fatal(err)
+				}
+
+				if got, _ := repo.TaskById(ctx, 0, int(task.ID)); got.DueAt != nil || got.Version != 4 {
+					t.Fatalf("UpdateTask clearing due_at = %+v", got)
+				}
+
+				if err := repo.UpdateTask(ctx, int(task.ID), &Task{UserID: 2, Title: "x", Description: "x", Status: "pending"}); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("UpdateTask by another user = %v, want ErrInvalidUserFound", err)
+				}
+
+				if err := repo.UpdateTask(ctx, int(task.ID)+1000, update); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("UpdateTask of a missing task = %v, want ErrInvalidUserFound", err)
+				}
+			})
+
+			t.Run("soft delete", func(t *testing.T) {
+				repo := open(t)
+				task := newTask(1, "temporary", "pending")
+				repo.CreateTask(ctx, task)
+
+				if err := repo.SoftDelete(ctx, 0, 2, task.ID); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("SoftDelete by another user = %v, want ErrInvalidUserFound", err)
+				}
+
+				if err := repo.SoftDelete(ctx, 0, 1, task.ID); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := repo.TaskById(ctx, 0, int(task.ID)); !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("TaskById of a deleted task = %v, want ErrNoRecord", err)
+				}
+
+				if err := repo.SoftDelete(ctx, 0, 1, task.ID); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("second SoftDelete = %v, want ErrInvalidUserFound", err)
+				}
+
+				if err := repo.UpdateTask(ctx, int(task.ID), newTask(1, "back", "pending")); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("UpdateTask of a deleted task = %v, want ErrInvalidUserFound", err)
+				}
+			})
+
+			t.Run("purge deleted", func(t *testing.T) {
+				repo := open(t)
+				kept, purged := newTask(1, "kept", "pending"), newTask(1, "purged", "pending")
+				repo.CreateTask(ctx, kept)
+				repo.CreateTask(ctx, purged)
+				repo.SoftDelete(ctx, 0, 1, purged.ID)
+
+				if n, err := repo.PurgeDeleted(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+					t.Fatalf("PurgeDeleted of older tasks = %d, %v, want nothing purged", n, err)
+				}
+
+				if n, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+					t.Fatalf("PurgeDeleted = %d, %v, want 1", n, err)
+				}
+
+				if _, err := repo.TaskById(ctx, 0, int(kept.ID)); err != nil {
+					t.Fatalf("PurgeDeleted took a live task: %v", err)
+				}
+			})
+
+			t.Run("listing", func(t *testing.T) {
+				repo := open(t)
+				var ids []uint
+				for i, status := range []string{"pending", "completed", "pending", "in progress", "pending"} {
+					task := newTask(uint(i%2+1), status, status)
+					if err := repo.CreateTask(ctx, task); err != nil {
+						t.Fatal(err)
+					}
+
+					ids = append(ids, task.ID)
+				}
+
+				repo.SoftDelete(ctx, 0, 1, ids[4])
+
+				all, err := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want := []uint{ids[3], ids[2], ids[1], ids[0]}; !sameIDs(all, want) {
+					t.Fatalf("TaskListing = %v, want %v newest first without the deleted one", taskIDs(all), want)
+				}
+
+				pending, _ := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, Status: "pending"})
+				if want := []uint{ids[2], ids[0]}; !sameIDs(pending, want) {
+					t.Fatalf("TaskListing status=pending = %v, want %v", taskIDs(pending), want)
+				}
+
+				page, _ := repo.TaskListing(ctx, &Filters{CurrPage: 2, PageSize: 3})
+				if want := []uint{ids[0]}; !sameIDs(page, want) {
+					t.Fatalf("TaskListing page 2 = %v, want %v", taskIDs(page), want)
+				}
+
+				past, err := repo.TaskListing(ctx, &Filters{CurrPage: 5, PageSize: 3})
+				if err != nil || len(past) != 0 {
+					t.Fatalf("TaskListing past the end = %v, %v", taskIDs(past), err)
+				}
+			})
+
+			t.Run("page bounds", func(t *testing.T) {
+				repo := open(t)
+				var ids []uint
+				for i := 0; i < maxPageSize+5; i++ {
+					task := newTask(1, "task", "pending")
+					if err := repo.CreateTask(ctx, task); err != nil {
+						t.Fatal(err)
+					}
+
+					ids = append(ids, task.ID)
+				}
+
+				for _, f := range []*Filters{{CurrPage: 1, PageSize: -1}, {CurrPage: 1, PageSize: 0}, {CurrPage: -3, PageSize: -1}} {
+					tasks, err := repo.TaskListing(ctx, f)
+					if err != nil || len(tasks) != defaultPageSize || tasks[0].ID != ids[len(ids)-1] {
+						t.Fatalf("TaskListing page %d limit %d = %v, %v, want the first %d", f.CurrPage, f.PageSize, taskIDs(tasks), err, defaultPageSize)
+					}
+				}
+
+				tasks, err := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 1000})
+				if err != nil || len(tasks) != maxPageSize {
+					t.Fatalf("TaskListing limit 1000 = %d tasks, %v, want %d", len(tasks), err, maxPageSize)
+				}
+
+				if key := (Filters{CurrPage: -2, PageSize: -1}).cacheKey(); key != (Filters{CurrPage: 1, PageSize: defaultPageSize}).cacheKey() {
+					t.Fatalf("cacheKey of page -2 limit -1 = %q, want that of the first page", key)
+				}
+			})
+
+			t.Run("listing filters", func(t *testing.T) {
+				repo := open(t)
+				day := func(d int) *time.Time {
+					due := time.Date(2030, time.January, d, 12, 0, 0, 0, time.UTC)
+					return &due
+				}
+
+				var ids []uint
+				for _, due := range []*time.Time{day(20), day(10), nil, day(15)} {
+					task := newTask(1, "dated", "pending")
+					task.DueAt = due
+					if err := repo.CreateTask(ctx, task); err != nil {
+						t.Fatal(err)
+					}
+
+					ids = append(ids, task.ID)
+				}
+
+				byDue, _ := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, SortBy: "due_at", SortOrder: "asc", DueAfter: "2030-01-01"})
+				if want := []uint{ids[1], ids[3], ids[0]}; !sameIDs(byDue, want) {
+					t.Fatalf("TaskListing by due_at asc = %v, want %v", taskIDs(byDue), want)
+				}
+
+				window, _ := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, SortBy: "due_at", SortOrder: "desc", DueAfter: "2030-01-11", DueBefore: "2030-01-20"})
+				if want := []uint{ids[3]}; !sameIDs(window, want) {
+					t.Fatalf("TaskListing between 11 and 20 January = %v, want %v", taskIDs(window), want)
+				}
+
+				before, _ := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, SortBy: "bogus", DueBefore: "2030-01-16"})
+				if want := []uint{ids[3], ids[1]}; !sameIDs(before, want) {
+					t.Fatalf("TaskListing before 16 January = %v, want %v", taskIDs(before), want)
+				}
+			})
+
+			t.Run("validation", func(t *testing.T) {
+				repo := open(t)
+				if v := repo.ValidateTaskData(newTask(1, "ok", "pending"), false); !v.Valid() {
+					t.Fatalf("valid task rejected: %v", v.Errors)
+				}
+
+				v := repo.ValidateTaskData(&Task{Status: "someday"}, false)
+				for _, field := range []string{"title", "description", "status"} {
+					if v.Errors[field] == "" {
+						t.Errorf("no error for %s in %v", field, v.Errors)
+					}
+				}
+			})
+		})
+	}
+}
+
+func inProject(task *Task, projectID uint) *Task {
+	task.ProjectID = &projectID
+	return task
+}
+
+func TestProjectRepositoryConformance(t *testing.T) {
+	for name, open := range taskRepositories() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			all := &Filters{CurrPage: 1, PageSize: 10, SortOrder: "asc"}
+			setup := func(t *testing.T) (TaskRepository, ProjectRepository) {
+				repo := open(t)
+				return repo, repo.(ProjectRepository)
+			}
+
+			t.Run("create, read and list", func(t *testing.T) {
+				tasks, projects := setup(t)
+				second, first := &Project{UserID: 1, Name: "second", Position: 2}, &Project{UserID: 1, Name: "first", Color: "#ff0000", Position: 1}
+				for _, project := range []*Project{second, first, {UserID: 2, Name: "other"}} {
+					if err := projects.CreateProject(ctx, project); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				for _, task := range []*Task{inProject(newTask(1, "a", "pending"), first.ID), inProject(newTask(1, "b", "pending"), first.ID), inProject(newTask(1, "c", "completed"), first.ID), inProject(newTask(1, "d", "pending"), first.ID)} {
+					if err := tasks.CreateTask(ctx, task); err != nil {
+						t.Fatal(err)
+					}
+
+					if task.Title == "d" {
+						tasks.SoftDelete(ctx, 0, 1, task.ID)
+					}
+				}
+
+				got, err := projects.ProjectById(ctx, 1, first.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got.Name != "first" || got.Color != "#ff0000" || got.Counts["pending"] != 2 || got.Counts["completed"] != 1 || got.Counts["in progress"] != 0 {
+					t.Fatalf("ProjectById = %+v", got)
+				}
+
+				if _, err := projects.ProjectById(ctx, 2, first.ID); !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("ProjectById of another user's project = %v, want ErrNoRecord", err)
+				}
+
+				listed, err := projects.ProjectListing(ctx, 1, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(listed) != 2 || listed[0].ID != first.ID || listed[1].ID != second.ID || listed[1].Counts["pending"] != 0 {
+					t.Fatalf("ProjectListing = %+v, want first and second by position", listed)
+				}
+
+				if tasks, _ := tasks.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, ProjectID: first.ID}); len(tasks) != 3 {
+					t.Fatalf("TaskListing of the project has %d tasks, want 3", len(tasks))
+				}
+			})
+
+			t.Run("tasks only join projects of their user", func(t *testing.T) {
+				tasks, projects := setup(t)
+				other := &Project{UserID: 2, Name: "other"}
+				projects.CreateProject(ctx, other)
+				if err := tasks.CreateTask(ctx, inProject(newTask(1, "x", "pending"), other.ID)); !errors.Is(err, pkg.ErrUnknownProject) {
+					t.Fatalf("CreateTask in another user's project = %v, want ErrUnknownProject", err)
+				}
+
+				task := newTask(1, "x", "pending")
+				tasks.CreateTask(ctx, task)
+				if err := tasks.UpdateTask(ctx, int(task.ID), inProject(newTask(1, "x", "pending"), other.ID+1000)); !errors.Is(err, pkg.ErrUnknownProject) {
+					t.Fatalf("UpdateTask into a missing project = %v, want ErrUnknownProject", err)
+				}
+			})
+
+			t.Run("archiving hides tasks", func(t *testing.T) {
+				tasks, projects := setup(t)
+				project := &Project{UserID: 1, Name: "old"}
+				projects.CreateProject(ctx, project)
+				loose, filed := newTask(1, "loose", "pending"), inProject(newTask(1, "filed", "pending"), project.ID)
+				tasks.CreateTask(ctx, loose)
+				tasks.CreateTask(ctx, filed)
+
+				if err := projects.UpdateProject(ctx, project.ID, &Project{UserID: 2, Name: "stolen", Archived: true}); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("UpdateProject by another user = %v, want ErrInvalidUserFound", err)
+				}
+
+				if err := projects.UpdateProject(ctx, project.ID, &Project{UserID: 1, Name: "old", Archived: true}); err != nil {
+					t.Fatal(err)
+				}
+
+				if listed, _ := tasks.TaskListing(ctx, all); !sameIDs(listed, []uint{loose.ID}) {
+					t.Fatalf("TaskListing = %v, want only the task outside the archived project", taskIDs(listed))
+				}
+
+				if listed, _ := tasks.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, ProjectID: project.ID}); !sameIDs(listed, []uint{filed.ID}) {
+					t.Fatalf("TaskListing of the archived project = %v, want its task", taskIDs(listed))
+				}
+
+				if listed, _ := projects.ProjectListing(ctx, 1, false); len(listed) != 0 {
+					t.Fatalf("ProjectListing without archived = %+v", listed)
+				}
+
+				if listed, _ := projects.ProjectListing(ctx, 1, true); len(listed) != 1 || !listed[0].Archived {
+					t.Fatalf("ProjectListing with archived = %+v", listed)
+				}
+			})
+
+			t.Run("delete moving tasks", func(t *testing.T) {
+				tasks, projects := setup(t)
+				from, to, other := &Project{UserID: 1, Name: "from"}, &Project{UserID: 1, Name: "to"}, &Project{UserID: 2, Name: "other"}
+				for _, project := range []*Project{from, to, other} {
+					projects.CreateProject(ctx, project)
+				}
+
+				task := inProject(newTask(1, "moving", "pending"), from.ID)
+				tasks.CreateTask(ctx, task)
+
+				for _, moveTo := range []uint{from.ID, other.ID} {
+					if err := projects.DeleteProject(ctx, 1, from.ID, ProjectTasks{MoveTo: &moveTo}); !errors.Is(err, pkg.ErrUnknownProject) {
+						t.Fatalf("DeleteProject moving to %d = %v, want ErrUnknownProject", moveTo, err)
+					}
+				}
+
+				if err := projects.DeleteProject(ctx, 2, from.ID, ProjectTasks{}); !errors.Is(err, pkg.ErrInvalidUserFound) {
+					t.Fatalf("DeleteProject by another user = %v, want ErrInvalidUserFound", err)
+				}
+
+				if err := projects.DeleteProject(ctx, 1, from.ID, ProjectTasks{MoveTo: &to.ID}); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := projects.ProjectById(ctx, 1, from.ID); !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("ProjectById after DeleteProject = %v, want ErrNoRecord", err)
+				}
+
+				if got, _ := tasks.TaskById(ctx, 0, int(task.ID)); got == nil || got.ProjectID == nil || *got.ProjectID != to.ID {
+					t.Fatalf("task after DeleteProject = %+v, want it in %d", got, to.ID)
+				}
+
+				if err := projects.DeleteProject(ctx, 1, to.ID, ProjectTasks{}); err != nil {
+					t.Fatal(err)
+				}
+
+				if got, _ := tasks.TaskById(ctx, 0, int(task.ID)); got == nil || got.ProjectID != nil {
+					t.Fatalf("task after DeleteProject without a target = %+v, want it in no project", got)
+				}
+			})
+
+			t.Run("delete with tasks", func(t *testing.T) {
+				tasks, projects := setup(t)
+				project := &Project{UserID: 1, Name: "doomed"}
+				projects.CreateProject(ctx, project)
+				doomed, kept := inProject(newTask(1, "doomed", "pending"), project.ID), newTask(1, "kept", "pending")
+				tasks.CreateTask(ctx, doomed)
+				tasks.CreateTask(ctx, kept)
+
+				if err := projects.DeleteProject(ctx, 1, project.ID, ProjectTasks{Delete: true}); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := tasks.TaskById(ctx, 0, int(doomed.ID)); !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("TaskById of a task of the deleted project = %v, want ErrNoRecord", err)
+				}
+
+				if listed, _ := tasks.TaskListing(ctx, all); !sameIDs(listed, []uint{kept.ID}) {
+					t.Fatalf("TaskListing = %v, want %v", taskIDs(listed), kept.ID)
+				}
+			})
+
+			t.Run("validation", func(t *testing.T) {
+				_, projects := setup(t)
+				v := projects.ValidateProjectData(&Project{Name: " ", Color: "red", Position: -1})
+				for _, field := range []string{"name", "color", "position"} {
+					if v.Errors[field] == "" {
+						t.Errorf("no error for %s in %v", field, v.Errors)
+					}
+				}
+
+				if v := projects.ValidateProjectData(&Project{Name: "fine", Color: "#A0b1C2"}); len(v.Errors) != 0 {
+					t.Fatalf("errors for a valid project: %v", v.Errors)
+				}
+			})
+		})
+	}
+}
+
+func inWorkspaceOf(task *Task, workspaceID uint) *Task {
+	task.WorkspaceID = &workspaceID
+	return task
+}
+
+func TestTaskWorkspaceScopeConformance(t *testing.T) {
+	for name, open := range taskRepositories() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := open(t)
+			personal := newTask(1, "personal", "pending")
+			shared := inWorkspaceOf(newTask(1, "shared", "pending"), 7)
+			other := inWorkspaceOf(newTask(2, "other", "pending"), 8)
+			for _, task := range []*Task{personal, shared, other} {
+				if err := repo.CreateTask(ctx, task); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got, err := repo.TaskById(ctx, 7, int(shared.ID)); err != nil || got.WorkspaceID == nil || *got.WorkspaceID != 7 {
+				t.Fatalf("TaskById in its workspace = %+v, %v", got, err)
+			}
+
+			if _, err := repo.TaskById(ctx, 0, int(shared.ID)); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("TaskById of a workspace task as personal = %v, want ErrNoRecord", err)
+			}
+
+			if _, err := repo.TaskById(ctx, 7, int(personal.ID)); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("TaskById of a personal task in a workspace = %v, want ErrNoRecord", err)
+			}
+
+			for workspaceID, want := range map[uint][]uint{0: {personal.ID}, 7: {shared.ID}, 8: {other.ID}} {
+				tasks, err := repo.TaskListing(ctx, &Filters{CurrPage: 1, PageSize: 10, WorkspaceID: workspaceID})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !sameIDs(tasks, want) {
+					t.Fatalf("TaskListing of workspace %d = %v, want %v", workspaceID, taskIDs(tasks), want)
+				}
+			}
+
+			// in a workspace it is the membership that counts, not who created it
+			if err := repo.UpdateTask(ctx, int(shared.ID), inWorkspaceOf(newTask(2, "edited", "pending"), 7)); err != nil {
+				t.Fatalf("UpdateTask by another member = %v", err)
+			}
+
+			if err := repo.UpdateTask(ctx, int(shared.ID), newTask(1, "moved", "pending")); !errors.Is(err, pkg.ErrInvalidUserFound) {
+				t.Fatalf("UpdateTask of a workspace task as personal = %v, want ErrInvalidUserFound", err)
+			}
+
+			if err := repo.UpdateTask(ctx, int(shared.ID), inWorkspaceOf(newTask(1, "moved", "pending"), 8)); !errors.Is(err, pkg.ErrInvalidUserFound) {
+				t.Fatalf("UpdateTask from another workspace = %v, want ErrInvalidUserFound", err)
+			}
+
+			if got, _ := repo.TaskById(ctx, 7, int(shared.ID)); got == nil || got.Title != "edited" || got.UserID != 1 {
+				t.Fatalf("TaskById after the updates = %+v, want the edit of the member", got)
+			}
+
+			if err := repo.SoftDelete(ctx, 8, 1, shared.ID); !errors.Is(err, pkg.ErrInvalidUserFound) {
+				t.Fatalf("SoftDelete from another workspace = %v, want ErrInvalidUserFound", err)
+			}
+
+			if err := repo.SoftDelete(ctx, 0, 1, shared.ID); !errors.Is(err, pkg.ErrInvalidUserFound) {
+				t.Fatalf("SoftDelete of a workspace task as personal = %v, want ErrInvalidUserFound", err)
+			}
+
+			if err := repo.SoftDelete(ctx, 7, 2, shared.ID); err != nil {
+				t.Fatalf("SoftDelete by another member = %v", err)
+			}
+
+			projects := repo.(ProjectRepository)
+			project := &Project{UserID: 1, Name: "mine"}
+			projects.CreateProject(ctx, project)
+			if err := repo.CreateTask(ctx, inProject(inWorkspaceOf(newTask(1, "filed", "pending"), 7), project.ID)); !errors.Is(err, pkg.ErrUnknownProject) {
+				t.Fatalf("CreateTask of a workspace task in a project = %v, want ErrUnknownProject", err)
+			}
+		})
+	}
+}
+
+// mailbox is a Mailer that hands the mails over to the test.
+type mailbox chan [2]string
+
+func (m mailbox) Send(ctx context.Context, to, subject, body string) error {
+	m <- [2]string{to, body}
+	return nil
+}
+
+var invitationLink = regexp.MustCompile(`/v1/invitations/([0-9a-f]+)/accept`)
+
+// invitation waits for the mail of an invitation and returns who it went
+// to and its token.
+func (m mailbox) invitation(t *testing.T) (string, string) {
+	t.Helper()
+	select {
+	case mail := <-m:
+		match := invitationLink.FindStringSubmatch(mail[1])
+		if match == nil {
+			t.Fatalf("no invitation link in %q", mail[1])
+		}
+
+		return mail[0], match[1]
+	case <-time.After(time.Second):
+		t.Fatal("no invitation mail was sent")
+		return "", ""
+	}
+}
+
+func workspaceRepositories() map[string]func(t *testing.T, mailer Mailer) (WorkspaceRepository, UserRepository) {
+	return map[string]func(t *testing.T, mailer Mailer) (WorkspaceRepository, UserRepository){
+		"memory": func(t *testing.T, mailer Mailer) (WorkspaceRepository, UserRepository) {
+			users := NewMemoryUserRepository(time.Hour)
+			return NewMemoryWorkspaceRepository(users, mailer, "http://tasks.test", logrus.New()), users
+		},
+		"gorm": func(t *testing.T, mailer Mailer) (WorkspaceRepository, UserRepository) {
+			db := testDB(t)
+			return &WorkspaceModelORM{db: db, logger: logrus.New(), mailer: mailer, appURL: "http://tasks.test"},
+				&UserModelORM{db: db, logger: logrus.New()}
+		},
+	}
+}
+
+func TestWorkspaceRepositoryConformance(t *testing.T) {
+	for name, open := range workspaceRepositories() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			mails := make(mailbox, 1)
+			repo, users := open(t, mails)
+			ids := map[string]uint{}
+			for _, email := range []string{"ada@example.com", "bob@example.com", "eve@example.com"} {
+				if err := users.RegisterUser(ctx, email, "Correct-horse-1", "127.0.0.1"); err != nil {
+					t.Fatal(err)
+				}
+
+				user, err := users.UserByEmail(ctx, email)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ids[email] = user.ID
+			}
+
+			ada, bob, eve := ids["ada@example.com"], ids["bob@example.com"], ids["eve@example.com"]
+			workspace := &Workspace{Name: "team"}
+			if err := repo.CreateWorkspace(ctx, workspace, ada); err != nil {
+				t.Fatal(err)
+			}
+
+			if workspace.ID == 0 || workspace.Role != WorkspaceOwner {
+				t.Fatalf("CreateWorkspace = %+v, want an ID and the owner role", workspace)
+			}
+
+			if listed, _ := repo.WorkspaceListing(ctx, ada); len(listed) != 1 || listed[0].Role != WorkspaceOwner {
+				t.Fatalf("WorkspaceListing of the owner = %+v", listed)
+			}
+
+			if listed, _ := repo.WorkspaceListing(ctx, bob); len(listed) != 0 {
+				t.Fatalf("WorkspaceListing of a stranger = %+v, want none", listed)
+			}
+
+			if _, err := repo.Member(ctx, workspace.ID, bob); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("Member of a stranger = %v, want ErrNoRecord", err)
+			}
+
+			if err := repo.Invite(ctx, &WorkspaceInvitation{WorkspaceID: workspace.ID, Email: " Bob@Example.com ", Role: WorkspaceEditor, InvitedBy: ada}); err != nil {
+				t.Fatal(err)
+			}
+
+			to, token := mails.invitation(t)
+			if to != "bob@example.com" {
+				t.Fatalf("invitation mailed to %q", to)
+			}
+
+			if err := repo.Invite(ctx, &WorkspaceInvitation{WorkspaceID: workspace.ID, Email: "ada@example.com", Role: WorkspaceViewer, InvitedBy: ada}); !errors.Is(err, pkg.ErrAlreadyMember) {
+				t.Fatalf("inviting a member = %v, want ErrAlreadyMember", err)
+			}
+
+			if _, err := repo.AcceptInvitation(ctx, token, eve, "eve@example.com"); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("accepting the invitation of somebody else = %v, want ErrNoRecord", err)
+			}
+
+			if _, err := repo.AcceptInvitation(ctx, "not-a-token", bob, "bob@example.com"); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("accepting with a bad token = %v, want ErrNoRecord", err)
+			}
+
+			member, err := repo.AcceptInvitation(ctx, token, bob, "bob@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if member.UserID != bob || member.Role != WorkspaceEditor {
+				t.Fatalf("AcceptInvitation = %+v, want bob as editor", member)
+			}
+
+			if _, err := repo.AcceptInvitation(ctx, token, bob, "bob@example.com"); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("reusing the invitation = %v, want ErrNoRecord", err)
+			}
+
+			got, err := repo.WorkspaceById(ctx, workspace.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Members) != 2 || got.Members[0].Email != "ada@example.com" || got.Members[1].Email != "bob@example.com" || got.Members[1].Role != WorkspaceEditor {
+				t.Fatalf("members = %+v", got.Members)
+			}
+
+			if err := repo.RemoveMember(ctx, workspace.ID, ada); !errors.Is(err, pkg.ErrLastOwner) {
+				t.Fatalf("removing the last owner = %v, want ErrLastOwner", err)
+			}
+
+			if err := repo.SetMemberRole(ctx, workspace.ID, ada, WorkspaceViewer); !errors.Is(err, pkg.ErrLastOwner) {
+				t.Fatalf("demoting the last owner = %v, want ErrLastOwner", err)
+			}
+
+			if err := repo.SetMemberRole(ctx, workspace.ID, bob, WorkspaceOwner); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.SetMemberRole(ctx, workspace.ID, ada, WorkspaceViewer); err != nil {
+				t.Fatalf("demoting one of two owners = %v", err)
+			}
+
+			if err := repo.RemoveMember(ctx, workspace.ID, ada); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.Member(ctx, workspace.ID, ada); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("Member after RemoveMember = %v, want ErrNoRecord", err)
+			}
+
+			if err := repo.SetMemberRole(ctx, workspace.ID, eve, WorkspaceEditor); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("SetMemberRole of a stranger = %v, want ErrNoRecord", err)
+			}
+
+			if workspaceIDs, _ := repo.WorkspaceIDs(ctx); len(workspaceIDs) != 1 || workspaceIDs[0] != workspace.ID {
+				t.Fatalf("WorkspaceIDs = %v", workspaceIDs)
+			}
+
+			if _, err := repo.WorkspaceById(ctx, workspace.ID+1000); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("WorkspaceById of a missing workspace = %v, want ErrNoRecord", err)
+			}
+		})
+	}
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	if !WorkspaceRoleAllows(WorkspaceOwner, WorkspaceEditor) || !WorkspaceRoleAllows(WorkspaceEditor, WorkspaceEditor) {
+		t.Fatal("a role doesn't allow what the roles below it may do")
+	}
+
+	if WorkspaceRoleAllows(WorkspaceViewer, WorkspaceEditor) || WorkspaceRoleAllows("", WorkspaceViewer) {
+		t.Fatal("a role allows more than it may do")
+	}
+}
+
+func TestTaskStatusConstraint(t *testing.T) {
+	db := testDB(t)
+	if err := db.Create(newTask(1, "odd", "someday")).Error; err == nil {
+		t.Fatal("the database accepted a task with an unknown status")
+	}
+}
+
+func taskIDs(tasks []*Task) []uint {
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+
+	return ids
+}
+
+func sameIDs(tasks []*Task, want []uint) bool {
+	got := taskIDs(tasks)
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestUserRepositoryConformance(t *testing.T) {
+	for name, open := range userRepositories() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := open(t)
+			creds := &UserStruct{Email: "ada@example.com", Passw: "Correct-horse-1", RepeatPassw: "Correct-horse-1"}
+
+			if v := repo.ValidateUserData(creds, true); !v.Valid() {
+				t.Fatalf("valid registration rejected: %v", v.Errors)
+			}
+
+			if err := repo.RegisterUser(ctx, creds.Email, creds.Passw, "127.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if v := repo.ValidateUserData(creds, true); v.Errors["email"] == "" {
+				t.Fatal("registering a taken email passed validation")
+			}
+
+			if err := repo.RegisterUser(ctx, creds.Email, creds.Passw, "127.0.0.1"); err == nil {
+				t.Fatal("registering a taken email succeeded")
+			}
+
+			user, err := repo.UserByEmail(ctx, creds.Email)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if user.Active || user.ActivationToken == "" {
+				t.Fatalf("new user = %+v, want inactive with an activation token", user)
+			}
+
+			if _, err := repo.UserByEmail(ctx, "nobody@example.com"); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("UserByEmail of a stranger = %v, want ErrNoRecord", err)
+			}
+
+			if _, _, err := repo.LoginUser(ctx, creds, "127.0.0.1"); !errors.Is(err, pkg.ErrAccountInActive) {
+				t.Fatalf("LoginUser before activation = %v, want ErrAccountInActive", err)
+			}
+
+			if err := repo.ActivateAccount("not-a-token"); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("ActivateAccount with a bad token = %v, want ErrNoRecord", err)
+			}
+
+			if err := repo.ActivateAccount(user.ActivationToken); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.ActivateAccount(user.ActivationToken); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("reusing the activation token = %v, want ErrNoRecord", err)
+			}
+
+			wrong := &UserStruct{Email: creds.Email, Passw: "Wrong-password-1"}
+			if _, _, err := repo.LoginUser(ctx, wrong, "127.0.0.1"); !errors.Is(err, pkg.ErrInvalidCredentials) {
+				t.Fatalf("LoginUser with a wrong password = %v, want ErrInvalidCredentials", err)
+			}
+
+			stranger := &UserStruct{Email: "nobody@example.com", Passw: creds.Passw}
+			if _, _, err := repo.LoginUser(ctx, stranger, "127.0.0.1"); !errors.Is(err, pkg.ErrInvalidCredentials) {
+				t.Fatalf("LoginUser of a stranger = %v, want ErrInvalidCredentials", err)
+			}
+
+			token, mfa, err := repo.LoginUser(ctx, creds, "127.0.0.1")
+			if err != nil || mfa || token == "" {
+				t.Fatalf("LoginUser = %q, %v, %v", token, mfa, err)
+			}
+
+			claims, err := repo.ParseToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.UserID != user.ID || claims.Email != creds.Email || !claims.IsSession() {
+				t.Fatalf("claims = %+v", claims)
+			}
+
+			if _, err := repo.ParseToken(token + "x"); err == nil {
+				t.Fatal("ParseToken accepted a tampered token")
+			}
+
+			if err := repo.UserActivityLog(&UserActivityLog{UserID: user.ID, Activity: "Logged Out"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if user.Role != RoleUser {
+				t.Fatalf("new user has role %q, want %q", user.Role, RoleUser)
+			}
+
+			if err := repo.SetRole(ctx, user.ID, RoleAdmin); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.SetRole(ctx, user.ID, "root"); err == nil {
+				t.Fatal("SetRole accepted an unknown role")
+			}
+
+			if err := repo.SetPassword(ctx, user.ID, "Another-pass-2"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.ParseToken(token); !errors.Is(err, pkg.ErrInvalidToken) {
+				t.Fatalf("ParseToken of a session from before the new password = %v, want ErrInvalidToken", err)
+			}
+
+			if _, _, err := repo.LoginUser(ctx, creds, "127.0.0.1"); !errors.Is(err, pkg.ErrInvalidCredentials) {
+				t.Fatalf("LoginUser with the old password = %v, want ErrInvalidCredentials", err)
+			}
+
+			changed := &UserStruct{Email: creds.Email, Passw: "Another-pass-2"}
+			if token, _, err = repo.LoginUser(ctx, changed, "127.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.SetActive(ctx, user.ID, false); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.ParseToken(token); !errors.Is(err, pkg.ErrInvalidToken) {
+				t.Fatalf("ParseToken of a session from before the deactivation = %v, want ErrInvalidToken", err)
+			}
+
+			if _, _, err := repo.LoginUser(ctx, changed, "127.0.0.1"); !errors.Is(err, pkg.ErrAccountInActive) {
+				t.Fatalf("LoginUser after deactivation = %v, want ErrAccountInActive", err)
+			}
+
+			if err := repo.SetActive(ctx, user.ID, true); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := repo.LoginUser(ctx, changed, "127.0.0.1"); err != nil {
+				t.Fatalf("LoginUser with the new password = %v", err)
+			}
+
+			if user, err = repo.UserByEmail(ctx, creds.Email); err != nil || user.Role != RoleAdmin {
+				t.Fatalf("UserByEmail = %+v, %v, want an admin", user, err)
+			}
+
+			for _, err := range []error{
+				repo.SetActive(ctx, 999, true),
+				repo.SetPassword(ctx, 999, "Another-pass-2"),
+				repo.SetRole(ctx, 999, RoleAdmin),
+			} {
+				if !errors.Is(err, pkg.ErrNoRecord) {
+					t.Fatalf("changing an unknown user = %v, want ErrNoRecord", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package models
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// a new key is published this long before it starts signing, so services
+	// caching the JWKS know it by the time they see its first token
+	keyPublishAhead = time.Hour
+	// private keys encrypted with JWT_KEY_ENCRYPTION_KEY start with this,
+	// the others are PEM from before it was set
+	sealedKeyPrefix = "sealed:"
+)
+
+// SigningKey is one JWT signing key. Keys live in the database so every
+// replica signs with, and publishes, the same set.
+type SigningKey struct {
+	ID          uint      `gorm:"primaryKey"`
+	Kid         string    `gorm:"uniqueIndex;size:64;not null"`
+	Algorithm   string    `gorm:"size:16;not null"`
+	PrivateKey  string    `gorm:"type:text;not null"`
+	PublicKey   string    `gorm:"type:text;not null"`
+	ActivatesAt time.Time `gorm:"index;not null"`
+	RetiresAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	CreatedAt   *time.Time
+}
+
+type signingKey struct {
+	SigningKey
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// TokenSigner signs and verifies JWTs with RS256 or EdDSA keys identified
+// by kid, and rotates them on a schedule with an overlap on both ends.
+type TokenSigner struct {
+	db          *gorm.DB
+	logger      *logrus.Logger
+	algorithm   string
+	issuer      string
+	audience    string
+	rotateEvery time.Duration
+	// a retired key keeps verifying until the last token it signed expired
+	verifyAfter time.Duration
+	// encryptionKey is the base64 AES-256 key private keys are stored
+	// encrypted with, empty stores them as plain PEM
+	encryptionKey string
+	mu            sync.RWMutex
+	keys          []*signingKey
+	reloadedAt    time.Time
+}
+
+func NewTokenSigner(db *gorm.DB, logger *logrus.Logger, cfg config.JWTConfig) *TokenSigner {
+	return &TokenSigner{
+		db:            db,
+		logger:        logger,
+		algorithm:     cfg.Algorithm,
+		issuer:        cfg.Issuer,
+		audience:      cfg.Audience,
+		rotateEvery:   cfg.KeyRotation,
+		verifyAfter:   cfg.SessionTTL + time.Minute,
+		encryptionKey: cfg.KeyEncryptionKey,
+	}
+}
+
+// Run rotates keys every interval until ctx is done.
+func (s *TokenSigner) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Rotate(ctx); err != nil {
+				s.logger.Error("Signing key rotation failed: ", err)
+			}
+		}
+	}
+}
+
+// Rotate drops expired keys, creates the successor of the current key once
+// it is due to be published and reloads the key set.
+func (s *TokenSigner) Rotate(ctx context.Context) error {
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&SigningKey{}).Error; err != nil {
+		return err
+	}
+
+	var latest SigningKey
+	err := s.db.WithContext(ctx).Order("activates_at DESC").First(&latest).Error
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		err = s.createKey(ctx, now)
+	case err != nil:
+		return err
+	case !now.Before(latest.RetiresAt.Add(-keyPublishAhead)):
+		activatesAt := latest.RetiresAt
+		if activatesAt.Before(now) {
+			activatesAt = now
+		}
+
+		err = s.createKey(ctx, activatesAt)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.reload(ctx)
+}
+
+func (s *TokenSigner) createKey(ctx context.Context, activatesAt time.Time) error {
+	var private crypto.Signer
+	var err error
+	switch s.algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q", s.algorithm)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return err
+	}
+
+	kid, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+
+	privatePEM, err := s.seal(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	if err != nil {
+		return err
+	}
+
+	key := SigningKey{
+		Kid:         kid,
+		Algorithm:   s.algorithm,
+		PrivateKey:  privatePEM,
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		ActivatesAt: activatesAt,
+		RetiresAt:   activatesAt.Add(s.rotateEvery),
+		ExpiresAt:   activatesAt.Add(s.rotateEvery + s.verifyAfter),
+	}
+
+	s.logger.Info("Created signing key ", kid, " active from ", activatesAt.Format(time.RFC3339))
+	return s.db.WithContext(ctx).Create(&key).Error
+}
+
+func (s *TokenSigner) reload(ctx context.Context) error {
+	var rows []SigningKey
+	if err := s.db.WithContext(ctx).Where("expires_at >= ?", time.Now()).Order("activates_at DESC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	keys := make([]*signingKey, 0, len(rows))
+	for _, row := range rows {
+		key, err := s.parseSigningKey(row)
+		if err != nil {
+			s.logger.Error("Skipping unreadable signing key ", row.Kid, ": ", err)
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.After(keys[j].ActivatesAt) })
+	s.mu.Lock()
+	s.keys = keys
+	s.reloadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// aead is the cipher private keys are encrypted with.
+func (s *TokenSigner) aead() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(s.encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_KEY_ENCRYPTION_KEY: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_KEY_ENCRYPTION_KEY: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the private key of kid for storage, the kid is
+// authenticated with it so rows can't be swapped.
+func (s *TokenSigner) seal(kid string, privatePEM []byte) (string, error) {
+	if s.encryptionKey == "" {
+		return string(privatePEM), nil
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, privatePEM, []byte(kid))), nil
+}
+
+// open is the PEM private key of a stored row.
+func (s *TokenSigner) open(row SigningKey) ([]byte, error) {
+	sealed, ok := strings.CutPrefix(row.PrivateKey, sealedKeyPrefix)
+	if !ok {
+		return []byte(row.PrivateKey), nil
+	}
+
+	if s.encryptionKey == "" {
+		return nil, errors.New("key is encrypted and JWT_KEY_ENCRYPTION_KEY is not set")
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted key")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(row.Kid))
+}
+
+func (s *TokenSigner) parseSigningKey(row SigningKey) (*signingKey, error) {
+	privatePEM, err := s.open(row)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a signing key", parsed)
+	}
+
+	method := jwt.GetSigningMethod(row.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", row.Algorithm)
+	}
+
+	return &signingKey{SigningKey: row, method: method, private: private, public: private.Public()}, nil
+}
+
+// current is the newest key that has started signing and not retired yet.
+// Retired keys only verify.
+func (s *TokenSigner) current() *signingKey {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if !key.ActivatesAt.After(now) && now.Before(key.RetiresAt) {
+			return key
+		}
+	}
+
+	return nil
+}
+
+func (s *TokenSigner) lookup(kid string) *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// reloadDue limits the reloads unknown kids can trigger, so garbage tokens
+// don't turn into database queries.
+func (s *TokenSigner) reloadDue() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Since(s.reloadedAt) > 30*time.Second
+}
+
+func (s *TokenSigner) Sign(claims *MyCustomClaims) (string, error) {
+	key := s.current()
+	if key == nil {
+		// rotation fell behind, catch up instead of signing with a retired key
+		if err := s.Rotate(context.Background()); err != nil {
+			s.logger.Error("Signing key rotation failed: ", err)
+		}
+
+		key = s.current()
+	}
+
+	if key == nil {
+		return "", pkg.ErrInternalServer
+	}
+
+	claims.Issuer = s.issuer
+	claims.Audience = s.audience
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.private)
+}
+
+func (s *TokenSigner) Parse(tokenString string) (*MyCustomClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key := s.lookup(kid)
+		if key == nil && s.reloadDue() {
+			// another replica may have rotated since we last looked
+			if err := s.reload(context.Background()); err != nil {
+				return nil, err
+			}
+
+			key = s.lookup(kid)
+		}
+
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("[error] Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return key.public, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*MyCustomClaims)
+	if !ok || !token.Valid || !claims.VerifyIssuer(s.issuer, true) || !claims.VerifyAudience(s.audience, true) {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// JWKS is the public half of every key that may still sign or verify, in
+// the RFC 7517 format served at /.well-known/jwks.json.
+func (s *TokenSigner) JWKS() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]map[string]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		jwk := map[string]string{"kid": key.Kid, "alg": key.Algorithm, "use": "sig"}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
@@ -0,0 +1,322 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func testEncryptionKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testSigner(t *testing.T, db *gorm.DB, cfg config.JWTConfig) *TokenSigner {
+	t.Helper()
+	base := config.JWTConfig{Algorithm: "EdDSA", Issuer: "go-task", Audience: "go-task", KeyRotation: 2 * time.Hour, SessionTTL: time.Hour}
+	if cfg.Algorithm != "" {
+		base.Algorithm = cfg.Algorithm
+	}
+
+	if cfg.Issuer != "" {
+		base.Issuer = cfg.Issuer
+	}
+
+	if cfg.Audience != "" {
+		base.Audience = cfg.Audience
+	}
+
+	base.KeyEncryptionKey = cfg.KeyEncryptionKey
+	return NewTokenSigner(db, logrus.New(), base)
+}
+
+func sign(t *testing.T, s *TokenSigner) (string, string) {
+	t.Helper()
+	claims := MyCustomClaims{Email: "someone@example.com", UserID: 1, StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+	token, err := s.Sign(&claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &MyCustomClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token, parsed.Header["kid"].(string)
+}
+
+// moveKey shifts the schedule of a stored key, as if it had been created
+// earlier.
+func moveKey(t *testing.T, db *gorm.DB, kid string, activatesAt, retiresAt, expiresAt time.Time) {
+	t.Helper()
+	err := db.Model(&SigningKey{}).Where("kid = ?", kid).
+		Updates(map[string]interface{}{"activates_at": activatesAt, "retires_at": retiresAt, "expires_at": expiresAt}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func jwksKids(s *TokenSigner) []string {
+	var kids []string
+	for _, key := range s.JWKS()["keys"].([]map[string]string) {
+		kids = append(kids, key["kid"])
+	}
+
+	return kids
+}
+
+func TestTokenSignerRotation(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	s := testSigner(t, db, config.JWTConfig{})
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	first, firstKid := sign(t, s)
+	if claims, err := s.Parse(first); err != nil || claims.UserID != 1 || claims.Issuer != "go-task" {
+		t.Fatalf("Parse = %+v, %v", claims, err)
+	}
+
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if kids := jwksKids(s); len(kids) != 1 {
+		t.Fatalf("JWKS before the successor is due = %v, want one key", kids)
+	}
+
+	// the successor is published ahead of the retirement, but doesn't sign yet
+	now := time.Now()
+	moveKey(t, db, firstKid, now.Add(-90*time.Minute), now.Add(30*time.Minute), now.Add(90*time.Minute))
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	kids := jwksKids(s)
+	if len(kids) != 2 {
+		t.Fatalf("JWKS within an hour of the retirement = %v, want two keys", kids)
+	}
+
+	if _, kid := sign(t, s); kid != firstKid {
+		t.Fatalf("signed with %s before the successor activated, want %s", kid, firstKid)
+	}
+
+	// once retired the old key only verifies
+	secondKid := kids[0]
+	moveKey(t, db, firstKid, now.Add(-2*time.Hour), now.Add(-time.Minute), now.Add(time.Hour))
+	moveKey(t, db, secondKid, now.Add(-time.Minute), now.Add(2*time.Hour), now.Add(3*time.Hour))
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, kid := sign(t, s); kid != secondKid {
+		t.Fatalf("signed with %s after the rotation, want %s", kid, secondKid)
+	}
+
+	if _, err := s.Parse(first); err != nil {
+		t.Fatalf("token of the retired key no longer verifies: %v", err)
+	}
+
+	// another replica, which only learns about keys from the database
+	replica := testSigner(t, db, config.JWTConfig{})
+	if _, err := replica.Parse(first); err != nil {
+		t.Fatalf("replica can't verify a token of the retired key: %v", err)
+	}
+
+	moveKey(t, db, firstKid, now.Add(-3*time.Hour), now.Add(-2*time.Hour), now.Add(-time.Minute))
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if kids := jwksKids(s); len(kids) != 1 || kids[0] != secondKid {
+		t.Fatalf("JWKS after the old key expired = %v, want [%s]", kids, secondKid)
+	}
+
+	if _, err := s.Parse(first); err == nil {
+		t.Fatal("token of an expired key still verifies")
+	}
+}
+
+func TestTokenSignerNeverSignsWithRetiredKeys(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	s := testSigner(t, db, config.JWTConfig{})
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, retiredKid := sign(t, s)
+	now := time.Now()
+	moveKey(t, db, retiredKid, now.Add(-3*time.Hour), now.Add(-time.Minute), now.Add(time.Hour))
+	if err := s.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, kid := sign(t, s); kid == retiredKid {
+		t.Fatal("signed with a retired key")
+	}
+}
+
+func TestTokenSignerJWKS(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm string
+		want      map[string]bool
+	}{
+		{"EdDSA", map[string]bool{"kty=OKP": true, "crv=Ed25519": true, "x": true}},
+		{"RS256", map[string]bool{"kty=RSA": true, "n": true, "e": true}},
+	} {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			s := testSigner(t, testDB(t), config.JWTConfig{Algorithm: tt.algorithm})
+			if err := s.Rotate(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			keys := s.JWKS()["keys"].([]map[string]string)
+			if len(keys) != 1 {
+				t.Fatalf("JWKS = %v, want one key", keys)
+			}
+
+			key := keys[0]
+			if key["alg"] != tt.algorithm || key["use"] != "sig" || key["kid"] == "" {
+				t.Fatalf("JWK = %v", key)
+			}
+
+			for field := range tt.want {
+				name, value, fixed := strings.Cut(field, "=")
+				if fixed && key[name] != value || !fixed && key[name] == "" {
+					t.Fatalf("JWK %v lacks %s", key, field)
+				}
+			}
+
+			if _, ok := key["d"]; ok {
+				t.Fatal("JWKS publishes the private key")
+			}
+		})
+	}
+}
+
+func TestTokenSignerRejects(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	s := testSigner(t, db, config.JWTConfig{})
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := sign(t, s)
+	for name, other := range map[string]*TokenSigner{
+		"issuer":   testSigner(t, db, config.JWTConfig{Issuer: "someone-else"}),
+		"audience": testSigner(t, db, config.JWTConfig{Audience: "someone-else"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := other.Parse(token); !errors.Is(err, pkg.ErrInvalidToken) {
+				t.Fatalf("Parse with another %s = %v, want ErrInvalidToken", name, err)
+			}
+		})
+	}
+
+	t.Run("unknown kid", func(t *testing.T) {
+		other := testSigner(t, testDB(t), config.JWTConfig{})
+		if err := other.Rotate(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		foreign, _ := sign(t, other)
+		if _, err := s.Parse(foreign); err == nil {
+			t.Fatal("token of a key we never had verifies")
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		claims := base64.RawURLEncoding.EncodeToString([]byte(`{"email":"admin@example.com","user_id":2,"iss":"go-task","aud":"go-task"}`))
+		if _, err := s.Parse(parts[0] + "." + claims + "." + parts[2]); err == nil {
+			t.Fatal("tampered token verifies")
+		}
+	})
+}
+
+func TestSigningKeysAreEncrypted(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	key := testEncryptionKey(t)
+	s := testSigner(t, db, config.JWTConfig{KeyEncryptionKey: key})
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var row SigningKey
+	if err := db.First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(row.PrivateKey, sealedKeyPrefix) || strings.Contains(row.PrivateKey, "PRIVATE KEY") {
+		t.Fatalf("private key stored as %.40q..., want it encrypted", row.PrivateKey)
+	}
+
+	token, _ := sign(t, s)
+	if _, err := testSigner(t, db, config.JWTConfig{KeyEncryptionKey: key}).Parse(token); err != nil {
+		t.Fatalf("replica with the same key can't verify: %v", err)
+	}
+
+	for name, other := range map[string]string{"without a key": "", "with another key": testEncryptionKey(t)} {
+		t.Run(name, func(t *testing.T) {
+			signer := testSigner(t, db, config.JWTConfig{KeyEncryptionKey: other})
+			if err := signer.reload(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if kids := jwksKids(signer); len(kids) != 0 {
+				t.Fatalf("loaded %v", kids)
+			}
+		})
+	}
+
+	t.Run("swapped rows", func(t *testing.T) {
+		if err := db.Create(&SigningKey{Kid: "copy", Algorithm: row.Algorithm, PrivateKey: row.PrivateKey, PublicKey: row.PublicKey,
+			ActivatesAt: row.ActivatesAt, RetiresAt: row.RetiresAt, ExpiresAt: row.ExpiresAt}).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.reload(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.lookup("copy") != nil {
+			t.Fatal("key moved to another kid still decrypts")
+		}
+	})
+
+	t.Run("plain keys from before", func(t *testing.T) {
+		db := testDB(t)
+		if err := testSigner(t, db, config.JWTConfig{}).Rotate(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		encrypting := testSigner(t, db, config.JWTConfig{KeyEncryptionKey: key})
+		if err := encrypting.reload(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if kids := jwksKids(encrypting); len(kids) != 1 {
+			t.Fatalf("plain key isn't loaded once encryption is configured, JWKS = %v", kids)
+		}
+	})
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
+	"github.com/iamgak/go-task/pkg"
+)
+
+// Task reads are cached under these keys. A task is cached by id and
+// dropped by id when it changes. Listings can't be found by the task they
+// contain, so their keys carry a generation instead: bumping it leaves the
+// old pages unreachable until their TTL runs out, no pattern delete needed.
+//
+// Each workspace has keys and a generation of its own, a write to one
+// leaves what the others cached alone. Personal tasks keep the keys they
+// had before there were workspaces.
+func cacheKeyPrefix(workspaceID uint) string {
+	if workspaceID == 0 {
+		return "tasks"
+	}
+
+	return fmt.Sprintf("tasks:ws:%d", workspaceID)
+}
+
+func taskCacheKey(workspaceID, id uint) string {
+	return fmt.Sprintf("%s:id:%d", cacheKeyPrefix(workspaceID), id)
+}
+
+func listingGenerationKey(workspaceID uint) string {
+	return cacheKeyPrefix(workspaceID) + ":gen:listing"
+}
+
+// listingCacheKey is listingCacheKey once failed invalidations went through.
+func (c *TaskModelORM) listingCacheKey(ctx context.Context, f *Filters) (string, error) {
+	if err := c.flushPending(ctx); err != nil {
+		return "", err
+	}
+
+	return listingCacheKey(ctx, c.cache, f)
+}
+
+// listingCacheKey is where the page f selects is cached in the current
+// generation of its workspace.
+func listingCacheKey(ctx context.Context, cache Cache, f *Filters) (string, error) {
+	generation, err := listingGeneration(ctx, cache, f.WorkspaceID)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:listing:%d:%s", cacheKeyPrefix(f.WorkspaceID), generation, f.cacheKey()), nil
+}
+
+func listingGeneration(ctx context.Context, cache Cache, workspaceID uint) (int64, error) {
+	value, err := cache.Get(ctx, listingGenerationKey(workspaceID))
+	if err == ErrCacheMiss {
+		return seedListingGeneration(ctx, cache, workspaceID)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// seedListingGeneration starts a lost counter from the clock, counting from
+// zero again could bring back pages cached before it was lost.
+func seedListingGeneration(ctx context.Context, cache Cache, workspaceID uint) (int64, error) {
+	generation := time.Now().UnixNano()
+	return generation, cache.Set(ctx, listingGenerationKey(workspaceID), []byte(strconv.FormatInt(generation, 10)), 0)
+}
+
+// InvalidateListings makes every cached task listing of the workspace, 0
+// for the personal tasks, stale. Task writes call it, so does the cache
+// flush admin command.
+func InvalidateListings(ctx context.Context, cache Cache, workspaceID uint) error {
+	generation, err := cache.Incr(ctx, listingGenerationKey(workspaceID))
+	if err == nil && generation == 1 {
+		_, err = seedListingGeneration(ctx, cache, workspaceID)
+	}
+
+	return err
+}
+
+// loadTimeout bounds a load shared by several readers, it runs detached
+// from the request that started it so that one giving up fails nobody else.
+const loadTimeout = 5 * time.Second
+
+// cacheEntry is what task reads store. The entry outlives FreshUntil by
+// CacheConfig.StaleTTL, in that window it is served while a fresh copy is
+// loaded. NotFound remembers a pkg.ErrNoRecord result.
+type cacheEntry struct {
+	Value      json.RawMessage `json:"value,omitempty"`
+	NotFound   bool            `json:"not_found,omitempty"`
+	FreshUntil time.Time       `json:"fresh_until"`
+	LoadTime   time.Duration   `json:"load_time"`
+}
+
+// refreshDue tells whether the entry should be loaded again. Besides stale
+// entries that is, by chance, one close to going stale: the slower it loads
+// and the larger beta, the earlier (probabilistic early expiration), so a hot
+// entry is refreshed by one reader before all of them miss it at once.
+func (e *cacheEntry) refreshDue(now time.Time, beta float64) bool {
+	if !now.Before(e.FreshUntil) {
+		return true
+	}
+
+	if beta <= 0 || e.LoadTime <= 0 {
+		return false
+	}
+
+	early := time.Duration(float64(e.LoadTime) * beta * -math.Log(1-rand.Float64()))
+	return !now.Add(early).Before(e.FreshUntil)
+}
+
+// readThrough returns what is cached at key, one of the workspace's, or
+// loads it. Concurrent misses of a key share one load, stale and nearly stale
+// entries are served while one background load refreshes them. kind labels
+// the metrics.
+func readThrough[T any](ctx context.Context, c *TaskModelORM, kind string, workspaceID uint, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	// a cache that can't be trusted or reached is left out, reads go to
+	// the database then
+	store := c.flushPending(ctx) == nil
+	reload := func(ctx context.Context) (interface{}, error) {
+		return loadEntry(ctx, c, workspaceID, key, ttl, load, store)
+	}
+
+	var cached []byte
+	err := ErrCacheUnavailable
+	if store {
+		cached, err = c.cache.Get(ctx, key)
+	}
+
+	if err != nil && err != ErrCacheMiss {
+		countCacheLookup(kind, "error")
+		store = false
+	}
+
+	var entry cacheEntry
+	if err == nil && json.Unmarshal(cached, &entry) == nil {
+		now := time.Now()
+		result := "hit"
+		if !now.Before(entry.FreshUntil) {
+			result = "stale"
+			c.refresh(ctx, kind, key, "stale", reload)
+		} else if entry.refreshDue(now, c.cacheTTL.EarlyRefreshBeta) {
+			c.refresh(ctx, kind, key, "early", reload)
+		}
+
+		if entry.NotFound {
+			countCacheLookup(kind, "negative")
+			return zero, pkg.ErrNoRecord
+		}
+
+		var value T
+		if err := json.Unmarshal(entry.Value, &value); err == nil {
+			countCacheLookup(kind, result)
+			return value, nil
+		}
+	}
+
+	// entries that don't decode, written by an older release, count as misses
+	if store {
+		countCacheLookup(kind, "miss")
+	}
+
+	ch := c.loads.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		metrics.CacheLoads.WithLabelValues(kind, "miss").Inc()
+		return reload(loadCtx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			metrics.CacheCoalesced.WithLabelValues(kind).Inc()
+		}
+
+		value, _ := res.Val.(T)
+		return value, res.Err
+	}
+}
+
+// loadEntry loads the value and, with store, caches it, a pkg.ErrNoRecord
+// for CacheConfig.NotFoundTTL. A value that couldn't be cached is still
+// returned.
+//
+// A task write landing while the value loads may have dropped key already,
+// storing the value then would bring back what it replaced. Every write bumps
+// the listing generation of its workspace, so the value is only stored if the
+// generation is the one from before the load.
+func loadEntry[T any](ctx context.Context, c *TaskModelORM, workspaceID uint, key string, ttl time.Duration, load func(ctx context.Context) (T, error), store bool) (T, error) {
+	var generation int64
+	if store {
+		var err error
+		generation, err = listingGeneration(ctx, c.cache, workspaceID)
+		store = err == nil
+	}
+
+	started := time.Now()
+	value, err := load(ctx)
+	entry := cacheEntry{LoadTime: time.Since(started)}
+	expiry := ttl + c.cacheTTL.StaleTTL
+	switch {
+	case errors.Is(err, pkg.ErrNoRecord):
+		entry.NotFound = true
+		expiry = c.cacheTTL.NotFoundTTL
+		entry.FreshUntil = time.Now().Add(expiry)
+	case err != nil:
+		return value, err
+	default:
+		entry.FreshUntil = time.Now().Add(ttl)
+		if entry.Value, err = json.Marshal(value); err != nil {
+			return value, err
+		}
+	}
+
+	if store {
+		current, err := listingGeneration(ctx, c.cache, workspaceID)
+		store = err == nil && current == generation
+	}
+
+	if store {
+		encoded, _ := json.Marshal(entry)
+		if err := c.cache.Set(ctx, key, encoded, expiry); err != nil && err != ErrCacheUnavailable {
+			logging.FromContext(ctx, c.logger).Warn("Caching ", key, " failed: ", err)
+		}
+	}
+
+	if entry.NotFound {
+		return value, pkg.ErrNoRecord
+	}
+
+	return value, nil
+}
+
+// refresh runs load in the background unless a refresh of key is running
+// already. It shares the load with readers missing the key meanwhile.
+func (c *TaskModelORM) refresh(ctx context.Context, kind, key, reason string, load func(ctx context.Context) (interface{}, error)) {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	metrics.CacheLoads.WithLabelValues(kind, reason).Inc()
+	go func() {
+		defer c.refreshing.Delete(key)
+		refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		_, err, _ := c.loads.Do(key, func() (interface{}, error) {
+			return load(refreshCtx)
+		})
+
+		if err != nil && !errors.Is(err, pkg.ErrNoRecord) {
+			logging.FromContext(refreshCtx, c.logger).Warn("Refreshing ", key, " failed: ", err)
+		}
+	}()
+}
+
+// invalidate drops what reads cached about the tasks of the workspace
+// after they changed, and its listings. A write isn't failed for the cache:
+// what couldn't be dropped is kept and retried before this process uses the
+// cache again.
+func (c *TaskModelORM) invalidate(ctx context.Context, workspaceID uint, taskIDs ...uint) {
+	c.pendingMu.Lock()
+	if c.pending == nil {
+		c.pending = make(map[string]struct{})
+		c.pendingListings = make(map[uint]struct{})
+	}
+
+	for _, taskID := range taskIDs {
+		c.pending[taskCacheKey(workspaceID, taskID)] = struct{}{}
+	}
+
+	c.pendingListings[workspaceID] = struct{}{}
+	c.hasPending.Store(true)
+	c.pendingMu.Unlock()
+
+	if err := c.flushPending(ctx); err != nil {
+		metrics.CacheInvalidationFailures.Inc()
+		if err != ErrCacheUnavailable {
+			logging.FromContext(ctx, c.logger).Warn("Invalidating the cache of tasks ", taskIDs, " failed, retrying later: ", err)
+		}
+	}
+}
+
+// flushPending runs the invalidations still to do. Until it succeeds the
+// cache may hold what they should have dropped and must not be read.
+func (c *TaskModelORM) flushPending(ctx context.Context) error {
+	if !c.hasPending.Load() {
+		return nil
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	// the generations go first, a load that sees the old one after the keys
+	// are gone could otherwise still store what they held, see loadEntry
+	for workspaceID := range c.pendingListings {
+		if err := InvalidateListings(ctx, c.cache, workspaceID); err != nil {
+			return err
+		}
+
+		delete(c.pendingListings, workspaceID)
+	}
+
+	keys := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		keys = append(keys, key)
+	}
+
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		return err
+	}
+
+	clear(c.pending)
+	c.hasPending.Store(false)
+	return nil
+}
@@ -0,0 +1,406 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingCache remembers which keys were stored, so a test can look the
+// exact keys of a read up again after a write.
+type recordingCache struct {
+	Cache
+	mu     sync.Mutex
+	stored []string
+}
+
+func (c *recordingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	c.stored = append(c.stored, key)
+	c.mu.Unlock()
+	return c.Cache.Set(ctx, key, value, ttl)
+}
+
+// lastStored is the last key stored with the prefix.
+func (c *recordingCache) lastStored(t *testing.T, prefix string) string {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.stored) - 1; i >= 0; i-- {
+		if strings.HasPrefix(c.stored[i], prefix) {
+			return c.stored[i]
+		}
+	}
+
+	t.Fatalf("nothing stored under %s", prefix)
+	return ""
+}
+
+func testCaches() map[string]func(t *testing.T) Cache {
+	return map[string]func(t *testing.T) Cache{
+		"memory": func(t *testing.T) Cache { return NewMemoryCache() },
+		"redis":  func(t *testing.T) Cache { return &RedisStruct{client: testRedis(t), logger: logrus.New()} },
+	}
+}
+
+func TestTaskWritesInvalidateReads(t *testing.T) {
+	for name, open := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := &recordingCache{Cache: open(t)}
+			repo := &TaskModelORM{db: testDB(t), cache: cache, logger: logrus.New(), cacheTTL: config.Default().Cache}
+			filters := &Filters{CurrPage: 1, PageSize: 10}
+
+			// read both ways, then check after each write that the keys those
+			// reads used are out of reach and that reading again sees it
+			readAll := func() (taskKey, listingKey string) {
+				t.Helper()
+				if _, err := repo.TaskById(ctx, 0, 1); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := repo.TaskListing(ctx, filters); err != nil {
+					t.Fatal(err)
+				}
+
+				return cache.lastStored(t, "tasks:id:"), cache.lastStored(t, "tasks:listing:")
+			}
+
+			// a task is dropped, a listing is left behind by its generation
+			assertStale := func(write, taskKey, listingKey string) {
+				t.Helper()
+				if _, err := cache.Get(ctx, taskKey); taskKey != "" && err == nil {
+					t.Fatalf("%s left %s cached", write, taskKey)
+				}
+
+				if current, _ := listingCacheKey(ctx, cache, filters); current == listingKey {
+					t.Fatalf("%s kept the listing key %s", write, listingKey)
+				}
+			}
+
+			task := newTask(1, "first", "pending")
+			if err := repo.CreateTask(ctx, task); err != nil {
+				t.Fatal(err)
+			}
+
+			_, listingKey := readAll()
+			if err := repo.CreateTask(ctx, newTask(1, "second", "pending")); err != nil {
+				t.Fatal(err)
+			}
+
+			assertStale("CreateTask", "", listingKey)
+			if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 2 {
+				t.Fatalf("listing after CreateTask has %d tasks, want 2", len(tasks))
+			}
+
+			taskKey, listingKey := readAll()
+			if err := repo.UpdateTask(ctx, int(task.ID), newTask(1, "renamed", "completed")); err != nil {
+				t.Fatal(err)
+			}
+
+			assertStale("UpdateTask", taskKey, listingKey)
+			if got, _ := repo.TaskById(ctx, 0, int(task.ID)); got == nil || got.Title != "renamed" {
+				t.Fatalf("TaskById after UpdateTask = %+v, want the new title", got)
+			}
+
+			if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 2 || tasks[1].Title != "renamed" {
+				t.Fatalf("listing after UpdateTask = %+v, want the new title", tasks)
+			}
+
+			taskKey, listingKey = readAll()
+			if err := repo.SoftDelete(ctx, 0, 1, task.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			assertStale("SoftDelete", taskKey, listingKey)
+			if _, err := repo.TaskById(ctx, 0, int(task.ID)); !errors.Is(err, pkg.ErrNoRecord) {
+				t.Fatalf("TaskById after SoftDelete = %v, want ErrNoRecord", err)
+			}
+
+			if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 1 {
+				t.Fatalf("listing after SoftDelete has %d tasks, want 1", len(tasks))
+			}
+		})
+	}
+}
+
+func TestProjectChangesInvalidateReads(t *testing.T) {
+	ctx := context.Background()
+	repo := &TaskModelORM{db: testDB(t), cache: NewMemoryCache(), logger: logrus.New(), cacheTTL: config.Default().Cache}
+	filters := &Filters{CurrPage: 1, PageSize: 10}
+	project, target := &Project{UserID: 1, Name: "project"}, &Project{UserID: 1, Name: "target"}
+	repo.CreateProject(ctx, project)
+	repo.CreateProject(ctx, target)
+	task := inProject(newTask(1, "filed", "pending"), project.ID)
+	repo.CreateTask(ctx, task)
+
+	if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 1 {
+		t.Fatalf("listing has %d tasks, want 1", len(tasks))
+	}
+
+	repo.TaskById(ctx, 0, int(task.ID))
+	if err := repo.UpdateProject(ctx, project.ID, &Project{UserID: 1, Name: "project", Archived: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 0 {
+		t.Fatal("the cached listing still shows the task of the archived project")
+	}
+
+	if err := repo.DeleteProject(ctx, 1, project.ID, ProjectTasks{MoveTo: &target.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := repo.TaskById(ctx, 0, int(task.ID)); got == nil || got.ProjectID == nil || *got.ProjectID != target.ID {
+		t.Fatalf("TaskById after moving = %+v, want it in %d", got, target.ID)
+	}
+
+	if tasks, _ := repo.TaskListing(ctx, filters); len(tasks) != 1 {
+		t.Fatal("the cached listing misses the task moved out of the archived project")
+	}
+}
+
+func TestWorkspaceCachesArePartitioned(t *testing.T) {
+	ctx := context.Background()
+	cache := &recordingCache{Cache: NewMemoryCache()}
+	repo := &TaskModelORM{db: testDB(t), cache: cache, logger: logrus.New(), cacheTTL: config.Default().Cache}
+	personal := &Filters{CurrPage: 1, PageSize: 10}
+	shared := &Filters{CurrPage: 1, PageSize: 10, WorkspaceID: 7}
+	task := inWorkspaceOf(newTask(1, "shared", "pending"), 7)
+	repo.CreateTask(ctx, task)
+
+	repo.TaskById(ctx, 7, int(task.ID))
+	if key := cache.lastStored(t, "tasks:"); key != taskCacheKey(7, task.ID) || !strings.HasPrefix(key, "tasks:ws:7:id:") {
+		t.Fatalf("task of workspace 7 cached under %s", key)
+	}
+
+	personalKey, _ := listingCacheKey(ctx, cache, personal)
+	sharedKey, _ := listingCacheKey(ctx, cache, shared)
+	if !strings.HasPrefix(sharedKey, "tasks:ws:7:listing:") || personalKey == sharedKey {
+		t.Fatalf("listing keys %s and %s aren't partitioned", personalKey, sharedKey)
+	}
+
+	if err := repo.UpdateTask(ctx, int(task.ID), inWorkspaceOf(newTask(2, "edited", "pending"), 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := listingCacheKey(ctx, cache, shared); current == sharedKey {
+		t.Fatal("a write to workspace 7 kept its listing key")
+	}
+
+	if current, _ := listingCacheKey(ctx, cache, personal); current != personalKey {
+		t.Fatal("a write to workspace 7 made the personal listings stale")
+	}
+
+	for key, kind := range map[string]string{sharedKey: "listing", taskCacheKey(7, 1): "id", listingGenerationKey(7): "gen", personalKey: "listing"} {
+		if got := cacheKeyKind(key); got != kind {
+			t.Fatalf("cacheKeyKind(%s) = %s, want %s", key, got, kind)
+		}
+	}
+}
+
+func TestListingGenerationSurvivesLoss(t *testing.T) {
+	for name, open := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cache := open(t)
+			filters := &Filters{CurrPage: 1, PageSize: 10}
+			before, err := listingCacheKey(ctx, cache, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cache.Set(ctx, before, []byte(`[]`), time.Minute)
+			cache.Delete(ctx, listingGenerationKey(0))
+			if err := InvalidateListings(ctx, cache, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			after, err := listingCacheKey(ctx, cache, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if after == before {
+				t.Fatalf("listing key %s was reused after the generation was lost", after)
+			}
+
+			if err := InvalidateListings(ctx, cache, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			if again, _ := listingCacheKey(ctx, cache, filters); again == after {
+				t.Fatal("InvalidateListings kept the listing key")
+			}
+		})
+	}
+}
+
+func TestConcurrentMissesShareOneLoad(t *testing.T) {
+	repo := &TaskModelORM{cache: NewMemoryCache(), logger: logrus.New(), cacheTTL: config.Default().Cache}
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (string, error) {
+		loads.Add(1)
+		<-release
+		return "loaded", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan string, 20)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := readThrough(context.Background(), repo, "test", 0, "tasks:id:1", time.Minute, load)
+			if err != nil {
+				t.Error(err)
+			}
+
+			results <- value
+		}()
+	}
+
+	// let the readers pile up behind the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	for value := range results {
+		if value != "loaded" {
+			t.Fatalf("a reader got %q", value)
+		}
+	}
+
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("%d loads for concurrent misses, want 1", n)
+	}
+}
+
+func TestNotFoundIsCached(t *testing.T) {
+	repo := &TaskModelORM{cache: NewMemoryCache(), logger: logrus.New(), cacheTTL: config.Default().Cache}
+	repo.cacheTTL.NotFoundTTL = 50 * time.Millisecond
+	loads := 0
+	load := func(ctx context.Context) (*Task, error) {
+		loads++
+		return nil, pkg.ErrNoRecord
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := readThrough(context.Background(), repo, "test", 0, "tasks:id:1", time.Minute, load); !errors.Is(err, pkg.ErrNoRecord) {
+			t.Fatalf("readThrough = %v, want ErrNoRecord", err)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("%d loads of a missing task, want 1", loads)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	readThrough(context.Background(), repo, "test", 0, "tasks:id:1", time.Minute, load)
+	if loads != 2 {
+		t.Fatal("the not found result outlived CACHE_NOT_FOUND_TTL")
+	}
+}
+
+func TestStaleEntriesAreServedWhileRefreshing(t *testing.T) {
+	repo := &TaskModelORM{cache: NewMemoryCache(), logger: logrus.New(), cacheTTL: config.Default().Cache}
+	repo.cacheTTL.EarlyRefreshBeta = 0
+	var version atomic.Int32
+	version.Store(1)
+	load := func(ctx context.Context) (int32, error) {
+		return version.Load(), nil
+	}
+
+	ctx := context.Background()
+	ttl := 20 * time.Millisecond
+	if v, _ := readThrough(ctx, repo, "test", 0, "tasks:id:1", ttl, load); v != 1 {
+		t.Fatalf("first read = %d, want 1", v)
+	}
+
+	version.Store(2)
+	time.Sleep(ttl)
+	if v, err := readThrough(ctx, repo, "test", 0, "tasks:id:1", ttl, load); err != nil || v != 1 {
+		t.Fatalf("stale read = %d, %v, want the stale 1", v, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := readThrough(ctx, repo, "test", 0, "tasks:id:1", ttl, load); v == 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the stale entry was never refreshed")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWritesDuringALoadAreNotUndone(t *testing.T) {
+	for name, open := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			repo := &TaskModelORM{cache: open(t), logger: logrus.New(), cacheTTL: config.Default().Cache}
+			loading, release := make(chan struct{}), make(chan struct{})
+			load := func(ctx context.Context) (string, error) {
+				close(loading)
+				<-release
+				return "before the write", nil
+			}
+
+			ctx := context.Background()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				readThrough(ctx, repo, "test", 0, taskCacheKey(0, 1), time.Minute, load)
+			}()
+
+			// the write lands after the load read the database, before it
+			// stores what it read
+			<-loading
+			repo.invalidate(ctx, 0, 1)
+			close(release)
+			<-done
+
+			value, err := readThrough(ctx, repo, "test", 0, taskCacheKey(0, 1), time.Minute, func(ctx context.Context) (string, error) {
+				return "after the write", nil
+			})
+
+			if err != nil || value != "after the write" {
+				t.Fatalf("read after the write = %q, %v, want what the write left", value, err)
+			}
+		})
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	now := time.Now()
+	entry := cacheEntry{FreshUntil: now.Add(time.Second), LoadTime: time.Millisecond}
+	if entry.refreshDue(now, 0) {
+		t.Fatal("refresh due with early refresh off")
+	}
+
+	if !entry.refreshDue(now.Add(time.Second), 0) {
+		t.Fatal("refresh not due for a stale entry")
+	}
+
+	// a slow load and a large beta pull the refresh far ahead
+	entry.LoadTime = time.Hour
+	if !entry.refreshDue(now, 1000) {
+		t.Fatal("refresh not due for an entry that loads slowly")
+	}
+
+	entry.LoadTime = time.Nanosecond
+	if entry.refreshDue(now, 1) {
+		t.Fatal("refresh due a second early for an entry that loads in a nanosecond")
+	}
+}
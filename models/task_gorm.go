@@ -2,112 +2,95 @@ package models
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
 	"github.com/iamgak/go-task/pkg"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
 type Task struct {
 	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
 	UserID      uint       `gorm:"index;not null" json:"-" binding:"-"`
-	Title       string     `gorm:"not null" json:"title,omitempty"` // Optional
+	ProjectID   *uint      `gorm:"index" json:"project_id"`
+	WorkspaceID *uint      `gorm:"index" json:"workspace_id" binding:"-"` // nil for personal tasks
+	Title       string     `gorm:"not null" json:"title,omitempty"`       // Optional
 	Description string     `gorm:"not null" json:"description"`
-	Status      string     `gorm:"type:enum('pending','in progress','completed');not null" json:"status"`
-	IsDeleted   bool       `gorm:"default:0" json:"-"`                   // Hidden from JSON (soft delete)
+	Status      string     `gorm:"size:20;not null;check:chk_tasks_status,status IN ('pending','in progress','completed')" json:"status"`
+	IsDeleted   bool       `gorm:"default:false" json:"-"`               // Hidden from JSON (soft delete)
 	DueAt       *time.Time `gorm:"default:null" json:"due_at,omitempty"` // Optional
 	Version     uint       `gorm:"default:1" json:"version"`
-	CreatedAt   *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()" json:"created_at,omitempty" binding:"-"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" binding:"-"`
 	UpdatedAt   *time.Time `gorm:"default:null" json:"updated_at,omitempty" binding:"-"` // Optional
 	DeletedAt   *time.Time `gorm:"default:null" json:"-" binding:"-"`                    // Hidden from JSON (soft delete)
+	// ClearDueAt makes UpdateTask drop the due date, a nil DueAt keeps it
+	ClearDueAt bool `gorm:"-" json:"-" binding:"-"`
 }
 
 type TaskModelORM struct {
-	db     *gorm.DB
-	logger *logrus.Logger
-	mute   sync.RWMutex
-	redis  RedisStruct
+	db       *gorm.DB
+	logger   *logrus.Logger
+	mute     sync.RWMutex
+	cache    Cache
+	cacheTTL config.CacheConfig
+	// loads coalesces the database reads of a cache key, refreshing holds
+	// the keys refreshed in the background
+	loads      singleflight.Group
+	refreshing sync.Map
+	// pending are invalidations that failed, see invalidate
+	pendingMu       sync.Mutex
+	pending         map[string]struct{}
+	pendingListings map[uint]struct{}
+	hasPending      atomic.Bool
 }
 
-func (c *TaskModelORM) TaskById(ctx context.Context, taskID int) (*Task, error) {
-	var task *Task
-	cacheKey := fmt.Sprintf("tasks:id:%d", taskID)
-	cachedData, err := c.redis.getRedis(ctx, cacheKey)
-	if err != nil && err != redis.Nil {
-		return nil, err
-	}
-
-	if err != redis.Nil {
-		byteVal, ok := cachedData.([]byte)
-		if !ok {
-			strVal, ok := cachedData.(string)
-			if !ok {
-				return nil, fmt.Errorf("expected %T to be a []byte or string", byteVal)
+func (c *TaskModelORM) TaskById(ctx context.Context, workspaceID uint, taskID int) (*Task, error) {
+	return readThrough(ctx, c, "task", workspaceID, taskCacheKey(workspaceID, uint(taskID)), c.cacheTTL.TaskTTL, func(ctx context.Context) (*Task, error) {
+		var task *Task
+		result := inWorkspace(c.db.WithContext(ctx), workspaceID).Where("id = ? AND is_deleted = ?", taskID, false).First(&task)
+		if result.Error != nil {
+			if result.Error == gorm.ErrRecordNotFound {
+				return task, pkg.ErrNoRecord
 			}
-			byteVal = []byte(strVal)
-		}
 
-		err = json.Unmarshal(byteVal, &task)
-		return task, err
-	}
-
-	result := c.db.WithContext(ctx).Where("id = ? AND is_deleted = 0", taskID).First(&task)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			return task, pkg.ErrNoRecord
+			logging.FromContext(ctx, c.logger).Error("Query Execution Failed: ", result.Error)
+			return task, result.Error
 		}
 
-		c.logger.Error("Query Execution Failed: ", result.Error)
-		return task, result.Error
-	}
-
-	tasksJSON, _ := json.Marshal(task)
-	return task, c.redis.setRedis(ctx, cacheKey, tasksJSON, 10*time.Minute)
+		return task, nil
+	})
 }
 
 func (c *TaskModelORM) TaskListing(ctx context.Context, f *Filters) ([]*Task, error) {
-	var task []*Task
-	cacheKey := fmt.Sprintf("tasks:listing:%s", strings.TrimSpace(f.otherConditions()))
-	cachedData, err := c.redis.getRedis(ctx, cacheKey)
-	if err != nil && err != redis.Nil {
-		return nil, err
-	}
-
-	if err != redis.Nil {
-		byteVal, ok := cachedData.([]byte)
-		if !ok {
-			strVal, ok := cachedData.(string)
-			if !ok {
-				return nil, fmt.Errorf("expected %T to be a []byte or string", byteVal)
-			}
-			byteVal = []byte(strVal)
-		}
-
-		err = json.Unmarshal(byteVal, &task)
-		return task, err
+	load := func(ctx context.Context) ([]*Task, error) {
+		var task []*Task
+		result := f.apply(c.db.WithContext(ctx).Where("is_deleted = ?", false)).Find(&task)
+		return task, result.Error
 	}
 
-	result := c.db.WithContext(ctx).Where("is_deleted = 0 " + f.otherConditions()).Find(&task)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			return task, pkg.ErrNoRecord
-		}
-		return task, result.Error
+	// without the generation there is no key, the page comes from the database
+	cacheKey, err := c.listingCacheKey(ctx, f)
+	if err != nil {
+		countCacheLookup("listing", "error")
+		return load(ctx)
 	}
 
-	tasksJSON, _ := json.Marshal(task)
-	return task, c.redis.setRedis(ctx, cacheKey, tasksJSON, 10*time.Minute)
+	return readThrough(ctx, c, "listing", f.WorkspaceID, cacheKey, c.cacheTTL.ListingTTL, load)
 }
 
 func (c *TaskModelORM) CreateTask(ctx context.Context, task *Task) error {
 	c.mute.Lock()
 	defer c.mute.Unlock()
+	if err := c.checkProject(ctx, task); err != nil {
+		return err
+	}
+
 	result := c.db.WithContext(ctx).Model(&Task{}).Create(task)
 	if result.Error != nil {
 		return result.Error
@@ -117,31 +100,38 @@ func (c *TaskModelORM) CreateTask(ctx context.Context, task *Task) error {
 		return pkg.ErrNoRecord
 	}
 
-	return c.redis.FlushCache(ctx)
+	metrics.TasksCreated.Inc()
+	// the id may be remembered as not found
+	c.invalidate(ctx, taskWorkspace(task), task.ID)
+	return nil
 }
 func (c *TaskModelORM) UpdateTask(ctx context.Context, id int, task *Task) error {
 	c.mute.Lock()
 	defer c.mute.Unlock()
+	if err := c.checkProject(ctx, task); err != nil {
+		return err
+	}
 
 	updates := map[string]interface{}{
 		"title":       task.Title,
 		"description": task.Description,
 		"status":      task.Status,
+		"project_id":  task.ProjectID,
 		"updated_at":  time.Now(),
 		"version":     gorm.Expr("version + 1"),
 	}
 
-	if !task.DueAt.IsZero() {
+	if task.ClearDueAt {
+		updates["due_at"] = nil
+	} else if task.DueAt != nil && !task.DueAt.IsZero() {
 		updates["due_at"] = task.DueAt
 	}
 
 	// Perform the update with conditional check
 	var tasks Task
-	result := c.db.
-		WithContext(ctx).
-		Model(tasks).
+	result := writableTasks(c.db.WithContext(ctx).Model(tasks), taskWorkspace(task), task.UserID).
 		// Clauses(clause.Returning{Columns: []clause.Column{{Name: "title"}, {Name: "description"}}}).
-		Where("id = ? AND user_id = ? AND is_deleted = 0", id, task.UserID).
+		Where("id = ? AND is_deleted = ?", id, false).
 		Updates(updates)
 
 	if result.Error != nil {
@@ -152,20 +142,23 @@ func (c *TaskModelORM) UpdateTask(ctx context.Context, id int, task *Task) error
 		return pkg.ErrInvalidUserFound
 	}
 
-	_ = c.redis.deleteRedis(ctx, fmt.Sprintf("task:id:%d", id))
-	return c.redis.FlushCache(ctx)
+	if task.Status == "completed" {
+		metrics.TasksCompleted.Inc()
+	}
+
+	c.invalidate(ctx, taskWorkspace(task), uint(id))
+	return nil
 }
 
-func (c *TaskModelORM) SoftDelete(ctx context.Context, userID, taskID uint) error {
+func (c *TaskModelORM) SoftDelete(ctx context.Context, workspaceID, userID, taskID uint) error {
 	c.mute.Lock()
 	defer c.mute.Unlock()
 	updates := map[string]interface{}{
 		"deleted_at": time.Now(),
 		"is_deleted": true,
 	}
-	result := c.db.WithContext(ctx).
-		Model(&Task{}).
-		Where("id = ? AND user_id = ? AND is_deleted = 0", taskID, userID).
+	result := writableTasks(c.db.WithContext(ctx).Model(&Task{}), workspaceID, userID).
+		Where("id = ? AND is_deleted = ?", taskID, false).
 		Updates(updates)
 
 	if result.Error != nil {
@@ -176,11 +169,74 @@ func (c *TaskModelORM) SoftDelete(ctx context.Context, userID, taskID uint) erro
 		return pkg.ErrInvalidUserFound
 	}
 
-	_ = c.redis.deleteRedis(ctx, fmt.Sprintf("task:id:%d", taskID))
-	return c.redis.FlushCache(ctx)
+	metrics.TasksDeleted.Inc()
+	c.invalidate(ctx, workspaceID, taskID)
+	return nil
+}
+
+// checkProject returns pkg.ErrUnknownProject unless the project of the task
+// is one of the user's. Projects are personal, tasks of a workspace can't
+// be in one.
+func (c *TaskModelORM) checkProject(ctx context.Context, task *Task) error {
+	if task.ProjectID == nil {
+		return nil
+	}
+
+	if task.WorkspaceID != nil {
+		return pkg.ErrUnknownProject
+	}
+
+	return ownsProject(c.db.WithContext(ctx), task.UserID, *task.ProjectID)
+}
+
+// taskWorkspace is the workspace of the task, 0 for a personal one.
+func taskWorkspace(task *Task) uint {
+	if task.WorkspaceID == nil {
+		return 0
+	}
+
+	return *task.WorkspaceID
+}
+
+// inWorkspace narrows a task query to the workspace, or to the personal
+// tasks for 0.
+func inWorkspace(db *gorm.DB, workspaceID uint) *gorm.DB {
+	if workspaceID == 0 {
+		return db.Where("workspace_id IS NULL")
+	}
+
+	return db.Where("workspace_id = ?", workspaceID)
+}
+
+// writableTasks narrows to the tasks of the workspace, personal tasks only
+// to those of the user.
+func writableTasks(db *gorm.DB, workspaceID, userID uint) *gorm.DB {
+	if workspaceID == 0 {
+		return inWorkspace(db, 0).Where("user_id = ?", userID)
+	}
+
+	return inWorkspace(db, workspaceID)
+}
+
+// PurgeDeleted hard deletes what SoftDelete left behind. Nothing cached can
+// refer to those tasks anymore, so the cache is left alone.
+func (c *TaskModelORM) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	result := c.db.WithContext(ctx).
+		Where("is_deleted = ? AND deleted_at < ?", true, before).
+		Delete(&Task{})
+
+	return result.RowsAffected, result.Error
+}
+
+func countCacheLookup(cache, result string) {
+	metrics.CacheRequests.WithLabelValues(cache, result).Inc()
 }
 
 func (m *TaskModelORM) ValidateTaskData(task *Task, updated bool) *pkg.Validator {
+	return validateTaskData(task, updated)
+}
+
+func validateTaskData(task *Task, updated bool) *pkg.Validator {
 	validator := &pkg.Validator{
 		Errors: make(map[string]string),
 	}
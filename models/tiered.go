@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// invalidationChannel carries the keys a replica dropped to the local
+// tiers of the others.
+const invalidationChannel = "tasks.cache.invalidate"
+
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+// TieredCache keeps hot entries in process memory in front of a shared
+// remote cache. Local copies live for localTTL at most. Deletes go to the
+// remote cache and over Redis pub/sub to every replica, Run applies those
+// of the others.
+type TieredCache struct {
+	local    *MemoryCache
+	remote   Cache
+	client   *redis.Client
+	localTTL time.Duration
+	origin   string
+	logger   *logrus.Logger
+}
+
+func NewTieredCache(local *MemoryCache, remote Cache, client *redis.Client, localTTL time.Duration, logger *logrus.Logger) *TieredCache {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	return &TieredCache{
+		local:    local,
+		remote:   remote,
+		client:   client,
+		localTTL: localTTL,
+		origin:   hex.EncodeToString(origin),
+		logger:   logger,
+	}
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := c.local.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.local.Set(ctx, key, value, c.localTTL)
+	return value, nil
+}
+
+func (c *TieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+
+	if ttl <= 0 || ttl > c.localTTL {
+		ttl = c.localTTL
+	}
+
+	return c.local.Set(ctx, key, value, ttl)
+}
+
+func (c *TieredCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	c.local.Delete(ctx, keys...)
+	if err := c.remote.Delete(ctx, keys...); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, invalidation{Keys: keys})
+}
+
+// Incr counts in the remote cache, the local copies of the counter are
+// dropped everywhere.
+func (c *TieredCache) Incr(ctx context.Context, key string) (int64, error) {
+	c.local.Delete(ctx, key)
+	n, err := c.remote.Incr(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, c.publish(ctx, invalidation{Keys: []string{key}})
+}
+
+func (c *TieredCache) Clear(ctx context.Context) error {
+	c.local.Clear(ctx)
+	if err := c.remote.Clear(ctx); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, invalidation{All: true})
+}
+
+// Stats are those of the remote cache, with the local tier of this
+// process as Local.
+func (c *TieredCache) Stats(ctx context.Context) (*CacheStats, error) {
+	stats, err := c.remote.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Local, err = c.local.Stats(ctx)
+	return stats, err
+}
+
+func (c *TieredCache) publish(ctx context.Context, msg invalidation) error {
+	msg.Origin = c.origin
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+// Run applies the invalidations of other replicas until ctx is done. While
+// the subscription is down messages are lost, so the local tier is cleared
+// whenever it is (re)established.
+func (c *TieredCache) Run(ctx context.Context) error {
+	sub := c.client.Subscribe(ctx, invalidationChannel)
+	// Receive doesn't give up when ctx is done, closing the subscription
+	// makes it
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	defer stop()
+	defer sub.Close()
+	for {
+		msg, err := sub.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			c.local.Clear(ctx)
+			c.logger.Warn("Cache invalidation subscription failed, retrying: ", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			c.local.Clear(ctx)
+		case *redis.Message:
+			c.apply(ctx, msg.Payload)
+		}
+	}
+}
+
+func (c *TieredCache) apply(ctx context.Context, payload string) {
+	var msg invalidation
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		c.logger.Warn("Ignoring a malformed cache invalidation: ", err)
+		return
+	}
+
+	switch {
+	case msg.Origin == c.origin:
+	case msg.All:
+		c.local.Clear(ctx)
+	default:
+		c.local.Delete(ctx, msg.Keys...)
+	}
+}
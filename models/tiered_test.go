@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// replica is a tiered cache as the nth process sharing a Redis has it,
+// with its invalidation worker running.
+func replica(t *testing.T, server *miniredis.Miniredis, n int) *TieredCache {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	cache := NewTieredCache(NewLRUCache(1<<20), &RedisStruct{client: client, logger: logrus.New()}, client, time.Minute, logrus.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		client.Close()
+	})
+
+	// wait for the subscription so no invalidation is missed
+	eventually(t, "the invalidation worker never subscribed", func() bool {
+		return server.PubSubNumSub(invalidationChannel)[invalidationChannel] >= n
+	})
+
+	return cache
+}
+
+// eventually fails the test unless check holds within a second.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTieredCacheInvalidatesReplicas(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	a, b := replica(t, server, 1), replica(t, server, 2)
+
+	a.Set(ctx, "tasks:id:1", []byte(`{"id":1}`), time.Minute)
+	if value, err := b.Get(ctx, "tasks:id:1"); err != nil || string(value) != `{"id":1}` {
+		t.Fatalf("Get on the other replica = %q, %v", value, err)
+	}
+
+	// b serves its local copy now, even with Redis changed behind its back
+	server.Set("tasks:id:1", `{"id":2}`)
+	if value, _ := b.Get(ctx, "tasks:id:1"); string(value) != `{"id":1}` {
+		t.Fatalf("Get = %q, want the local copy", value)
+	}
+
+	a.Delete(ctx, "tasks:id:1")
+	eventually(t, "Delete on one replica left the key in the local tier of the other", func() bool {
+		_, err := b.local.Get(ctx, "tasks:id:1")
+		return err == ErrCacheMiss
+	})
+
+	if _, err := b.Get(ctx, "tasks:id:1"); err != ErrCacheMiss {
+		t.Fatalf("Get after Delete = %v, want ErrCacheMiss", err)
+	}
+
+	// the listing generation is counted in Redis and read through the
+	// local tiers, a bump must reach every one of them
+	a.Set(ctx, listingGenerationKey(0), []byte("1"), 0)
+	b.Get(ctx, listingGenerationKey(0))
+	if n, err := a.Incr(ctx, listingGenerationKey(0)); err != nil || n != 2 {
+		t.Fatalf("Incr = %d, %v, want 2", n, err)
+	}
+
+	eventually(t, "Incr on one replica left the old generation on the other", func() bool {
+		value, _ := b.Get(ctx, listingGenerationKey(0))
+		return string(value) == "2"
+	})
+
+	b.Set(ctx, "tasks:id:3", []byte(`{"id":3}`), time.Minute)
+	a.Clear(ctx)
+	eventually(t, "Clear on one replica left the local tier of the other", func() bool {
+		stats, _ := b.local.Stats(ctx)
+		return len(stats.Keys) == 0
+	})
+}
+
+func TestTieredCacheBoundsLocalCopies(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	cache := NewTieredCache(NewLRUCache(1<<20), &RedisStruct{client: client, logger: logrus.New()}, client, 20*time.Millisecond, logrus.New())
+
+	cache.Set(ctx, "tasks:id:1", []byte(`{"id":1}`), time.Minute)
+	server.Set("tasks:id:1", `{"id":2}`)
+	time.Sleep(30 * time.Millisecond)
+	if value, _ := cache.Get(ctx, "tasks:id:1"); string(value) != `{"id":2}` {
+		t.Fatalf("Get = %q, the local copy outlived CACHE_LOCAL_TTL", value)
+	}
+
+	stats, err := cache.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Local == nil || stats.Local.Keys["id"] != 1 {
+		t.Fatalf("Stats = %+v, want the local tier with the task", stats)
+	}
+}
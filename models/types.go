@@ -12,11 +12,25 @@ type User struct {
 	HashPassw       string     `gorm:"not null"`
 	ActivationToken string     `gorm:"index"`
 	Active          bool       `gorm:"default:false" json:"-"`
+	MFAEnabled      bool       `gorm:"default:false" json:"-"`
+	MFASecret       string     `json:"-"`
+	Role            string     `gorm:"size:16;not null;default:'user';check:chk_users_role,role IN ('user','admin')" json:"role"`
 	VerifiedAt      time.Time  `gorm:"default:null"`
-	CreatedAt       *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()" json:"created_at,omitempty" binding:"-"`
+	CreatedAt       *time.Time `json:"created_at,omitempty" binding:"-"`
 	UpdatedAt       *time.Time `gorm:"default:null" json:"-" binding:"-"`
 }
 
+// Roles a user can have, the users table only allows these.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ValidRole tells whether role is one of RoleUser and RoleAdmin.
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
 type UserStruct struct {
 	Email       string `json:"email"`
 	Passw       string `json:"passw"`
@@ -24,23 +38,89 @@ type UserStruct struct {
 }
 
 type UsersSession struct {
-	ID         uint       `gorm:"primaryKey"`
-	UserID     uint       `gorm:"index"`
-	LoginToken string     `gorm:"not null"`
-	CreatedAt  *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()"`
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	LoginToken string `gorm:"not null"`
+	CreatedAt  *time.Time
+}
+
+type UserRecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index"`
+	CodeHash  string     `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	CreatedAt *time.Time
+}
+
+type MFAStruct struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	Passw    string `json:"passw"`
+}
+
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	TokenHash  string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	Scopes     []string   `gorm:"serializer:json;not null" json:"scopes"`
+	ExpiresAt  *time.Time `gorm:"default:null" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `gorm:"default:null" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `gorm:"default:null" json:"-"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// HasScope reports whether the token grants scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type APITokenStruct struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Provider  string `gorm:"size:64;not null;uniqueIndex:idx_provider_subject"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_provider_subject"`
+	Email     string `gorm:"size:255"`
+	CreatedAt *time.Time
 }
 
 type UserActivityLog struct {
-	ID         uint       `gorm:"primaryKey"`
-	UserID     uint       `gorm:"index"`
-	Activity   string     `gorm:"not null"`
-	Superseded bool       `gorm:"default:0"`
-	CreatedAt  *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()"`
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	Activity   string `gorm:"not null"`
+	Superseded bool   `gorm:"default:false"`
+	CreatedAt  *time.Time
 	UpdatedAt  *time.Time `gorm:"default:null"`
 }
 
+// Token purposes. Only session tokens are accepted by LoginMiddleware, an
+// MFA pending token is good for nothing but POST /login/mfa.
+const (
+	tokenPurposeSession = ""
+	tokenPurposeMFA     = "mfa"
+)
+
 type MyCustomClaims struct {
-	Email  string `json:"email"`
-	UserID uint   `json:"user_id"`
+	Email   string `json:"email"`
+	UserID  uint   `json:"user_id"`
+	Purpose string `json:"purpose,omitempty"`
 	jwt.StandardClaims
 }
+
+func (c *MyCustomClaims) IsSession() bool {
+	return c.Purpose == tokenPurposeSession
+}
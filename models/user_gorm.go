@@ -8,13 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/metrics"
 	"github.com/iamgak/go-task/pkg"
-	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -22,9 +22,14 @@ import (
 )
 
 type UserModelORM struct {
-	db     *gorm.DB
-	redis  *redis.Client
-	logger *logrus.Logger
+	db         *gorm.DB
+	redis      *redis.Client
+	logger     *logrus.Logger
+	mailer     Mailer
+	signer     *TokenSigner
+	identity   IdentityProvider
+	appURL     string
+	sessionTTL time.Duration
 }
 
 func (m *UserModelORM) RegisterUser(ctx context.Context, email, password, ip string) error {
@@ -43,27 +48,52 @@ func (m *UserModelORM) RegisterUser(ctx context.Context, email, password, ip str
 		return pkg.ErrNoRecord
 	}
 
+	metrics.Registrations.Inc()
 	activity := UserActivityLog{UserID: user.ID, Activity: "New User Register"}
 	return m.UserActivityLog(&activity)
 }
 
-func (m *UserModelORM) LoginUser(c context.Context, creds *UserStruct) (string, error) {
+// LoginUser checks the credentials and returns a session token, or a short
+// lived MFA pending token and true when the user has MFA turned on.
+func (m *UserModelORM) LoginUser(c context.Context, creds *UserStruct, ip string) (string, bool, error) {
+	email := normaliseEmail(creds.Email)
+	if err := m.checkLoginAllowed(c, email, ip); err != nil {
+		return "", false, err
+	}
+
 	var user User
 	if err := m.db.WithContext(c).Where("email = ?", strings.TrimSpace(creds.Email)).First(&user).Error; err != nil {
-		m.logger.Error("Error fetching data", err)
-		return "", pkg.ErrInvalidCredentials
+		logging.FromContext(c, m.logger).Error("Error fetching data", err)
+		m.recordLoginFailure(c, email, ip, nil)
+		return "", false, pkg.ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(creds.Passw)); err != nil {
+		logging.FromContext(c, m.logger).Error("Error handling passw", err)
+		m.recordLoginFailure(c, email, ip, &user)
+		return "", false, pkg.ErrInvalidCredentials
 	}
 
+	// inactive is only reported to those who know the password, wrong
+	// guesses count as failures like for any other account
 	if !user.Active {
-		return "", pkg.ErrAccountInActive
+		return "", false, pkg.ErrAccountInActive
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(creds.Passw)); err != nil {
-		m.logger.Error("Error handling passw", err)
-		return "", pkg.ErrInvalidCredentials
+	m.resetLoginFailures(c, email)
+	if user.MFAEnabled {
+		token, err := m.generateToken(user.Email, user.ID, tokenPurposeMFA, mfaPendingTTL)
+		return token, true, err
 	}
 
-	token, err := m.generateToken(user.Email, user.ID)
+	token, err := m.completeLogin(c, &user, "password")
+	return token, false, err
+}
+
+// completeLogin issues the session token once every factor has been checked,
+// method is how the user got here for the login metrics.
+func (m *UserModelORM) completeLogin(ctx context.Context, user *User, method string) (string, error) {
+	token, err := m.generateToken(user.Email, user.ID, tokenPurposeSession, m.sessionTTL)
 	if err != nil {
 		return "", err
 	}
@@ -74,7 +104,12 @@ func (m *UserModelORM) LoginUser(c context.Context, creds *UserStruct) (string,
 		return "", err
 	}
 
-	return token, m.CreateSession(token, user.ID)
+	if err := m.CreateSession(token, user.ID); err != nil {
+		return "", err
+	}
+
+	metrics.Logins.WithLabelValues(method).Inc()
+	return token, nil
 }
 
 func (m *UserModelORM) GeneratePassword(newPassword string) ([]byte, error) {
@@ -108,23 +143,132 @@ func (m *UserModelORM) ActivateAccount(token string) error {
 	return m.UserActivityLog(&activity)
 }
 
-func (m *UserModelORM) generateToken(email string, userID uint) (string, error) {
-	if err := godotenv.Load(); err != nil {
-		m.logger.Error(err.Error())
-		return "", pkg.ErrInternalServer
-	}
-
-	signingKey := []byte(os.Getenv("SIGNING_KEY"))
+func (m *UserModelORM) generateToken(email string, userID uint, purpose string, ttl time.Duration) (string, error) {
 	claims := MyCustomClaims{
-		Email:  email,
-		UserID: userID,
+		Email:   email,
+		UserID:  userID,
+		Purpose: purpose,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(4 * time.Hour).Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(signingKey)
+
+	return m.signer.Sign(&claims)
+}
+
+// ParseToken checks the signature and, for sessions, that the session
+// wasn't ended since: a password change or deactivation deletes them.
+func (m *UserModelORM) ParseToken(tokenString string) (*MyCustomClaims, error) {
+	claims, err := m.signer.Parse(tokenString)
+	if err != nil || !claims.IsSession() {
+		return claims, err
+	}
+
+	var sessions int64
+	if err := m.db.Model(&UsersSession{}).Where("user_id = ? AND login_token = ?", claims.UserID, tokenString).Count(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	if sessions == 0 {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// UserByEmail looks a user up by address, it returns pkg.ErrNoRecord when
+// there is none.
+func (m *UserModelORM) UserByEmail(ctx context.Context, email string) (*User, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Where("email = ?", strings.TrimSpace(email)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, pkg.ErrNoRecord
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SetActive turns an account on or off. Activating it this way also drops
+// the pending activation token, deactivating it ends its sessions and
+// revokes its API tokens.
+func (m *UserModelORM) SetActive(ctx context.Context, userID uint, active bool) error {
+	updates := map[string]interface{}{"active": active, "updated_at": time.Now()}
+	activity := "Account Deactivated"
+	if active {
+		updates["activation_token"] = nil
+		updates["verified_at"] = time.Now()
+		activity = "Account Activated"
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := updateUser(tx, userID, updates); err != nil || active {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&UsersSession{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&APIToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", time.Now()).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return m.UserActivityLog(&UserActivityLog{UserID: userID, Activity: activity})
+}
+
+func (m *UserModelORM) SetPassword(ctx context.Context, userID uint, password string) error {
+	hashedPassword, err := m.GeneratePassword(password)
+	if err != nil {
+		return err
+	}
+
+	// whoever knew the old password is logged out with it
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := updateUser(tx, userID, map[string]interface{}{"hash_passw": string(hashedPassword), "updated_at": time.Now()}); err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&UsersSession{}).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return m.UserActivityLog(&UserActivityLog{UserID: userID, Activity: "Password Changed"})
+}
+
+func (m *UserModelORM) SetRole(ctx context.Context, userID uint, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	if err := updateUser(m.db.WithContext(ctx), userID, map[string]interface{}{"role": role, "updated_at": time.Now()}); err != nil {
+		return err
+	}
+
+	return m.UserActivityLog(&UserActivityLog{UserID: userID, Activity: "Role Changed"})
+}
+
+// updateUser returns pkg.ErrNoRecord when there is no such user.
+func updateUser(db *gorm.DB, userID uint, updates map[string]interface{}) error {
+	result := db.Model(&User{}).Where("id = ?", userID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return pkg.ErrNoRecord
+	}
+
+	return nil
 }
 
 func (m *UserModelORM) emailExists(email string) bool {
@@ -144,6 +288,12 @@ func (m *UserModelORM) GenerateSHA1Hash(ip string) string {
 }
 
 func (m *UserModelORM) ValidateUserData(user *UserStruct, register bool) *pkg.Validator {
+	return validateUserData(user, register, m.emailExists)
+}
+
+// validateUserData checks the form, emailExists tells whether an address is
+// taken when registering.
+func validateUserData(user *UserStruct, register bool, emailExists func(string) bool) *pkg.Validator {
 	validator := &pkg.Validator{
 		Errors: make(map[string]string),
 	}
@@ -158,7 +308,7 @@ func (m *UserModelORM) ValidateUserData(user *UserStruct, register bool) *pkg.Va
 
 	if register {
 		validator.CheckField(validator.NotBlank(user.RepeatPassw), "repeatPassword", "Please, fill the repeat password field")
-		if m.emailExists(user.Email) {
+		if emailExists(user.Email) {
 			validator.CheckField(false, "email", "Email already registered")
 		}
 		if user.Passw != user.RepeatPassw {
@@ -171,8 +321,8 @@ func (m *UserModelORM) ValidateUserData(user *UserStruct, register bool) *pkg.Va
 }
 
 func (m *UserModelORM) UserActivityLog(activity *UserActivityLog) error {
-	result := m.db.Model(&UserActivityLog{}).Where("user_id = ? AND activity =? AND superseded = 0", activity.UserID, activity.Activity).
-		Updates(map[string]interface{}{"superseded": 1, "updated_at": time.Now()})
+	result := m.db.Model(&UserActivityLog{}).Where("user_id = ? AND activity = ? AND superseded = ?", activity.UserID, activity.Activity, false).
+		Updates(map[string]interface{}{"superseded": true, "updated_at": time.Now()})
 
 	if result.Error != nil && result.Error != sql.ErrNoRows {
 		return result.Error
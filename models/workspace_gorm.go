@@ -0,0 +1,343 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-task/logging"
+	"github.com/iamgak/go-task/pkg"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Roles of workspace members, each may do what the ones before it may:
+// viewers read the tasks, editors change them too and owners also manage
+// the members.
+const (
+	WorkspaceViewer = "viewer"
+	WorkspaceEditor = "editor"
+	WorkspaceOwner  = "owner"
+
+	invitationDays = 7
+)
+
+var workspaceRoles = []string{WorkspaceViewer, WorkspaceEditor, WorkspaceOwner}
+
+func ValidWorkspaceRole(role string) bool {
+	return workspaceRoleRank(role) >= 0
+}
+
+// WorkspaceRoleAllows tells whether role may do what needs at least the
+// role least.
+func WorkspaceRoleAllows(role, least string) bool {
+	return ValidWorkspaceRole(least) && workspaceRoleRank(role) >= workspaceRoleRank(least)
+}
+
+func workspaceRoleRank(role string) int {
+	for i, r := range workspaceRoles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Workspace is shared by its members, its tasks are theirs to read and
+// change as their role allows.
+type Workspace struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Name      string     `gorm:"size:100;not null" json:"name"`
+	CreatedAt *time.Time `json:"created_at,omitempty" binding:"-"`
+	UpdatedAt *time.Time `gorm:"default:null" json:"updated_at,omitempty" binding:"-"`
+	// Role is the one of the user the workspace was listed for, Members are
+	// only filled in by WorkspaceById
+	Role    string             `gorm:"-" json:"role,omitempty"`
+	Members []*WorkspaceMember `gorm:"-" json:"members,omitempty"`
+}
+
+type WorkspaceMember struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	WorkspaceID uint       `gorm:"not null;uniqueIndex:idx_workspace_members_member" json:"workspace_id"`
+	UserID      uint       `gorm:"not null;uniqueIndex:idx_workspace_members_member;index" json:"user_id"`
+	Role        string     `gorm:"size:16;not null;check:chk_workspace_members_role,role IN ('viewer','editor','owner')" json:"role"`
+	Email       string     `gorm:"->;-:migration" json:"email,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// WorkspaceInvitation asks whoever has the email to join. Only the hash of
+// its token is kept, the token itself is in the mail.
+type WorkspaceInvitation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint       `gorm:"index;not null" json:"workspace_id" binding:"-"`
+	Email       string     `gorm:"size:255;not null" json:"email"`
+	Role        string     `gorm:"size:16;not null;check:chk_workspace_invitations_role,role IN ('viewer','editor','owner')" json:"role"`
+	TokenHash   string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	InvitedBy   uint       `gorm:"not null" json:"-" binding:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at" binding:"-"`
+	AcceptedAt  *time.Time `gorm:"default:null" json:"accepted_at,omitempty" binding:"-"`
+	CreatedAt   *time.Time `json:"created_at,omitempty" binding:"-"`
+}
+
+type WorkspaceModelORM struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+	mailer Mailer
+	appURL string
+}
+
+func (m *WorkspaceModelORM) CreateWorkspace(ctx context.Context, workspace *Workspace, ownerID uint) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(workspace).Error; err != nil {
+			return err
+		}
+
+		workspace.Role = WorkspaceOwner
+		return tx.Create(&WorkspaceMember{WorkspaceID: workspace.ID, UserID: ownerID, Role: WorkspaceOwner}).Error
+	})
+}
+
+func (m *WorkspaceModelORM) WorkspaceListing(ctx context.Context, userID uint) ([]*Workspace, error) {
+	var members []*WorkspaceMember
+	if err := m.db.WithContext(ctx).Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	roles := make(map[uint]string, len(members))
+	ids := make([]uint, 0, len(members))
+	for _, member := range members {
+		roles[member.WorkspaceID] = member.Role
+		ids = append(ids, member.WorkspaceID)
+	}
+
+	workspaces := make([]*Workspace, 0, len(ids))
+	if len(ids) == 0 {
+		return workspaces, nil
+	}
+
+	if err := m.db.WithContext(ctx).Where("id IN ?", ids).Order("name").Order("id").Find(&workspaces).Error; err != nil {
+		return nil, err
+	}
+
+	for _, workspace := range workspaces {
+		workspace.Role = roles[workspace.ID]
+	}
+
+	return workspaces, nil
+}
+
+func (m *WorkspaceModelORM) WorkspaceById(ctx context.Context, workspaceID uint) (*Workspace, error) {
+	var workspace Workspace
+	result := m.db.WithContext(ctx).First(&workspace, workspaceID)
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, pkg.ErrNoRecord
+	}
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	err := m.db.WithContext(ctx).
+		Select("workspace_members.*, users.email").
+		Joins("JOIN users ON users.id = workspace_members.user_id").
+		Where("workspace_members.workspace_id = ?", workspaceID).
+		Order("workspace_members.id").
+		Find(&workspace.Members).Error
+
+	return &workspace, err
+}
+
+func (m *WorkspaceModelORM) Member(ctx context.Context, workspaceID, userID uint) (*WorkspaceMember, error) {
+	return findMember(m.db.WithContext(ctx), workspaceID, userID)
+}
+
+func findMember(db *gorm.DB, workspaceID, userID uint) (*WorkspaceMember, error) {
+	var member WorkspaceMember
+	result := db.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member)
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil, pkg.ErrNoRecord
+	}
+
+	return &member, result.Error
+}
+
+func (m *WorkspaceModelORM) SetMemberRole(ctx context.Context, workspaceID, userID uint, role string) error {
+	if !ValidWorkspaceRole(role) {
+		return fmt.Errorf("unknown workspace role %q", role)
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		member, err := keepOwner(tx, workspaceID, userID, role)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(member).Update("role", role).Error
+	})
+}
+
+func (m *WorkspaceModelORM) RemoveMember(ctx context.Context, workspaceID, userID uint) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		member, err := keepOwner(tx, workspaceID, userID, "")
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(member).Error
+	})
+}
+
+// keepOwner finds the member about to get the role, none when removed. It
+// returns pkg.ErrLastOwner if that would leave the workspace without owner.
+func keepOwner(tx *gorm.DB, workspaceID, userID uint, role string) (*WorkspaceMember, error) {
+	member, err := findMember(tx, workspaceID, userID)
+	if err != nil || member.Role != WorkspaceOwner || role == WorkspaceOwner {
+		return member, err
+	}
+
+	var owners int64
+	if err := tx.Model(&WorkspaceMember{}).Where("workspace_id = ? AND role = ?", workspaceID, WorkspaceOwner).Count(&owners).Error; err != nil {
+		return nil, err
+	}
+
+	if owners < 2 {
+		return nil, pkg.ErrLastOwner
+	}
+
+	return member, nil
+}
+
+func (m *WorkspaceModelORM) Invite(ctx context.Context, invitation *WorkspaceInvitation) error {
+	invitation.Email = normaliseEmail(invitation.Email)
+	var workspace Workspace
+	if err := m.db.WithContext(ctx).First(&workspace, invitation.WorkspaceID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return pkg.ErrNoRecord
+		}
+
+		return err
+	}
+
+	var members int64
+	err := m.db.WithContext(ctx).
+		Model(&WorkspaceMember{}).
+		Joins("JOIN users ON users.id = workspace_members.user_id").
+		Where("workspace_members.workspace_id = ? AND LOWER(users.email) = ?", invitation.WorkspaceID, invitation.Email).
+		Count(&members).Error
+	if err != nil {
+		return err
+	}
+
+	if members != 0 {
+		return pkg.ErrAlreadyMember
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	invitation.TokenHash = hashToken(token)
+	invitation.ExpiresAt = time.Now().AddDate(0, 0, invitationDays)
+	if err := m.db.WithContext(ctx).Create(invitation).Error; err != nil {
+		return err
+	}
+
+	sendInvitation(ctx, m.mailer, m.logger, m.appURL, &workspace, invitation, token)
+	return nil
+}
+
+// sendInvitation mails the link to accept the invitation, without holding
+// up the request.
+func sendInvitation(ctx context.Context, mailer Mailer, logger *logrus.Logger, appURL string, workspace *Workspace, invitation *WorkspaceInvitation, token string) {
+	body := fmt.Sprintf("You have been invited to join the workspace %q as %s.\n\n"+
+		"To accept, log in with this email address and send a POST request to:\n\n%s/v1/invitations/%s/accept\n\n"+
+		"The invitation expires on %s.",
+		workspace.Name, invitation.Role, appURL, token, invitation.ExpiresAt.Format("2006-01-02"))
+
+	to := invitation.Email
+	go func() {
+		if err := mailer.Send(context.Background(), to, "You have been invited to "+workspace.Name, body); err != nil {
+			logging.FromContext(ctx, logger).Error("Failed to send invitation email: ", err)
+		}
+	}()
+}
+
+func (m *WorkspaceModelORM) AcceptInvitation(ctx context.Context, token string, userID uint, email string) (*WorkspaceMember, error) {
+	var member *WorkspaceMember
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invitation WorkspaceInvitation
+		result := tx.Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&invitation)
+		if result.Error == gorm.ErrRecordNotFound || result.Error == nil && invitation.Email != normaliseEmail(email) {
+			return pkg.ErrNoRecord
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if _, err := findMember(tx, invitation.WorkspaceID, userID); !errors.Is(err, pkg.ErrNoRecord) {
+			if err == nil {
+				return pkg.ErrAlreadyMember
+			}
+
+			return err
+		}
+
+		member = &WorkspaceMember{WorkspaceID: invitation.WorkspaceID, UserID: userID, Role: invitation.Role}
+		if err := tx.Create(member).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&invitation).Update("accepted_at", time.Now()).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	member.Email = email
+	return member, nil
+}
+
+func (m *WorkspaceModelORM) WorkspaceIDs(ctx context.Context) ([]uint, error) {
+	var ids []uint
+	err := m.db.WithContext(ctx).Model(&Workspace{}).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (m *WorkspaceModelORM) ValidateWorkspaceData(workspace *Workspace) *pkg.Validator {
+	return validateWorkspaceData(workspace)
+}
+
+func (m *WorkspaceModelORM) ValidateInvitationData(invitation *WorkspaceInvitation) *pkg.Validator {
+	return validateInvitationData(invitation)
+}
+
+func validateWorkspaceData(workspace *Workspace) *pkg.Validator {
+	validator := &pkg.Validator{
+		Errors: make(map[string]string),
+	}
+
+	validator.CheckField(validator.NotBlank(workspace.Name), "name", "Please, fill the name field")
+	validator.CheckField(validator.MaxChars(workspace.Name, 100), "name", "Name must not be longer than 100 characters")
+	return validator
+}
+
+func validateInvitationData(invitation *WorkspaceInvitation) *pkg.Validator {
+	validator := &pkg.Validator{
+		Errors: make(map[string]string),
+	}
+
+	email := strings.TrimSpace(invitation.Email)
+	validator.CheckField(validator.NotBlank(email), "email", "Please, fill the email field")
+	if validator.Errors["email"] == "" {
+		validator.CheckField(validator.ValidEmail(email), "email", "Invalid Email Format")
+	}
+
+	validator.CheckField(validator.PermittedValue(invitation.Role, workspaceRoles...), "role", "Role must be viewer, editor or owner")
+	return validator
+}
@@ -1,26 +1,110 @@
 package pkg
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net/http"
+)
 
 var (
-	ErrInvalidCredentials      = errors.New("errors: invalid credentials")
-	ErrAccountInActive         = errors.New("errors: account is inactive")
-	ErrDuplicateEmail          = errors.New("errors: duplicate email")
-	ErrNoRecord                = errors.New("errors: no matching record found")
-	ErrNoCookieFound           = errors.New("errors: no cookie found")
-	ErrCantFindProduct         = errors.New("errors: can't find product")
-	ErrCantDecodeProducts      = errors.New("errors: can't find product")
-	ErrUserIDIsNotValid        = errors.New("errors: user is not valid")
-	ErrCantAddInCart           = errors.New("errors: cannot add product to cart")
-	ErrCantAddUser             = errors.New("errors: cannot add user")
-	ErrCantAddProduct          = errors.New("errors: cannot add product")
-	ErrCantRemoveItem          = errors.New("errors: cannot remove item from cart")
-	ErrCantGetItem             = errors.New("errors: cannot get item from cart ")
-	ErrCantBuyCartItem         = errors.New("errors: cannot update the purchase")
-	ErrNoEnvFileFound          = errors.New("errors: no matching .env file found")
-	ErrIncorrectPassword       = errors.New("errors: incorrect password")
-	ErrCantUseGeneratePassword = errors.New("errors: bycrypt function not generating password")
-	ErrUserNotFound            = errors.New("errors: no such user exist")
-	ErrInvalidUserFound        = errors.New("errors: user access denied")
-	ErrInternalServer          = errors.New("errors: internal server error")
+	ErrInvalidCredentials = errors.New("errors: invalid credentials")
+	ErrAccountInActive    = errors.New("errors: account is inactive")
+	ErrNoRecord           = errors.New("errors: no matching record found")
+	ErrInvalidUserFound   = errors.New("errors: user access denied")
+	ErrInternalServer     = errors.New("errors: internal server error")
+	ErrAccountLocked      = errors.New("errors: account is temporarily locked")
+	ErrTooManyAttempts    = errors.New("errors: too many login attempts, try again later")
+	ErrInvalidToken       = errors.New("errors: invalid token")
+	ErrInvalidMFACode     = errors.New("errors: invalid MFA code")
+	ErrMFAAlreadyEnabled  = errors.New("errors: MFA is already enabled")
+	ErrMFANotEnabled      = errors.New("errors: MFA is not enabled")
+	ErrSSODisabled        = errors.New("errors: single sign-on is not configured")
+	ErrUnverifiedEmail    = errors.New("errors: identity provider did not verify the email")
+	ErrAccountExists      = errors.New("errors: an account with this email already exists")
+	ErrUnknownProject     = errors.New("errors: project not found")
+	ErrWorkspaceRole      = errors.New("errors: workspace role does not allow this")
+	ErrLastOwner          = errors.New("errors: workspace would be left without an owner")
+	ErrAlreadyMember      = errors.New("errors: already a member of the workspace")
+	ErrNeedsDatabase      = errors.New("errors: not available without a database")
 )
+
+// Stable error codes clients can switch on. Titles and details may change,
+// these don't.
+const (
+	CodeBadRequest        = "bad_request"
+	CodeValidationFailed  = "validation_failed"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeInsufficientScope = "insufficient_scope"
+	CodeNotFound          = "not_found"
+	CodeConflict          = "conflict"
+	CodeRateLimited       = "rate_limited"
+	CodeTimeout           = "timeout"
+	CodeMaintenance       = "maintenance"
+	CodeInternal          = "internal_error"
+
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeAccountInactive    = "account_inactive"
+	CodeAccountLocked      = "account_locked"
+	CodeTooManyAttempts    = "too_many_attempts"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidMFACode     = "invalid_mfa_code"
+	CodeMFAAlreadyEnabled  = "mfa_already_enabled"
+	CodeMFANotEnabled      = "mfa_not_enabled"
+	CodeSSODisabled        = "sso_disabled"
+	CodeUnverifiedEmail    = "unverified_email"
+	CodeAccountExists      = "account_exists"
+	CodeUnknownProject     = "unknown_project"
+	CodeLastOwner          = "last_owner"
+	CodeAlreadyMember      = "already_member"
+	CodeNotImplemented     = "not_implemented"
+)
+
+// ErrorKind is how an error is reported to clients.
+type ErrorKind struct {
+	Status int
+	Code   string
+	Title  string
+}
+
+// errorKinds maps sentinel errors, also when wrapped, to their response.
+// The first match wins.
+var errorKinds = []struct {
+	err  error
+	kind ErrorKind
+}{
+	{ErrInvalidCredentials, ErrorKind{http.StatusBadRequest, CodeInvalidCredentials, "Invalid email or password"}},
+	{ErrAccountInActive, ErrorKind{http.StatusBadRequest, CodeAccountInactive, "Account is not activated"}},
+	{ErrNoRecord, ErrorKind{http.StatusNotFound, CodeNotFound, "Not found"}},
+	// not telling apart missing tasks and tasks of somebody else
+	{ErrInvalidUserFound, ErrorKind{http.StatusNotFound, CodeNotFound, "Not found"}},
+	{ErrAccountLocked, ErrorKind{http.StatusLocked, CodeAccountLocked, "Account is temporarily locked"}},
+	{ErrTooManyAttempts, ErrorKind{http.StatusTooManyRequests, CodeTooManyAttempts, "Too many login attempts"}},
+	{ErrInvalidToken, ErrorKind{http.StatusUnauthorized, CodeInvalidToken, "Invalid or expired token"}},
+	{ErrInvalidMFACode, ErrorKind{http.StatusUnauthorized, CodeInvalidMFACode, "Invalid MFA code"}},
+	{ErrMFAAlreadyEnabled, ErrorKind{http.StatusConflict, CodeMFAAlreadyEnabled, "MFA is already enabled"}},
+	{ErrMFANotEnabled, ErrorKind{http.StatusConflict, CodeMFANotEnabled, "MFA is not enabled"}},
+	{ErrSSODisabled, ErrorKind{http.StatusNotFound, CodeSSODisabled, "Single sign-on is not configured"}},
+	{ErrUnverifiedEmail, ErrorKind{http.StatusUnauthorized, CodeUnverifiedEmail, "Identity provider did not verify the email"}},
+	{ErrAccountExists, ErrorKind{http.StatusConflict, CodeAccountExists, "An account with this email already exists"}},
+	{ErrUnknownProject, ErrorKind{http.StatusBadRequest, CodeUnknownProject, "Project not found"}},
+	{ErrWorkspaceRole, ErrorKind{http.StatusForbidden, CodeForbidden, "Your role in the workspace does not allow this"}},
+	{ErrLastOwner, ErrorKind{http.StatusConflict, CodeLastOwner, "A workspace needs an owner"}},
+	{ErrAlreadyMember, ErrorKind{http.StatusConflict, CodeAlreadyMember, "Already a member of the workspace"}},
+	{ErrNeedsDatabase, ErrorKind{http.StatusNotImplemented, CodeNotImplemented, "Not available on this server"}},
+	{context.DeadlineExceeded, ErrorKind{http.StatusGatewayTimeout, CodeTimeout, "Request timed out"}},
+}
+
+var internalError = ErrorKind{http.StatusInternalServerError, CodeInternal, "Internal Server Error"}
+
+// KindOf looks err up in the mapping table. Unknown errors are internal
+// errors, their message is not meant for clients.
+func KindOf(err error) (ErrorKind, bool) {
+	for _, e := range errorKinds {
+		if errors.Is(err, e.err) {
+			return e.kind, true
+		}
+	}
+
+	return internalError, false
+}
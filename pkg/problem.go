@@ -0,0 +1,31 @@
+package pkg
+
+// ProblemContentType is the media type of RFC 7807 error responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object, extended with our error
+// code, the request ID and per field validation errors.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func NewProblem(status int, code, title, detail string) *Problem {
+	return &Problem{Type: ProblemType(code), Title: title, Status: status, Detail: detail, Code: code}
+}
+
+// ProblemType is the URI identifying a kind of problem.
+func ProblemType(code string) string {
+	return "urn:go-task:problem:" + code
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +17,18 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// FieldErrors lists the errors of v ordered by field, so responses are
+// stable.
+func (v *Validator) FieldErrors() []FieldError {
+	fields := make([]FieldError, 0, len(v.Errors))
+	for field, message := range v.Errors {
+		fields = append(fields, FieldError{Field: field, Message: message})
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
 func (v *Validator) AddFieldError(key, message string) {
 	if v.Errors == nil {
 		v.Errors = make(map[string]string)
@@ -39,6 +52,16 @@ func (v *Validator) MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
 }
 
+func (v *Validator) PermittedValue(value string, permitted ...string) bool {
+	for _, p := range permitted {
+		if value == p {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (v *Validator) ValidEmail(email string) bool {
 	emailPattern := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	return emailPattern.MatchString(email)
@@ -1,33 +1,250 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/docs"
+	"github.com/iamgak/go-task/metrics"
+	"github.com/iamgak/go-task/models"
+	"github.com/iamgak/go-task/pkg"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func (app *Application) InitRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(gin.Logger())
-	r.Use(gin.Recovery())
-	r.Use(MaintenanceMiddleware())
-	r.Use(app.TimeoutMiddleware(5 * time.Second))
-	// read API
-	r.GET("/tasks", app.ListTask)
-	r.GET("/tasks/:id", app.TaskListingById)
+	r.Use(otelgin.Middleware(app.Config.Tracing.ServiceName, otelgin.WithFilter(traceRequest)))
+	r.Use(app.RequestLogger())
+	// metrics wrap recovery, so requests that panicked are counted as 500s
+	r.Use(metrics.Middleware())
+	r.Use(app.recovery())
 
-	authorise := r.Group("/tasks")
+	// probes are registered ahead of the maintenance and timeout middleware,
+	// gin only applies middleware to routes added after it
+	r.GET("/healthz", app.Healthz)
+	r.GET("/readyz", app.Readyz)
+	r.GET("/metrics", metrics.Handler())
 
-	authorise.Use(app.LoginMiddleware(), secureHeaders(), app.rateLimiter())
+	r.Use(app.MaintenanceMiddleware())
+	r.Use(app.TimeoutMiddleware(app.Config.HTTP.RequestTimeout))
+
+	r.GET("/openapi.json", docs.SpecHandler)
+	r.GET("/docs", docs.PageHandler)
+	r.GET("/.well-known/jwks.json", app.databaseOnly(), app.JWKS)
+	r.GET("/status", app.LoginMiddleware(), app.Status)
+
+	// browser flows are linked from emails and registered at the identity
+	// provider, they stay outside of the API versions
+	browser := r.Group("/")
+	browser.Use(app.rateLimiter("login", app.Config.RateLimit.Login))
 	{
-		// write API
-		authorise.POST("/", app.CreateTask)
-		authorise.PUT("/update/:id", app.UpdateTask)
-		authorise.DELETE("/delete/:id", app.SoftDelete)
+		browser.GET("/activation_token/:token", app.UserActivateAccount)
+		browser.GET("/unlock_account/:token", app.databaseOnly(), app.UserUnlockAccount)
+		browser.GET("/auth/oidc/start", app.databaseOnly(), app.OIDCStart)
+		browser.GET("/auth/oidc/callback", app.databaseOnly(), app.OIDCCallback)
+	}
+
+	for _, version := range apiVersions {
+		version.mount(app, r.Group(version.prefix))
 	}
 
-	r.POST("/login", app.UserLogin)
-	r.POST("/register", app.UserRegister)
-	r.GET("/activation_token/:token", app.UserActivateAccount)
+	app.mountLegacy(r)
+
+	r.NoRoute(func(c *gin.Context) {
+		app.problem(c, pkg.NewProblem(http.StatusNotFound, pkg.CodeNotFound, "Not found", "No route for "+c.Request.URL.Path))
+	})
+
 	return r
 }
+
+// apiVersion is one version of the API mounted under its prefix. A new
+// version gets its own mount function next to the older ones, reusing the
+// handlers that didn't change, so clients can move over at their own pace.
+type apiVersion struct {
+	prefix string
+	mount  func(app *Application, g *gin.RouterGroup)
+}
+
+var apiVersions = []apiVersion{
+	{"/v1", (*Application).mountV1},
+}
+
+func (app *Application) mountV1(g *gin.RouterGroup) {
+	read := app.rateLimiter("read", app.Config.RateLimit.Read)
+	g.GET("/tasks", read, app.projectLogin(), app.ListTask)
+	g.GET("/tasks/:id", read, app.TaskListingById)
+
+	tasks := g.Group("/tasks", app.taskWriteMiddleware()...)
+	{
+		tasks.POST("", app.CreateTask)
+		tasks.PUT("/:id", app.UpdateTask)
+		tasks.PATCH("/:id", app.PatchTask)
+		tasks.DELETE("/:id", app.SoftDelete)
+	}
+
+	// projects are private to their user, reading them needs a login too
+	g.GET("/projects", app.LoginMiddleware(), app.requireScope(models.ScopeTasksRead), read, app.ListProjects)
+	g.GET("/projects/:id", app.LoginMiddleware(), app.requireScope(models.ScopeTasksRead), read, app.ProjectById)
+
+	projects := g.Group("/projects", app.taskWriteMiddleware()...)
+	{
+		projects.POST("", app.CreateProject)
+		projects.PUT("/:id", app.UpdateProject)
+		projects.PATCH("/:id", app.PatchProject)
+		projects.DELETE("/:id", app.DeleteProject)
+	}
+
+	// tasks of a workspace are read by its members and changed by editors
+	// and owners, only owners manage the members and only with a session, as
+	// a tasks:write token must not hand the workspace over
+	workspaceReads := g.Group("/workspaces", app.LoginMiddleware(), app.requireScope(models.ScopeTasksRead), read)
+	{
+		workspaceReads.GET("", app.ListWorkspaces)
+		viewer := workspaceReads.Group("/:workspace", app.requireWorkspaceRole(models.WorkspaceViewer))
+		viewer.GET("", app.WorkspaceById)
+		viewer.GET("/tasks", app.ListTask)
+		viewer.GET("/tasks/:id", app.TaskListingById)
+	}
+
+	workspaces := g.Group("/workspaces", app.taskWriteMiddleware()...)
+	{
+		workspaces.POST("", app.CreateWorkspace)
+		editor := workspaces.Group("/:workspace", app.requireWorkspaceRole(models.WorkspaceEditor))
+		editor.POST("/tasks", app.CreateTask)
+		editor.PUT("/tasks/:id", app.UpdateTask)
+		editor.PATCH("/tasks/:id", app.PatchTask)
+		editor.DELETE("/tasks/:id", app.SoftDelete)
+
+		owner := workspaces.Group("/:workspace", app.sessionOnly(), app.requireWorkspaceRole(models.WorkspaceOwner))
+		owner.POST("/invitations", app.InviteMember)
+		owner.PATCH("/members/:user", app.UpdateMember)
+
+		// members may leave, RemoveMember checks the rest
+		workspaces.DELETE("/:workspace/members/:user", app.sessionOnly(), app.requireWorkspaceRole(models.WorkspaceViewer), app.RemoveMember)
+	}
+
+	// joining a workspace is an account change, like those under /me
+	g.POST("/invitations/:token/accept", app.LoginMiddleware(), app.sessionOnly(), secureHeaders(), app.rateLimiter("write", app.Config.RateLimit.Write), app.AcceptInvitation)
+
+	account := g.Group("/", app.rateLimiter("login", app.Config.RateLimit.Login))
+	{
+		account.POST("/login", app.UserLogin)
+		account.POST("/login/mfa", app.databaseOnly(), app.UserLoginMFA)
+		account.POST("/register", app.UserRegister)
+	}
+
+	app.mountMe(g.Group("/me"))
+}
+
+// The routes before /v1, they keep working until legacySunset but tell
+// clients where to go instead.
+var (
+	legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	legacySunset       = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+func (app *Application) mountLegacy(r *gin.Engine) {
+	read := app.rateLimiter("read", app.Config.RateLimit.Read)
+	r.GET("/tasks", deprecated("/v1/tasks"), read, app.projectLogin(), app.ListTask)
+	r.GET("/tasks/:id", deprecated("/v1/tasks/:id"), read, app.TaskListingById)
+
+	// the headers go ahead of the group middleware, so responses it rejects
+	// carry them too
+	tasks := r.Group("/tasks", deprecatedRoutes(map[string]string{
+		"/tasks/":           "/v1/tasks",
+		"/tasks/update/:id": "/v1/tasks/:id",
+		"/tasks/delete/:id": "/v1/tasks/:id",
+	}))
+	tasks.Use(app.taskWriteMiddleware()...)
+	{
+		tasks.POST("/", app.CreateTask)
+		tasks.PUT("/update/:id", app.UpdateTask)
+		tasks.DELETE("/delete/:id", app.SoftDelete)
+	}
+
+	account := r.Group("/", deprecatedRoutes(map[string]string{
+		"/login":     "/v1/login",
+		"/login/mfa": "/v1/login/mfa",
+		"/register":  "/v1/register",
+	}), app.rateLimiter("login", app.Config.RateLimit.Login))
+	{
+		account.POST("/login", app.UserLogin)
+		account.POST("/login/mfa", app.databaseOnly(), app.UserLoginMFA)
+		account.POST("/register", app.UserRegister)
+	}
+
+	me := r.Group("/me", func(c *gin.Context) {
+		setDeprecationHeaders(c, "/v1"+c.FullPath())
+		c.Next()
+	})
+	app.mountMe(me)
+}
+
+// mountMe adds the account management routes, which are the same in /v1 and
+// before it.
+func (app *Application) mountMe(me *gin.RouterGroup) {
+	me.Use(app.LoginMiddleware(), app.sessionOnly(), secureHeaders(), app.rateLimiter("write", app.Config.RateLimit.Write), app.databaseOnly())
+	{
+		me.POST("/mfa/enroll", app.EnrollMFA)
+		me.GET("/mfa/qr.png", app.MFAQRCode)
+		me.POST("/mfa/confirm", app.ConfirmMFA)
+		me.POST("/mfa/disable", app.DisableMFA)
+		me.POST("/tokens", app.CreateAPIToken)
+		me.GET("/tokens", app.ListAPITokens)
+		me.DELETE("/tokens/:id", app.RevokeAPIToken)
+	}
+}
+
+func (app *Application) taskWriteMiddleware() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		app.LoginMiddleware(),
+		secureHeaders(),
+		app.requireScope(models.ScopeTasksWrite),
+		app.rateLimiter("write", app.Config.RateLimit.Write),
+	}
+}
+
+// deprecated marks a legacy route whose replacement is successor.
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setDeprecationHeaders(c, successor)
+		c.Next()
+	}
+}
+
+// deprecatedRoutes marks the legacy routes of a group, successors maps
+// their full paths to their replacements.
+func deprecatedRoutes(successors map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setDeprecationHeaders(c, successors[c.FullPath()])
+		c.Next()
+	}
+}
+
+// setDeprecationHeaders sets the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers and links the successor, with its route parameters filled in from
+// the request.
+func setDeprecationHeaders(c *gin.Context, successor string) {
+	for _, param := range c.Params {
+		successor = strings.Replace(successor, ":"+param.Key, url.PathEscape(param.Value), 1)
+	}
+
+	c.Header("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+	c.Header("Sunset", legacySunset.Format(http.TimeFormat))
+	c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+}
+
+// traceRequest keeps probes and scrapes, which come every few seconds, out of
+// the traces.
+func traceRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+
+	return true
+}
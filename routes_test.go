@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-task/config"
+	"github.com/iamgak/go-task/docs"
+	"github.com/iamgak/go-task/models"
+	"github.com/sirupsen/logrus"
+)
+
+var ginParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+func testRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	app := &Application{Model: &models.Init{}, Config: &cfg, Logger: logrus.New()}
+	return app.InitRouter()
+}
+
+func specOperations(t *testing.T) map[string]bool {
+	t.Helper()
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	if err := json.Unmarshal(docs.Spec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	if !strings.HasPrefix(spec.OpenAPI, "3.1") {
+		t.Fatalf("openapi = %q, want 3.1.x", spec.OpenAPI)
+	}
+
+	operations := map[string]bool{}
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			operations[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	return operations
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	operations := specOperations(t)
+	for _, route := range testRouter(t).Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		if !operations[route.Method+" "+path] {
+			t.Errorf("%s %s is not in docs/openapi.json", route.Method, path)
+		}
+	}
+}
+
+func TestEveryDocumentedOperationIsRouted(t *testing.T) {
+	routes := map[string]bool{}
+	for _, route := range testRouter(t).Routes() {
+		routes[route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}")] = true
+	}
+
+	for operation := range specOperations(t) {
+		if !routes[operation] {
+			t.Errorf("docs/openapi.json documents %s, which has no route", operation)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// Supervisor runs the long lived parts of the process, the HTTP server and
+// the background workers. They all share one context, which is cancelled
+// on shutdown or as soon as any of them fails, so nothing outlives the rest.
+type Supervisor struct {
+	group  *errgroup.Group
+	ctx    context.Context
+	logger *logrus.Logger
+}
+
+func NewSupervisor(ctx context.Context, logger *logrus.Logger) *Supervisor {
+	group, ctx := errgroup.WithContext(ctx)
+	return &Supervisor{group: group, ctx: ctx, logger: logger}
+}
+
+// Go starts a worker. It should return once ctx is done, nil on a clean
+// stop and an error if it can't carry on.
+func (s *Supervisor) Go(name string, run func(ctx context.Context) error) {
+	s.group.Go(func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("worker %s panicked: %v", name, r)
+				s.logger.Error(err)
+			}
+		}()
+
+		s.logger.Info("worker started: ", name)
+		started := time.Now()
+		err = run(s.ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			s.logger.Error("worker ", name, " failed: ", err)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		s.logger.Info("worker stopped: ", name, " after ", time.Since(started).Round(time.Second))
+		return nil
+	})
+}
+
+// Wait blocks until every worker returned and reports the first failure.
+func (s *Supervisor) Wait() error {
+	return s.group.Wait()
+}